@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// Sorted set of product IDs scored by last access time (unix millis)
+const redisLastAccessKey = "products:last_access"
+
+// Hash of product ID to its hit count when it was deleted, kept without a TTL
+// for analytics when DELETED_HITS is "archive"
+const redisArchivedHitsKey = "products:archived_hits"
+
+// What happens to a deleted product's hit count
+const (
+	deletedHitsDelete  = "delete"  // dropped with the rest of its cache keys
+	deletedHitsArchive = "archive" // copied into products:archived_hits first
+	deletedHitsEvent   = "event"   // published as a final_hits event first
+)
+
+// ProductStats is the per-product access summary
+type ProductStats struct {
+	ID           int        `json:"id"`
+	Hits         int64      `json:"hits"`
+	Popularity   int64      `json:"popularity"`
+	LastAccessed *time.Time `json:"last_accessed,omitempty"`
+}
+
+// Record a GET of a product: bump its popularity and last-access time in one round trip
+func recordProductAccess(ctx context.Context, id int) {
+	member := strconv.Itoa(id)
+	now := clock.Now()
+	redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZIncrBy(ctx, redisPopularityKey, 1, member)
+		pipe.ZAdd(ctx, redisLastAccessKey, &redis.Z{Score: float64(now.UnixMilli()), Member: member})
+		return nil
+	})
+}
+
+// Handler - GET /product/{id}/stats
+func productStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	if err := rlockDB(ctx); err != nil {
+		writeDBLockError(w)
+		return
+	}
+	_, ok := fakeProductDB[id]
+	fakeDBLock.RUnlock()
+	if !ok {
+		writeProductNotFound(w, r, id)
+		return
+	}
+
+	member := strconv.Itoa(id)
+	var (
+		hits       *redis.StringCmd
+		popularity *redis.FloatCmd
+		lastAccess *redis.FloatCmd
+	)
+	redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		hits = pipe.Get(ctx, redisProductHitsKey(id))
+		popularity = pipe.ZScore(ctx, redisPopularityKey, member)
+		lastAccess = pipe.ZScore(ctx, redisLastAccessKey, member)
+		return nil
+	})
+
+	stats := ProductStats{ID: id}
+	stats.Hits, _ = hits.Int64()
+	if score, err := popularity.Result(); err == nil {
+		stats.Popularity = int64(score)
+	}
+	if score, err := lastAccess.Result(); err == nil {
+		t := time.UnixMilli(int64(score)).UTC()
+		stats.LastAccessed = &t
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// Utility - per DELETED_HITS, preserve a product's hit count before a delete
+// removes its hits key. Hits landing between the read and the delete are
+// lost, as they would be anyway.
+func saveDeletedHits(ctx context.Context, id int) {
+	if config.DeletedHits == deletedHitsDelete {
+		return
+	}
+	hits, err := redisClient.Get(ctx, redisProductHitsKey(id)).Int64()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("Could not read hits of deleted product %d: %v", id, err)
+		}
+		return
+	}
+	switch config.DeletedHits {
+	case deletedHitsArchive:
+		if err := redisClient.HSet(ctx, redisArchivedHitsKey, strconv.Itoa(id), hits).Err(); err != nil {
+			log.Printf("Could not archive hits of deleted product %d: %v", id, err)
+		}
+	case deletedHitsEvent:
+		productEvents.publish(productEvent{Type: "final_hits", ID: id, Hits: &hits})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetUpdatesLastAccessed(t *testing.T) {
+	_, h := setupTest(t)
+
+	var stats ProductStats
+	decodeBody(t, do(h, "GET", "/product/1/stats", ""), &stats)
+	if stats.LastAccessed != nil {
+		t.Fatalf("last_accessed before any GET: got %v", stats.LastAccessed)
+	}
+
+	before := time.Now().Truncate(time.Millisecond)
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET: got %d", w.Code)
+	}
+	decodeBody(t, do(h, "GET", "/product/1/stats", ""), &stats)
+	if stats.LastAccessed == nil || stats.LastAccessed.Before(before) || stats.LastAccessed.After(time.Now()) {
+		t.Fatalf("last_accessed after a GET at %v: got %v", before, stats.LastAccessed)
+	}
+	first := *stats.LastAccessed
+
+	time.Sleep(5 * time.Millisecond)
+	do(h, "GET", "/product/1", "")
+	decodeBody(t, do(h, "GET", "/product/1/stats", ""), &stats)
+	if !stats.LastAccessed.After(first) {
+		t.Fatalf("last_accessed not advanced by a second GET: %v then %v", first, stats.LastAccessed)
+	}
+	if stats.Popularity != 2 {
+		t.Fatalf("popularity after two GETs: got %d", stats.Popularity)
+	}
+}
+
+func TestDeletedHitsPolicies(t *testing.T) {
+	for _, policy := range []string{deletedHitsDelete, deletedHitsArchive, deletedHitsEvent} {
+		mr, h := setupTest(t)
+		config.DeletedHits = policy
+		config.EventBufferSize = 100
+		sub := productEvents.subscribe()
+
+		do(h, "GET", "/product/1", "")
+		for i := 0; i < 4; i++ {
+			do(h, "GET", "/product/1", "")
+		}
+		final, err := mr.Get(redisProductHitsKey(1))
+		if err != nil {
+			t.Fatalf("%s: no hits recorded: %v", policy, err)
+		}
+		if w := do(h, "DELETE", "/product/1", ""); w.Code != http.StatusNoContent {
+			t.Fatalf("%s: DELETE: got %d", policy, w.Code)
+		}
+		if mr.Exists(redisProductHitsKey(1)) {
+			t.Fatalf("%s: hits key survived the delete", policy)
+		}
+
+		archived := mr.HGet(redisArchivedHitsKey, "1")
+		var finalHits []int64
+	drain:
+		for {
+			select {
+			case ev := <-sub.events:
+				if ev.Type == "final_hits" && ev.ID == 1 {
+					finalHits = append(finalHits, *ev.Hits)
+				}
+			default:
+				break drain
+			}
+		}
+		productEvents.unsubscribe(sub)
+
+		switch policy {
+		case deletedHitsDelete:
+			if archived != "" || len(finalHits) != 0 {
+				t.Fatalf("delete: archived %q, events %v", archived, finalHits)
+			}
+		case deletedHitsArchive:
+			if archived != final || len(finalHits) != 0 {
+				t.Fatalf("archive: archived %q, events %v, want %s archived", archived, finalHits, final)
+			}
+		case deletedHitsEvent:
+			if archived != "" || len(finalHits) != 1 || strconv.FormatInt(finalHits[0], 10) != final {
+				t.Fatalf("event: archived %q, events %v, want one with %s", archived, finalHits, final)
+			}
+		}
+	}
+}
+
+func TestLastAccessedFollowsClock(t *testing.T) {
+	_, h := setupTest(t)
+	at := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock = fixedClock{at}
+
+	do(h, "GET", "/product/1", "")
+	var stats ProductStats
+	decodeBody(t, do(h, "GET", "/product/1/stats", ""), &stats)
+	if stats.LastAccessed == nil || !stats.LastAccessed.Equal(at) {
+		t.Fatalf("last_accessed: got %v, want the clock's %v", stats.LastAccessed, at)
+	}
+}
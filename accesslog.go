@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Middleware - with ACCESS_LOG, log each request once it has been answered.
+// Under LOG_SAMPLING only LOG_SAMPLE_RATE of successful requests answered
+// within SLOW_REQUEST_THRESHOLD are logged; errors (status >= 400) and slow
+// requests always are.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.AccessLog {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		elapsed := time.Since(start)
+		if !shouldLogRequest(sw.status, elapsed) {
+			return
+		}
+		slog.Info("request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.status),
+			slog.Duration("duration", elapsed),
+			slog.String("client_ip", clientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+// Utility - whether a request answered with status after elapsed is logged
+func shouldLogRequest(status int, elapsed time.Duration) bool {
+	if !config.LogSampling || status >= http.StatusBadRequest {
+		return true
+	}
+	if config.SlowRequestThreshold > 0 && elapsed >= config.SlowRequestThreshold {
+		return true
+	}
+	return rand.Float64() < config.LogSampleRate
+}
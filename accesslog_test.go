@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Utility - capture the default slog logger's output for the test
+func captureSlog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	_, h := setupTest(t)
+	config.AccessLog = true
+	config.LogSampling = true
+	config.LogSampleRate = 0
+	logs := captureSlog(t)
+
+	for i := 0; i < 10; i++ {
+		do(h, "GET", "/product/1", "")
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("fast successes logged at LOG_SAMPLE_RATE=0:\n%s", logs)
+	}
+	do(h, "GET", "/product/99", "")
+	if !strings.Contains(logs.String(), "status=404") {
+		t.Fatalf("error not logged:\n%s", logs)
+	}
+
+	config.LogSampleRate = 1
+	logs.Reset()
+	do(h, "GET", "/product/1", "")
+	if !strings.Contains(logs.String(), "status=200") {
+		t.Fatalf("success not logged at LOG_SAMPLE_RATE=1:\n%s", logs)
+	}
+}
+
+func TestShouldLogRequest(t *testing.T) {
+	setupTest(t)
+	config.LogSampling = true
+	config.LogSampleRate = 0
+	config.SlowRequestThreshold = time.Second
+
+	for _, c := range []struct {
+		status  int
+		elapsed time.Duration
+		want    bool
+	}{
+		{http.StatusOK, time.Millisecond, false},
+		{http.StatusOK, 2 * time.Second, true},
+		{http.StatusBadRequest, time.Millisecond, true},
+		{http.StatusServiceUnavailable, time.Millisecond, true},
+	} {
+		if got := shouldLogRequest(c.status, c.elapsed); got != c.want {
+			t.Errorf("status %d after %v: got %v, want %v", c.status, c.elapsed, got, c.want)
+		}
+	}
+	config.LogSampling = false
+	if !shouldLogRequest(http.StatusOK, time.Millisecond) {
+		t.Error("fast success not logged without LOG_SAMPLING")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// Middleware - require the admin bearer token on /admin routes. Without an
+// ADMIN_TOKEN configured the admin API is disabled entirely.
+func adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken == "" {
+			http.Error(w, "Admin API disabled", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminProductView is an admin read of a product, noting where it came from
+type adminProductView struct {
+	Product Product `json:"product"`
+	Source  string  `json:"source"` // "db" or "cache"
+}
+
+// Handler - GET /admin/product/{id}
+// Operators debugging stale-cache reports need the source of truth, so with
+// ADMIN_READS_FROM_DB (the default) this never consults the cache. With
+// Accept: application/vnd.cache-raw+json it instead returns the cache entry
+// exactly as stored, for diagnosing serialization issues.
+func adminGetProductHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+	if acceptsExactly(r.Header.Get("Accept"), contentTypeCacheRaw) {
+		writeRawCacheEntry(w, r, id)
+		return
+	}
+
+	view := adminProductView{Source: "db"}
+	if config.AdminReadsFromDB {
+		view.Product, err = readProductFromDB(ctx, id)
+	} else {
+		view.Product, view.Source, err = peekProductWithSource(ctx, id)
+	}
+	if errors.Is(err, errProductNotFound) {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeDBLockError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// Utility - write a product's cache entry byte for byte, with no decoding
+func writeRawCacheEntry(w http.ResponseWriter, r *http.Request, id int) {
+	redisKey := redisProductKey(id)
+	raw, err := redisClient.Get(r.Context(), redisKey).Bytes()
+	if err == redis.Nil {
+		http.Error(w, "Product not cached", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Raw cache read of %s failed: %v", redisKey, err)
+		http.Error(w, "Cache unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeCacheRaw)
+	w.Header().Set("X-Cache-Key", redisKey)
+	w.Write(raw)
+}
+
+// Longest ttl_seconds POST /admin/cache/extend accepts, whatever
+// MAX_CACHE_TTL is: 30 days
+const maxExtendTTLSeconds = 30 * 24 * 60 * 60
+
+type extendCacheRequest struct {
+	IDs        []int `json:"ids"`
+	TTLSeconds int   `json:"ttl_seconds"`
+}
+
+type extendCacheResponse struct {
+	Extended   []int `json:"extended"`
+	Absent     []int `json:"absent"`
+	TTLSeconds int   `json:"ttl_seconds"`
+}
+
+// Handler - POST /admin/cache/extend
+func extendCacheHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var input extendCacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(input.IDs) == 0 || input.TTLSeconds <= 0 {
+		http.Error(w, "ids and a positive ttl_seconds are required", http.StatusBadRequest)
+		return
+	}
+	if input.TTLSeconds > maxExtendTTLSeconds {
+		http.Error(w, fmt.Sprintf("ttl_seconds must be at most %d", maxExtendTTLSeconds), http.StatusBadRequest)
+		return
+	}
+	// Clamp in seconds, before converting, so no value can overflow
+	seconds := input.TTLSeconds
+	if maxSeconds := int(config.MaxCacheTTL / time.Second); config.MaxCacheTTL > 0 && seconds > maxSeconds {
+		seconds = maxSeconds
+	}
+	ttl := time.Duration(seconds) * time.Second
+
+	// Look the keys up first so tombstones aren't kept alive
+	gets := make([]*redis.StringCmd, len(input.IDs))
+	redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range input.IDs {
+			gets[i] = pipe.Get(ctx, redisProductKey(id))
+		}
+		return nil
+	})
+
+	resp := extendCacheResponse{Extended: []int{}, Absent: []int{}, TTLSeconds: int(ttl / time.Second)}
+	var present []int
+	for i, id := range input.IDs {
+		if data, err := gets[i].Result(); err == nil && data != redisTombstoneValue {
+			present = append(present, id)
+		} else {
+			resp.Absent = append(resp.Absent, id)
+		}
+	}
+
+	expires := make([]*redis.BoolCmd, len(present))
+	redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range present {
+			expires[i] = pipe.Expire(ctx, redisProductKey(id), ttl)
+			pipe.Expire(ctx, redisProductHitsKey(id), ttl)
+		}
+		return nil
+	})
+	for i, id := range present {
+		// The key may have expired between the lookup and the Expire
+		if ok, _ := expires[i].Result(); ok {
+			resp.Extended = append(resp.Extended, id)
+		} else {
+			resp.Absent = append(resp.Absent, id)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Handler - POST /admin/cleaner/pause
+func pauseCleanerHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.CompareAndSwapInt32(&cleanerPaused, 0, 1) {
+		log.Println("Cache cleaner paused via admin API")
+	}
+	writeCleanerState(w)
+}
+
+// Handler - POST /admin/cleaner/resume
+func resumeCleanerHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.CompareAndSwapInt32(&cleanerPaused, 1, 0) {
+		log.Println("Cache cleaner resumed via admin API")
+	}
+	writeCleanerState(w)
+}
+
+func writeCleanerState(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"paused": atomic.LoadInt32(&cleanerPaused) == 1})
+}
+
+// cacheSizeResponse is the body of GET /admin/cache/size
+type cacheSizeResponse struct {
+	Keys           int   `json:"keys"`            // everything under the product cache prefix
+	ProductEntries int   `json:"product_entries"` // product values of the current schema
+	MemoryBytes    int64 `json:"memory_bytes_estimate,omitempty"`
+	MemorySampled  int   `json:"memory_sampled_keys,omitempty"`
+}
+
+// Handler - GET /admin/cache/size[?memory=true]
+// Counts the product cache keys with SCAN, which never blocks Redis for
+// long, so the count is approximate while keys come and go. With memory=true
+// it also samples MEMORY USAGE of up to CACHE_SIZE_MEMORY_SAMPLES keys,
+// picked uniformly over the scan, and extrapolates to all of them.
+func cacheSizeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	withMemory := r.URL.Query().Get("memory") == "true"
+	var resp cacheSizeResponse
+	var sample []string
+	var cursor uint64
+	for {
+		keys, next, err := redisClient.Scan(ctx, cursor, redisProductKeyPrefix+"*", 100).Result()
+		if err != nil {
+			log.Printf("Cache size scan failed: %v", err)
+			http.Error(w, "Cache unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		for _, key := range keys {
+			resp.Keys++
+			if isProductValueKey(key, config.CacheSchemaVersion) {
+				resp.ProductEntries++
+			}
+			if !withMemory || config.CacheSizeMemorySamples <= 0 {
+				continue
+			}
+			// Reservoir sampling: every key scanned is equally likely to be kept
+			if len(sample) < config.CacheSizeMemorySamples {
+				sample = append(sample, key)
+			} else if i := rand.Intn(resp.Keys); i < len(sample) {
+				sample[i] = key
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	if len(sample) > 0 {
+		usages := make([]*redis.IntCmd, len(sample))
+		redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range sample {
+				usages[i] = pipe.MemoryUsage(ctx, key)
+			}
+			return nil
+		})
+		var total int64
+		for _, cmd := range usages {
+			// Keys that expired since the scan don't count
+			if n, err := cmd.Result(); err == nil {
+				total += n
+				resp.MemorySampled++
+			}
+		}
+		if resp.MemorySampled > 0 {
+			resp.MemoryBytes = total / int64(resp.MemorySampled) * int64(resp.Keys)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestExtendCacheReportsExtendedAndAbsent(t *testing.T) {
+	mr, h := setupTest(t)
+	config.AdminToken = "secret"
+	config.MaxCacheTTL = time.Hour
+
+	do(h, "GET", "/product/1", "")
+	do(h, "GET", "/product/2", "")
+	w := do(h, "POST", "/admin/cache/extend", `{"ids":[1,2,3,99],"ttl_seconds":86400}`, "Authorization", "Bearer secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("extend: got %d %s", w.Code, w.Body.String())
+	}
+	var resp extendCacheResponse
+	decodeBody(t, w, &resp)
+	if !reflect.DeepEqual(resp.Extended, []int{1, 2}) || !reflect.DeepEqual(resp.Absent, []int{3, 99}) {
+		t.Fatalf("extend: got extended %v absent %v", resp.Extended, resp.Absent)
+	}
+	if resp.TTLSeconds != 3600 {
+		t.Fatalf("ttl_seconds not clamped to MAX_CACHE_TTL: got %d", resp.TTLSeconds)
+	}
+	for _, id := range []int{1, 2} {
+		if ttl := mr.TTL(redisProductKey(id)); ttl != time.Hour {
+			t.Fatalf("product %d TTL: got %v, want 1h", id, ttl)
+		}
+	}
+	if mr.Exists(redisProductKey(3)) {
+		t.Fatal("extend created a key for an uncached product")
+	}
+}
+
+func TestExtendCacheRejectsHugeTTL(t *testing.T) {
+	mr, h := setupTest(t)
+	config.AdminToken = "secret"
+	do(h, "GET", "/product/1", "")
+
+	for _, maxTTL := range []time.Duration{time.Hour, 0} {
+		config.MaxCacheTTL = maxTTL
+		// Overflows to a negative time.Duration if converted unchecked
+		w := do(h, "POST", "/admin/cache/extend", `{"ids":[1],"ttl_seconds":9223372037}`, "Authorization", "Bearer secret")
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("MAX_CACHE_TTL=%v: huge ttl_seconds got %d, want 400", maxTTL, w.Code)
+		}
+		if !mr.Exists(redisProductKey(1)) {
+			t.Fatalf("MAX_CACHE_TTL=%v: huge ttl_seconds deleted the entry", maxTTL)
+		}
+	}
+	if w := do(h, "POST", "/admin/cache/extend", `{"ids":[1],"ttl_seconds":-5}`, "Authorization", "Bearer secret"); w.Code != http.StatusBadRequest {
+		t.Fatalf("negative ttl_seconds: got %d, want 400", w.Code)
+	}
+}
+
+func TestExtendCacheRequiresAdminToken(t *testing.T) {
+	_, h := setupTest(t)
+	body := `{"ids":[1],"ttl_seconds":60}`
+
+	if w := do(h, "POST", "/admin/cache/extend", body); w.Code != http.StatusForbidden {
+		t.Fatalf("without ADMIN_TOKEN: got %d, want 403", w.Code)
+	}
+	config.AdminToken = "secret"
+	if w := do(h, "POST", "/admin/cache/extend", body, "Authorization", "Bearer wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("with a wrong token: got %d, want 401", w.Code)
+	}
+}
+
+func TestAdminReadReturnsDBDataOverStaleCache(t *testing.T) {
+	_, h := setupTest(t)
+	config.AdminToken = "secret"
+	cacheStaleApple(t)
+
+	var view adminProductView
+	decodeBody(t, do(h, "GET", "/admin/product/1", "", "Authorization", "Bearer secret"), &view)
+	if view.Source != "db" || view.Product.Name != "Apple" {
+		t.Fatalf("admin read: got %+v, want the DB copy", view)
+	}
+
+	config.AdminReadsFromDB = false
+	decodeBody(t, do(h, "GET", "/admin/product/1", "", "Authorization", "Bearer secret"), &view)
+	if view.Source != "cache" || view.Product.Name != "Old Apple" {
+		t.Fatalf("admin read with ADMIN_READS_FROM_DB=false: got %+v, want the cached copy", view)
+	}
+}
+
+func TestRawCacheEntryForAdminsOnly(t *testing.T) {
+	mr, h := setupTest(t)
+	config.AdminToken = "secret"
+	stored := `{"id":1,"name":"Apple","price":100,"version":1,"stray":true}`
+	mr.Set(redisProductKey(1), stored)
+
+	w := do(h, "GET", "/admin/product/1", "", "Authorization", "Bearer secret", "Accept", contentTypeCacheRaw)
+	if w.Code != http.StatusOK || w.Body.String() != stored || w.Header().Get("Content-Type") != contentTypeCacheRaw {
+		t.Fatalf("admin raw read: got %d %q %q", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	if w := do(h, "GET", "/admin/product/2", "", "Authorization", "Bearer secret", "Accept", contentTypeCacheRaw); w.Code != http.StatusNotFound {
+		t.Fatalf("admin raw read of an uncached product: got %d", w.Code)
+	}
+
+	w = do(h, "GET", "/product/1", "", "Accept", contentTypeCacheRaw)
+	if w.Code != http.StatusOK || w.Body.String() == stored {
+		t.Fatalf("public read with the raw media type: got %d %q", w.Code, w.Body)
+	}
+	var p Product
+	decodeBody(t, w, &p)
+	if p.ID != 1 || p.Name != "Apple" {
+		t.Fatalf("public read: got %+v", p)
+	}
+}
+
+func TestCacheSize(t *testing.T) {
+	mr, h := setupTest(t)
+	config.AdminToken = "secret"
+	for id := 1; id <= 3; id++ {
+		do(h, "GET", fmt.Sprintf("/product/%d", id), "")
+	}
+	mr.Set("session:1", "not a product")
+
+	if w := do(h, "GET", "/admin/cache/size", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without the admin token: got %d, want 401", w.Code)
+	}
+	var size cacheSizeResponse
+	decodeBody(t, do(h, "GET", "/admin/cache/size", "", "Authorization", "Bearer secret"), &size)
+	// Each product has its value and its hit counter
+	if size.Keys != 6 || size.ProductEntries != 3 {
+		t.Fatalf("cache size: got %+v, want 6 keys and 3 product entries", size)
+	}
+	if size.MemoryBytes != 0 {
+		t.Fatalf("memory estimate without memory=true: got %+v", size)
+	}
+}
+
+// memoryUsageHook answers MEMORY USAGE, which miniredis lacks, with bytes
+type memoryUsageHook struct{ bytes int64 }
+
+func (memoryUsageHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (memoryUsageHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (memoryUsageHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h memoryUsageHook) AfterProcessPipeline(_ context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		if usage, ok := cmd.(*redis.IntCmd); ok && cmd.Name() == "memory" {
+			usage.SetVal(h.bytes)
+			usage.SetErr(nil)
+		}
+	}
+	return nil
+}
+
+func TestCacheSizeMemoryEstimate(t *testing.T) {
+	_, h := setupTest(t)
+	config.AdminToken = "secret"
+	config.CacheSizeMemorySamples = 2
+	redisClient.AddHook(memoryUsageHook{bytes: 100})
+	for id := 1; id <= 3; id++ {
+		do(h, "GET", fmt.Sprintf("/product/%d", id), "")
+	}
+
+	var size cacheSizeResponse
+	decodeBody(t, do(h, "GET", "/admin/cache/size?memory=true", "", "Authorization", "Bearer secret"), &size)
+	// 2 of the 6 keys sampled at 100 bytes each, extrapolated to all 6
+	if size.MemorySampled != 2 || size.MemoryBytes != 600 {
+		t.Fatalf("memory estimate: got %+v, want 2 samples and 600 bytes", size)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Pending async writes, pushed on the left and taken from the right, and
+	// the ones a worker has taken but not finished
+	redisWriteQueueKey      = "writes:queue"
+	redisWriteProcessingKey = "writes:processing"
+	redisWriteStatusPrefix  = "write:"
+	// A counter ordering queued writes against synchronous ones, and per
+	// product the counter value of the last synchronous write that
+	// replaced any writes queued before it
+	redisWriteSeqKey        = "writes:seq"
+	redisWriteSupersededKey = "writes:superseded"
+
+	asyncWritePollTimeout = time.Second // how long a worker blocks waiting for a job
+	asyncWriteRetryDelay  = time.Second // pause after a job failed transiently
+
+	asyncWritePending = "pending"
+	asyncWriteDone    = "done"
+	asyncWriteFailed  = "failed"
+)
+
+// Status records of async writes, by write ID
+var writeStatusCache = registerCache("writes", redisWriteStatusPrefix, func() time.Duration {
+	return config.AsyncWriteStatusTTL
+})
+
+// asyncWriteJob is a queued PUT
+type asyncWriteJob struct {
+	ID      string  `json:"id"`
+	Seq     int64   `json:"seq,omitempty"` // from redisWriteSeqKey
+	Product Product `json:"product"`
+}
+
+// errWriteSuperseded fails a queued write a later synchronous write replaced
+var errWriteSuperseded = errors.New("superseded by a later write")
+
+// Forget ARGV[1]'s supersede marker once a write queued after it (ARGV[2])
+// has been applied; no write it could apply to is left
+var clearSupersededScript = redis.NewScript(`
+local seq = redis.call('HGET', KEYS[1], ARGV[1])
+if seq and tonumber(seq) < tonumber(ARGV[2]) then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// AsyncWriteStatus is the body of GET /writes/{id}
+type AsyncWriteStatus struct {
+	ID        string     `json:"id"`
+	ProductID int        `json:"product_id"`
+	State     string     `json:"state"` // "pending", "done" or "failed"
+	Error     string     `json:"error,omitempty"`
+	Product   *Product   `json:"product,omitempty"` // as stored, once done
+	QueuedAt  time.Time  `json:"queued_at"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Queue a validated product write and record it as pending
+func enqueueProductWrite(ctx context.Context, input Product) (AsyncWriteStatus, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return AsyncWriteStatus{}, err
+	}
+	seq, err := redisClient.Incr(ctx, redisWriteSeqKey).Result()
+	if err != nil {
+		return AsyncWriteStatus{}, err
+	}
+	job := asyncWriteJob{ID: hex.EncodeToString(b[:]), Seq: seq, Product: input}
+	status := AsyncWriteStatus{ID: job.ID, ProductID: input.ID, State: asyncWritePending, QueuedAt: clock.Now()}
+	rawJob, _ := json.Marshal(job)
+	rawStatus, _ := json.Marshal(status)
+	// Status first, so a worker finishing the job straight away can't have
+	// its "done" overwritten by "pending"
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, writeStatusCache.key(job.ID), rawStatus, writeStatusCache.TTL())
+		pipe.LPush(ctx, redisWriteQueueKey, rawJob)
+		return nil
+	})
+	return status, err
+}
+
+// Background goroutine - apply queued writes one at a time. A job stays in
+// the processing list until its outcome is recorded, and on startup
+// leftovers from a worker that died mid-job are requeued, so every write is
+// applied at least once.
+func runAsyncWriteWorker(ctx context.Context) {
+	requeueUnfinishedWrites(ctx)
+	for ctx.Err() == nil {
+		raw, err := redisClient.BRPopLPush(ctx, redisWriteQueueKey, redisWriteProcessingKey, asyncWritePollTimeout).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Async write queue error: %v", err)
+				sleepCtx(ctx, asyncWriteRetryDelay)
+			}
+			continue
+		}
+		applyQueuedWrite(ctx, raw)
+	}
+}
+
+func requeueUnfinishedWrites(ctx context.Context) {
+	for {
+		err := redisClient.RPopLPush(ctx, redisWriteProcessingKey, redisWriteQueueKey).Err()
+		if errors.Is(err, redis.Nil) {
+			return
+		}
+		if err != nil {
+			log.Printf("Could not requeue unfinished async writes: %v", err)
+			return
+		}
+		metrics.IncrCounter("async_writes_requeued_total", nil)
+	}
+}
+
+// Apply one job taken from the queue and record its outcome. A busy DB puts
+// the job back at the head of the queue to be retried.
+func applyQueuedWrite(ctx context.Context, raw string) {
+	var job asyncWriteJob
+	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+		log.Printf("Dropping malformed async write: %v", err)
+		redisClient.LRem(ctx, redisWriteProcessingKey, 1, raw)
+		return
+	}
+
+	updated, err := saveProductUnless(ctx, job.Product, func() bool {
+		return queuedWriteSuperseded(ctx, job)
+	})
+	if errors.Is(err, errDBLockTimeout) || errors.Is(err, context.Canceled) {
+		redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LRem(ctx, redisWriteProcessingKey, 1, raw)
+			pipe.RPush(ctx, redisWriteQueueKey, raw)
+			return nil
+		})
+		sleepCtx(ctx, asyncWriteRetryDelay)
+		return
+	}
+
+	status := AsyncWriteStatus{ID: job.ID, ProductID: job.Product.ID, State: asyncWriteDone}
+	if prev, err := readWriteStatus(ctx, job.ID); err == nil {
+		status.QueuedAt = prev.QueuedAt
+	}
+	now := clock.Now()
+	status.AppliedAt = &now
+	switch {
+	case errors.Is(err, errWriteSuperseded):
+		status.State, status.Error = asyncWriteFailed, err.Error()
+		metrics.IncrCounter("async_writes_total", Labels{"result": "superseded"})
+	case err != nil:
+		status.State, status.Error = asyncWriteFailed, err.Error()
+		metrics.IncrCounter("async_writes_total", Labels{"result": "failed"})
+	default:
+		status.Product = &updated
+		metrics.IncrCounter("async_writes_total", Labels{"result": "done"})
+		clearSupersededScript.Run(ctx, redisClient, []string{redisWriteSupersededKey}, job.Product.ID, job.Seq)
+	}
+	rawStatus, _ := json.Marshal(status)
+	redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, writeStatusCache.key(job.ID), rawStatus, writeStatusCache.TTL())
+		pipe.LRem(ctx, redisWriteProcessingKey, 1, raw)
+		return nil
+	})
+}
+
+// Record that a synchronous write (a bulk update or delete) just replaced
+// ids, so writes queued before it aren't applied over it later. Only needed
+// while writes are queued. Callers hold fakeDBLock for writing, which
+// queuedWriteSuperseded is checked under too.
+func supersedeQueuedWrites(ctx context.Context, ids []int) {
+	if !config.AsyncWrites || len(ids) == 0 {
+		return
+	}
+	var queued, processing *redis.IntCmd
+	redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		queued = pipe.LLen(ctx, redisWriteQueueKey)
+		processing = pipe.LLen(ctx, redisWriteProcessingKey)
+		return nil
+	})
+	if queued.Val() == 0 && processing.Val() == 0 && queued.Err() == nil && processing.Err() == nil {
+		return
+	}
+	seq, err := redisClient.Incr(ctx, redisWriteSeqKey).Result()
+	if err != nil {
+		log.Printf("Could not mark queued writes superseded: %v", err)
+		return
+	}
+	fields := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		fields[strconv.Itoa(id)] = seq
+	}
+	redisClient.HSet(ctx, redisWriteSupersededKey, fields)
+}
+
+// Whether a synchronous write replaced job's product after job was queued
+func queuedWriteSuperseded(ctx context.Context, job asyncWriteJob) bool {
+	seq, err := redisClient.HGet(ctx, redisWriteSupersededKey, strconv.Itoa(job.Product.ID)).Int64()
+	return err == nil && seq > job.Seq
+}
+
+func readWriteStatus(ctx context.Context, id string) (AsyncWriteStatus, error) {
+	var status AsyncWriteStatus
+	raw, err := redisClient.Get(ctx, writeStatusCache.key(id)).Bytes()
+	if err != nil {
+		return status, err
+	}
+	err = json.Unmarshal(raw, &status)
+	return status, err
+}
+
+// Utility - wait for d or until ctx is done
+func sleepCtx(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// Utility - where the status of an async write can be polled
+func writeStatusLocation(r *http.Request, id string) string {
+	path := "/writes/" + id
+	if config.LocationStyle == locationAbsolute {
+		return requestOrigin(r) + path
+	}
+	return path
+}
+
+// Handler - GET /writes/{id}
+func writeStatusHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := readWriteStatus(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, redis.Nil) {
+		http.Error(w, "Write not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Write status lookup failed: %v", err)
+		http.Error(w, "Could not look up write", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if publicProjection(w, r) {
+		json.NewEncoder(w).Encode(projectWrapped(status, "product"))
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// Answer a PUT under ASYNC_WRITES: validate now, queue the write and return
+// 202 with the status URL in Location. Rules that depend on the stored data,
+// like MAX_CATALOG_VALUE, are checked when the write is applied.
+func acceptProductWrite(w http.ResponseWriter, r *http.Request, input Product) {
+	input, err := validateProduct(input)
+	if writeValidationError(w, r, err) {
+		return
+	}
+	status, err := enqueueProductWrite(r.Context(), input)
+	if writeTimeoutError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Could not queue write: %v", err)
+		http.Error(w, "Could not queue write", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", writeStatusLocation(r, status.ID))
+	projected := publicProjection(w, r)
+	w.WriteHeader(http.StatusAccepted)
+	if projected {
+		json.NewEncoder(w).Encode(projectWrapped(status, "product"))
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Run the async write worker until the test ends
+func startAsyncWriteWorker(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runAsyncWriteWorker(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// Poll a write's status until it leaves pending, or fail after timeout
+func waitForWrite(t *testing.T, h http.Handler, location string, timeout time.Duration) AsyncWriteStatus {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		var status AsyncWriteStatus
+		decodeBody(t, do(h, "GET", location, ""), &status)
+		if status.State != asyncWritePending {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("write %s still pending after %v", status.ID, timeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAsyncWriteAcceptedThenApplied(t *testing.T) {
+	_, h := setupTest(t)
+	config.AsyncWrites = true
+
+	w := do(h, "PUT", "/product/1", `{"id":1,"name":"Apricot","price":90}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("async PUT: got %d, want 202", w.Code)
+	}
+	var accepted AsyncWriteStatus
+	decodeBody(t, w, &accepted)
+	location := w.Header().Get("Location")
+	if accepted.State != asyncWritePending || location != "/writes/"+accepted.ID {
+		t.Fatalf("202: status %+v, Location %q", accepted, location)
+	}
+	if p, _ := dbProduct(1); p.Name != "Apple" {
+		t.Fatalf("write applied before any worker ran: %+v", p)
+	}
+	if w := do(h, "PUT", "/product/1", `{"id":1,"name":"Apricot","price":-1}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid async PUT: got %d, want 400 up front", w.Code)
+	}
+
+	startAsyncWriteWorker(t)
+	status := waitForWrite(t, h, location, 2*time.Second)
+	if status.State != asyncWriteDone || status.Product == nil || status.Product.Version != 2 || status.AppliedAt == nil {
+		t.Fatalf("applied write: %+v", status)
+	}
+	var p Product
+	decodeBody(t, do(h, "GET", "/product/1", ""), &p)
+	if p.Name != "Apricot" || p.Version != 2 {
+		t.Fatalf("read after the write applied: %+v", p)
+	}
+
+	if w := do(h, "GET", "/writes/0123abcd", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown write: got %d", w.Code)
+	}
+}
+
+func TestUnfinishedAsyncWriteRequeuedOnStart(t *testing.T) {
+	_, h := setupTest(t)
+	config.AsyncWrites = true
+	ctx := context.Background()
+
+	status, err := enqueueProductWrite(ctx, Product{ID: 2, Name: "Plantain", Price: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A worker took the job and died before finishing it
+	raw, err := redisClient.RPopLPush(ctx, redisWriteQueueKey, redisWriteProcessingKey).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job asyncWriteJob
+	if err := json.Unmarshal([]byte(raw), &job); err != nil || job.ID != status.ID {
+		t.Fatalf("processing list holds %s, %v", raw, err)
+	}
+
+	startAsyncWriteWorker(t)
+	if got := waitForWrite(t, h, "/writes/"+status.ID, 2*time.Second); got.State != asyncWriteDone {
+		t.Fatalf("requeued write: %+v", got)
+	}
+	if p, _ := dbProduct(2); p.Name != "Plantain" {
+		t.Fatalf("requeued write not applied: %+v", p)
+	}
+	if n := redisClient.LLen(ctx, redisWriteProcessingKey).Val(); n != 0 {
+		t.Fatalf("%d jobs left in the processing list", n)
+	}
+}
+
+func TestAsyncWriteQueuedBeforeDeleteNotApplied(t *testing.T) {
+	_, h := setupTest(t)
+	config.AsyncWrites = true
+
+	w := do(h, "PUT", "/product/1", `{"id":1,"name":"Apricot","price":90}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("async PUT: got %d, want 202", w.Code)
+	}
+	if w := do(h, "DELETE", "/product/1", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d, want 204", w.Code)
+	}
+
+	startAsyncWriteWorker(t)
+	status := waitForWrite(t, h, w.Header().Get("Location"), 2*time.Second)
+	if _, ok := dbProduct(1); ok || status.State != asyncWriteFailed {
+		t.Fatalf("a write queued before the delete brought the product back: %+v", status)
+	}
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET after the delete: got %d, want 404", w.Code)
+	}
+}
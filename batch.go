@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	batchMaxIDs     = 100  // in the GET query string
+	batchMaxPostIDs = 1000 // in a POST body
+
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// How repeated IDs in a batch request are answered
+const (
+	batchDuplicatesDedup    = "dedup"    // each product once, in first-seen order
+	batchDuplicatesPreserve = "preserve" // one entry per requested ID, repeats included
+)
+
+// BatchResult is the body of GET and POST /products/batch
+type BatchResult struct {
+	Items   []Product `json:"items"`
+	Missing []int     `json:"missing"`
+}
+
+// OrderedBatchResult is the batch response with ?ordered=true: one item per
+// requested ID, in request order, null where the product doesn't exist
+type OrderedBatchResult struct {
+	Items   []*Product `json:"items"`
+	Missing []int      `json:"missing"`
+}
+
+// BatchStreamItem is one line of a batch streamed as ND-JSON: the product,
+// or Missing for an ID with no product. A lookup failing mid-stream ends it
+// with a line carrying only Error.
+type BatchStreamItem struct {
+	ID      int      `json:"id,omitempty"`
+	Product *Product `json:"product,omitempty"`
+	Missing bool     `json:"missing,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Fetch several products with a single MGET, falling back to the DB for
+// misses and populating the cache with them in one pipeline. ids must not
+// contain duplicates.
+func loadProducts(ctx context.Context, ids []int) (map[int]Product, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisProductKey(id)
+	}
+	cached, err := cacheMGet(ctx, keys...)
+	if err != nil {
+		cached = make([]interface{}, len(ids)) // treat as all misses
+	}
+
+	found := make(map[int]Product, len(ids))
+	var fills []cacheFill
+	for i, id := range ids {
+		data, _ := cached[i].(string)
+		if data != "" && data != redisTombstoneValue {
+			if product, err := decodeCachedProductFor(data, config.CacheSchemaVersion, id); err == nil {
+				atomic.AddInt64(&statCacheHits, 1)
+				metrics.IncrCounter("product_cache_requests_total", Labels{"result": "hit"})
+				found[id] = product
+				continue
+			}
+		}
+		atomic.AddInt64(&statCacheMisses, 1)
+		metrics.IncrCounter("product_cache_requests_total", Labels{"result": "miss"})
+
+		product, gen, err := readProductWithGeneration(ctx, id)
+		if errors.Is(err, errProductNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[id] = product
+		if data != redisTombstoneValue {
+			// Overwrite anything undecodable rather than leave it in place
+			fills = append(fills, cacheFill{product: product, gen: gen, overwrite: data != ""})
+		}
+	}
+	if len(fills) > 0 {
+		populateProductCaches(ctx, fills)
+	}
+	return found, nil
+}
+
+// Utility - parse a comma-separated list of product IDs
+func parseIDList(s string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || id <= 0 {
+			return nil, errors.New("invalid id " + strconv.Quote(part))
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Handler - GET /products/batch?ids=1,2,3
+func batchProductsHandler(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("ids")
+	if raw == "" {
+		http.Error(w, "ids is required", http.StatusBadRequest)
+		return
+	}
+	requested, err := parseIDList(raw)
+	if err != nil {
+		http.Error(w, "Invalid ids: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(requested) > batchMaxIDs {
+		http.Error(w, "Too many ids", http.StatusBadRequest)
+		return
+	}
+	serveBatch(w, r, requested)
+}
+
+// Handler - POST /products/batch with a body of {"ids": [1, 2, 3]}, for
+// batches too large for a query string
+func postBatchProductsHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		IDs []int `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(body.IDs) == 0 {
+		http.Error(w, "ids is required", http.StatusBadRequest)
+		return
+	}
+	for _, id := range body.IDs {
+		if id <= 0 {
+			http.Error(w, "Invalid ids: invalid id "+strconv.Quote(strconv.Itoa(id)), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(body.IDs) > batchMaxPostIDs {
+		http.Error(w, "Too many ids", http.StatusBadRequest)
+		return
+	}
+	serveBatch(w, r, body.IDs)
+}
+
+// Answer a validated batch, streamed as ND-JSON if the client asked for it
+func serveBatch(w http.ResponseWriter, r *http.Request, requested []int) {
+	// Only distinct IDs go to Redis, whatever the response mode
+	unique := make([]int, 0, len(requested))
+	seen := make(map[int]bool, len(requested))
+	for _, id := range requested {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	order := unique
+	if config.BatchDuplicateIDs == batchDuplicatesPreserve {
+		order = requested
+	}
+	if acceptsExactly(r.Header.Get("Accept"), contentTypeNDJSON) {
+		streamBatch(w, r, order)
+		return
+	}
+
+	found, err := loadProducts(r.Context(), unique)
+	if err != nil {
+		writeDBLockError(w)
+		return
+	}
+	if r.URL.Query().Get("ordered") == "true" {
+		serveOrderedBatch(w, r, requested, found)
+		return
+	}
+
+	result := BatchResult{Items: []Product{}, Missing: []int{}}
+	for _, id := range order {
+		if product, ok := found[id]; ok {
+			result.Items = append(result.Items, product)
+		} else if !containsInt(result.Missing, id) {
+			result.Missing = append(result.Missing, id)
+		}
+	}
+
+	projected := publicProjection(w, r)
+	if r.Method == http.MethodGet {
+		// Over the versions of the products found and the IDs missing, so an
+		// update, a delete or a creation within the set changes it
+		etag := collectionETag(result.Items, fmt.Sprintf("batch:%v:%t", result.Missing, projected))
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if projected {
+		json.NewEncoder(w).Encode(projectWrapped(result, "items"))
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// Answer a batch with ?ordered=true, so clients can zip the items with the
+// IDs they sent: every requested ID gets a slot, repeats included
+func serveOrderedBatch(w http.ResponseWriter, r *http.Request, requested []int, found map[int]Product) {
+	result := OrderedBatchResult{Items: make([]*Product, len(requested)), Missing: []int{}}
+	var present []Product
+	for i, id := range requested {
+		if product, ok := found[id]; ok {
+			result.Items[i] = &product
+			present = append(present, product)
+		} else if !containsInt(result.Missing, id) {
+			result.Missing = append(result.Missing, id)
+		}
+	}
+
+	projected := publicProjection(w, r)
+	if r.Method == http.MethodGet {
+		etag := collectionETag(present, fmt.Sprintf("batch-ordered:%v:%t", requested, projected))
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if projected {
+		json.NewEncoder(w).Encode(projectWrapped(result, "items"))
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// Stream a batch as ND-JSON, one line per entry of order, loading it
+// BATCH_STREAM_CHUNK IDs (one MGET) at a time and flushing after each chunk
+// so a large batch starts arriving before all of it has been looked up
+func streamBatch(w http.ResponseWriter, r *http.Request, order []int) {
+	ctx := r.Context()
+	chunkSize := config.BatchStreamChunk
+	if chunkSize <= 0 {
+		chunkSize = len(order)
+	}
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	projected := publicProjection(w, r)
+	found := make(map[int]Product, len(order))
+	loaded := make(map[int]bool, len(order))
+	for start := 0; start < len(order); start += chunkSize {
+		end := start + chunkSize
+		if end > len(order) {
+			end = len(order)
+		}
+		chunk := order[start:end]
+		var ids []int
+		for _, id := range chunk {
+			if !loaded[id] {
+				loaded[id] = true
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > 0 {
+			got, err := loadProducts(ctx, ids)
+			if err != nil {
+				if start == 0 {
+					writeDBLockError(w) // nothing sent yet, so still a proper status
+					return
+				}
+				enc.Encode(BatchStreamItem{Error: "product store busy, batch incomplete"})
+				return
+			}
+			for id, p := range got {
+				found[id] = p
+			}
+		}
+		if start == 0 {
+			w.Header().Set("Content-Type", contentTypeNDJSON)
+		}
+		for _, id := range chunk {
+			item := BatchStreamItem{ID: id, Missing: true}
+			if p, ok := found[id]; ok {
+				item = BatchStreamItem{ID: id, Product: &p}
+			}
+			var line interface{} = item
+			if projected {
+				line = projectWrapped(item, "product")
+			}
+			if err := enc.Encode(line); err != nil {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The IDs of a batch result's items, in order
+func batchItemIDs(result BatchResult) []int {
+	ids := []int{}
+	for _, p := range result.Items {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func TestBatchDuplicateIDs(t *testing.T) {
+	for mode, want := range map[string][]int{
+		batchDuplicatesDedup:    {2, 1},
+		batchDuplicatesPreserve: {2, 1, 2},
+	} {
+		t.Run(mode, func(t *testing.T) {
+			_, h := setupTest(t)
+			config.BatchDuplicateIDs = mode
+			counter := countCommands(t)
+
+			for _, w := range []*httptest.ResponseRecorder{
+				do(h, "GET", "/products/batch?ids=2,1,2,9,9", ""),
+				do(h, "POST", "/products/batch", `{"ids":[2,1,2,9,9]}`),
+			} {
+				var result BatchResult
+				decodeBody(t, w, &result)
+				if got := batchItemIDs(result); !reflect.DeepEqual(got, want) || !reflect.DeepEqual(result.Missing, []int{9}) {
+					t.Fatalf("batch of 2,1,2,9,9: got items %v missing %v, want %v and [9]", got, result.Missing, want)
+				}
+			}
+			// Each key goes to Redis once
+			if args := counter.lastArgs("mget"); len(args) != 4 {
+				t.Fatalf("MGET sent %v, want the 3 distinct keys", args)
+			}
+		})
+	}
+}
+
+// flushRecorder notes how many lines had been written at each Flush
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	linesAtFlush []int
+}
+
+func (f *flushRecorder) Flush() {
+	f.linesAtFlush = append(f.linesAtFlush, strings.Count(f.Body.String(), "\n"))
+}
+
+func TestLargeBatchStreamsInChunks(t *testing.T) {
+	_, h := setupTest(t)
+	config.BatchStreamChunk = 100
+	counter := countCommands(t)
+
+	ids := make([]string, batchMaxPostIDs)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+	req := httptest.NewRequest("POST", "/products/batch", strings.NewReader(`{"ids":[`+strings.Join(ids, ",")+`]}`))
+	req.Header.Set("Accept", contentTypeNDJSON)
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != contentTypeNDJSON {
+		t.Fatalf("got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	want := []int{100, 200, 300, 400, 500, 600, 700, 800, 900, 1000}
+	if !reflect.DeepEqual(w.linesAtFlush, want) {
+		t.Fatalf("lines written at each flush: got %v, want %v", w.linesAtFlush, want)
+	}
+	if n := counter.count("mget"); n != 10 {
+		t.Fatalf("%d MGETs, want one per chunk", n)
+	}
+
+	dec := json.NewDecoder(w.Body)
+	found, missing := 0, 0
+	for i := 1; dec.More(); i++ {
+		var item BatchStreamItem
+		if err := dec.Decode(&item); err != nil {
+			t.Fatal(err)
+		}
+		if item.ID != i {
+			t.Fatalf("line %d is for ID %d", i, item.ID)
+		}
+		if item.Missing {
+			missing++
+		} else if item.Product != nil && item.Product.ID == i {
+			found++
+		}
+	}
+	if found != 3 || missing != batchMaxPostIDs-3 {
+		t.Fatalf("streamed %d found and %d missing, want 3 and %d", found, missing, batchMaxPostIDs-3)
+	}
+}
+
+func TestBatchETag(t *testing.T) {
+	_, h := setupTest(t)
+	const path = "/products/batch?ids=1,2,9"
+
+	etag := do(h, "GET", path, "").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("batch GET without an ETag")
+	}
+	if w := do(h, "GET", path, "", "If-None-Match", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("unchanged set: got %d with %d body bytes, want 304", w.Code, w.Body.Len())
+	}
+	if w := do(h, "GET", "/products/batch?ids=1,2", "", "If-None-Match", etag); w.Code != http.StatusOK {
+		t.Fatalf("another set: got %d, want 200", w.Code)
+	}
+
+	do(h, "PUT", "/product/2", `{"id":2,"name":"Plantain","price":60}`)
+	w := do(h, "GET", path, "", "If-None-Match", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("after updating a member: got %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	// A missing member appearing changes the set too
+	etag = w.Header().Get("ETag")
+	do(h, "PUT", "/product/9", `{"id":9,"name":"Fig","price":5}`)
+	if w := do(h, "GET", path, "", "If-None-Match", etag); w.Code != http.StatusOK {
+		t.Fatalf("after creating a missing member: got %d, want 200", w.Code)
+	}
+}
+
+func TestOrderedBatchFollowsRequestOrder(t *testing.T) {
+	_, h := setupTest(t)
+
+	for _, w := range []*httptest.ResponseRecorder{
+		do(h, "GET", "/products/batch?ids=3,9,1,3&ordered=true", ""),
+		do(h, "POST", "/products/batch?ordered=true", `{"ids":[3,9,1,3]}`),
+	} {
+		var result OrderedBatchResult
+		decodeBody(t, w, &result)
+		var ids []int
+		for _, p := range result.Items {
+			if p == nil {
+				ids = append(ids, 0)
+			} else {
+				ids = append(ids, p.ID)
+			}
+		}
+		// Every requested ID gets a slot, a null for the missing one
+		if !reflect.DeepEqual(ids, []int{3, 0, 1, 3}) || !reflect.DeepEqual(result.Missing, []int{9}) {
+			t.Fatalf("ordered batch of 3,9,1,3: got %v missing %v", ids, result.Missing)
+		}
+	}
+	if body := do(h, "GET", "/products/batch?ids=9,1&ordered=true", "").Body.String(); !strings.HasPrefix(body, `{"items":[null,`) {
+		t.Fatalf("missing ID not a null: %s", body)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var errCircuitOpen = errors.New("redis circuit breaker open")
+
+// BREAKER_OPEN_RESPONSE values, and circuit breaker states
+const (
+	breakerFallback        = "fallback"    // serve from the DB while the breaker is open
+	breakerOpenUnavailable = "unavailable" // answer 503 with Retry-After instead
+
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// circuitBreaker stops sending commands to Redis after BREAKER_FAILURES
+// consecutive connection-level failures, so requests fall back to the DB at
+// once instead of each waiting on a dead server. After BREAKER_OPEN_TIMEOUT a
+// single probe is let through; its outcome closes or re-opens the breaker.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+var redisBreaker = &circuitBreaker{state: breakerClosed}
+
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != breakerClosed
+}
+
+// How long until an open breaker lets a probe through; 0 unless it's open
+// and still cooling down
+func (b *circuitBreaker) cooldownRemaining() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return 0
+	}
+	if remaining := config.BreakerOpenTimeout - time.Since(b.openedAt); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Whether a command may be sent now
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if time.Since(b.openedAt) < config.BreakerOpenTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	default: // half-open: one probe at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+}
+
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !breakerFailure(err) {
+		if b.state != breakerClosed {
+			log.Printf("Redis circuit breaker closed after %s", time.Since(b.openedAt).Round(time.Second))
+		}
+		b.state, b.failures, b.probing = breakerClosed, 0, false
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= config.BreakerFailures {
+		if b.state == breakerClosed {
+			log.Printf("WARNING: Redis circuit breaker opened after %d consecutive failures: %v", b.failures, err)
+		}
+		b.state, b.openedAt, b.probing = breakerOpen, time.Now(), false
+		metrics.IncrCounter("redis_breaker_opened_total", nil)
+	}
+}
+
+// Utility - whether err means Redis itself is unhealthy. Replies such as
+// redis.Nil or WRONGTYPE prove the server is up, and errors we produce
+// ourselves say nothing about it.
+func breakerFailure(err error) bool {
+	if err == nil || err == redis.Nil || errors.Is(err, errCacheReadOnly) || errors.Is(err, errCircuitOpen) ||
+		errors.Is(err, context.Canceled) {
+		return false
+	}
+	var reply redis.Error
+	if errors.As(err, &reply) {
+		return false
+	}
+	return true
+}
+
+// breakerHook is a go-redis hook applying redisBreaker to every command
+type breakerHook struct{}
+
+func (breakerHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if config.BreakerFailures > 0 && !redisBreaker.allow() {
+		return ctx, errCircuitOpen
+	}
+	return ctx, nil
+}
+
+func (breakerHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if config.BreakerFailures > 0 && !errors.Is(cmd.Err(), errCircuitOpen) {
+		redisBreaker.record(cmd.Err())
+	}
+	return nil
+}
+
+func (breakerHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if config.BreakerFailures > 0 && !redisBreaker.allow() {
+		return ctx, errCircuitOpen
+	}
+	return ctx, nil
+}
+
+func (breakerHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if config.BreakerFailures == 0 || len(cmds) == 0 || errors.Is(cmds[0].Err(), errCircuitOpen) {
+		return nil
+	}
+	// A pipeline is one round trip; judge it by its first error, if any
+	var err error
+	for _, cmd := range cmds {
+		if breakerFailure(cmd.Err()) {
+			err = cmd.Err()
+			break
+		}
+	}
+	redisBreaker.record(err)
+	return nil
+}
+
+// Middleware - with BREAKER_OPEN_RESPONSE=unavailable, answer API requests
+// with 503 while the breaker cools down instead of falling back to the DB,
+// with Retry-After set to the cooldown left. Health, metrics and admin
+// endpoints are always served. Once the cooldown is over requests go through
+// again, so one of them can probe Redis.
+func breakerOpenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.BreakerOpenResponse != breakerOpenUnavailable || config.BreakerFailures == 0 || !breakerGuardedPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		remaining := redisBreaker.cooldownRemaining()
+		if remaining <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(remaining)))
+		metrics.IncrCounter("redis_breaker_rejected_total", nil)
+		http.Error(w, "Cache unavailable, try again later", http.StatusServiceUnavailable)
+	})
+}
+
+// Utility - whether a path is an API endpoint backed by Redis
+func breakerGuardedPath(path string) bool {
+	return strings.HasPrefix(path, "/product") || strings.HasPrefix(path, "/writes/")
+}
+
+// Handler - GET /healthz
+// Liveness: the process is up and serving
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// Handler - GET /readyz
+// Readiness. With READYZ_FAIL_ON_BREAKER_OPEN an open Redis breaker reports
+// 503, so deployments can route traffic away from a degraded instance.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&shuttingDown) == 1 {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if config.ReadyzFailOnBreakerOpen && redisBreaker.isOpen() {
+		http.Error(w, "redis circuit breaker open", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
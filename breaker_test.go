@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Utility - stop Redis and send reads until the breaker opens
+func openBreaker(t *testing.T, h http.Handler, closeRedis func()) {
+	t.Helper()
+	closeRedis()
+	for i := 0; i < 20 && !redisBreaker.isOpen(); i++ {
+		do(h, "GET", "/product/1", "")
+	}
+	if !redisBreaker.isOpen() {
+		t.Fatal("breaker still closed with Redis down")
+	}
+}
+
+func TestReadyzReflectsOpenBreaker(t *testing.T) {
+	for _, failOnOpen := range []bool{false, true} {
+		mr, h := setupTest(t)
+		config.BreakerFailures = 2
+		config.ReadyzFailOnBreakerOpen = failOnOpen
+
+		if w := do(h, "GET", "/readyz", ""); w.Code != http.StatusOK {
+			t.Fatalf("failOnOpen=%v: readyz with the breaker closed = %d", failOnOpen, w.Code)
+		}
+		openBreaker(t, h, mr.Close)
+
+		want := http.StatusOK
+		if failOnOpen {
+			want = http.StatusServiceUnavailable
+		}
+		if w := do(h, "GET", "/readyz", ""); w.Code != want {
+			t.Errorf("failOnOpen=%v: readyz with the breaker open = %d, want %d", failOnOpen, w.Code, want)
+		}
+		if w := do(h, "GET", "/healthz", ""); w.Code != http.StatusOK {
+			t.Errorf("failOnOpen=%v: healthz with the breaker open = %d", failOnOpen, w.Code)
+		}
+	}
+}
+
+func TestBreakerOpenRetryAfter(t *testing.T) {
+	mr, h := setupTest(t)
+	config.BreakerFailures = 2
+	config.BreakerOpenTimeout = 30 * time.Second
+	config.BreakerOpenResponse = breakerOpenUnavailable
+	openBreaker(t, h, mr.Close)
+
+	w := do(h, "GET", "/product/1", "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("GET with the breaker open: got %d, want 503", w.Code)
+	}
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retry < 29 || retry > 30 {
+		t.Fatalf("Retry-After: got %q, want the ~30s cooldown left", w.Header().Get("Retry-After"))
+	}
+	if w := do(h, "GET", "/healthz", ""); w.Code != http.StatusOK {
+		t.Fatalf("healthz with the breaker open: got %d", w.Code)
+	}
+
+	// Once the cooldown is over, requests go through to probe Redis
+	redisBreaker.mu.Lock()
+	redisBreaker.openedAt = time.Now().Add(-config.BreakerOpenTimeout)
+	redisBreaker.mu.Unlock()
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET after the cooldown: got %d, want 200 from the DB", w.Code)
+	}
+}
+
+func TestBreakerOpenFallsBackByDefault(t *testing.T) {
+	mr, h := setupTest(t)
+	config.BreakerFailures = 2
+	openBreaker(t, h, mr.Close)
+
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK || w.Header().Get("Retry-After") != "" {
+		t.Fatalf("GET with the breaker open: got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const bulkMaxOps = 1000
+
+// How POST /products/bulk handles a batch in which some items fail
+const (
+	bulkAllOrNothing = "all_or_nothing" // apply nothing unless every item succeeds
+	bulkBestEffort   = "best_effort"    // apply what succeeds, report the rest with 207
+)
+
+// bulkOp is one item of a bulk request: create or update carry a product,
+// delete an id
+type bulkOp struct {
+	Op      string   `json:"op"`
+	ID      int      `json:"id,omitempty"`
+	Product *Product `json:"product,omitempty"`
+}
+
+// BulkItemResult reports the outcome of one item, in request order
+type BulkItemResult struct {
+	Index  int      `json:"index"`
+	Op     string   `json:"op"`
+	Status int      `json:"status"`
+	ID     int      `json:"id,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	Result *Product `json:"product,omitempty"`
+}
+
+// Utility - check an item before anything is applied, normalizing its
+// product. Returns the HTTP status to report for it and an error.
+func checkBulkOp(op *bulkOp) (int, error) {
+	switch op.Op {
+	case "create", "update":
+		if op.Product == nil {
+			return http.StatusBadRequest, errors.New("product is required")
+		}
+		if op.Op == "update" && op.Product.ID <= 0 {
+			return http.StatusBadRequest, errors.New("product id is required")
+		}
+		p, err := validateProduct(*op.Product)
+		if err != nil {
+			status := http.StatusBadRequest
+			if config.ValidationStatus == validationStatus422 {
+				status = http.StatusUnprocessableEntity
+			}
+			return status, err
+		}
+		op.Product = &p
+	case "delete":
+		if op.ID <= 0 {
+			return http.StatusBadRequest, errors.New("id is required")
+		}
+	default:
+		return http.StatusBadRequest, errors.New(`op must be "create", "update" or "delete"`)
+	}
+	return 0, nil
+}
+
+// bulkChange is an applied item's side effects, run once the lock is released
+type bulkChange struct {
+	op      string
+	product Product
+	event   string
+}
+
+// Handler - POST /products/bulk
+// Applies a list of create/update/delete operations under a single write
+// lock. In all_or_nothing mode (the default) any failing item leaves the
+// store untouched; in best_effort mode the rest are applied and the response
+// is 207 Multi-Status with a result per item.
+func bulkProductsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var ops []bulkOp
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if len(ops) == 0 || len(ops) > bulkMaxOps {
+		http.Error(w, "Expected between 1 and 1000 operations", http.StatusBadRequest)
+		return
+	}
+	atomicMode := config.BulkMode != bulkBestEffort
+
+	results := make([]BulkItemResult, len(ops))
+	failed := 0
+	for i := range ops {
+		results[i] = BulkItemResult{Index: i, Op: ops[i].Op, ID: ops[i].ID}
+		if status, err := checkBulkOp(&ops[i]); err != nil {
+			results[i].Status, results[i].Error = status, err.Error()
+			failed++
+		}
+	}
+	if atomicMode && failed > 0 {
+		for i := range results {
+			if results[i].Error == "" {
+				results[i].Status, results[i].Error = http.StatusFailedDependency, "not applied"
+			}
+		}
+		writeBulkResults(w, r, http.StatusBadRequest, results)
+		return
+	}
+
+	// Creates under redis_incr need their IDs reserved before the lock
+	reserved := make([]int, len(ops))
+	for i, op := range ops {
+		if op.Op != "create" || results[i].Error != "" {
+			continue
+		}
+		id, err := reserveProductID(ctx)
+		if err != nil {
+			writeDBLockError(w)
+			return
+		}
+		reserved[i] = id
+	}
+
+	// Pending write-behind writes to the IDs being updated or deleted are
+	// replaced by the batch; updates move past the version the cache showed
+	var written []int
+	for i, op := range ops {
+		if results[i].Error != "" {
+			continue
+		}
+		if op.Op == "update" {
+			written = append(written, op.Product.ID)
+		} else if op.Op == "delete" {
+			written = append(written, op.ID)
+		}
+	}
+	pendingVersions := pendingWriteBehindVersions(ctx, written)
+
+	if err := lockDB(ctx); err != nil {
+		writeDBLockError(w)
+		return
+	}
+	// Pre-batch state of every touched ID (nil if absent), for rollback
+	previous := map[int]*Product{}
+	remember := func(id int) {
+		if _, seen := previous[id]; !seen {
+			previous[id] = fakeProductDB[id]
+		}
+	}
+	var changes []bulkChange
+	for i, op := range ops {
+		if results[i].Error != "" {
+			continue
+		}
+		switch op.Op {
+		case "create":
+			created, err := insertProductLocked(*op.Product, reserved[i])
+			if err != nil {
+				results[i].Status, results[i].Error = bulkErrorStatus(err), err.Error()
+				break
+			}
+			if _, seen := previous[created.ID]; !seen {
+				previous[created.ID] = nil
+			}
+			results[i].Status, results[i].ID, results[i].Result = http.StatusCreated, created.ID, &created
+			changes = append(changes, bulkChange{op: "create", product: created, event: "created"})
+		case "update":
+			remember(op.Product.ID)
+			updated, event, err := putProductLocked(*op.Product)
+			if err != nil {
+				results[i].Status, results[i].Error = bulkErrorStatus(err), err.Error()
+				break
+			}
+			if pending, ok := pendingVersions[updated.ID]; ok && pending >= updated.Version {
+				updated.Version = pending + 1
+				stored := updated
+				fakeProductDB[updated.ID] = &stored
+			}
+			results[i].Status, results[i].ID, results[i].Result = http.StatusOK, updated.ID, &updated
+			changes = append(changes, bulkChange{op: "update", product: updated, event: event})
+		case "delete":
+			if _, ok := fakeProductDB[op.ID]; !ok {
+				results[i].Status, results[i].Error = http.StatusNotFound, errProductNotFound.Error()
+				break
+			}
+			remember(op.ID)
+			delete(fakeProductDB, op.ID)
+			forgetGenerationLocked(op.ID)
+			results[i].Status = http.StatusNoContent
+			changes = append(changes, bulkChange{op: "delete", product: Product{ID: op.ID}, event: "deleted"})
+		}
+		if results[i].Error != "" {
+			failed++
+			if atomicMode {
+				break
+			}
+		}
+	}
+
+	if atomicMode && failed > 0 {
+		for id, p := range previous {
+			if p == nil {
+				delete(fakeProductDB, id)
+				forgetGenerationLocked(id)
+			} else {
+				fakeProductDB[id] = p
+				bumpGenerationLocked(id)
+			}
+		}
+		fakeDBLock.Unlock()
+		for i := range results {
+			if results[i].Error == "" {
+				results[i].Status, results[i].Error, results[i].Result = http.StatusFailedDependency, "rolled back", nil
+			}
+		}
+		writeBulkResults(w, r, http.StatusConflict, results)
+		return
+	}
+	// Drop the replaced pending writes before releasing the lock, so the
+	// write-behind worker can't store one over the batch (see
+	// storeWriteBehindProduct)
+	var replaced []int
+	for _, c := range changes {
+		if c.op != "create" {
+			replaced = append(replaced, c.product.ID)
+			if _, ok := pendingVersions[c.product.ID]; ok {
+				discardWriteBehind(ctx, c.product.ID)
+			}
+		}
+	}
+	supersedeQueuedWrites(ctx, replaced)
+	count := len(fakeProductDB)
+	fakeDBLock.Unlock()
+
+	metrics.SetGauge("products_in_db", float64(count), nil)
+	for _, c := range changes {
+		switch c.op {
+		case "create":
+			publishProductEvent(c.event, c.product.ID, &c.product)
+		case "update":
+			afterSave(ctx, c.product, c.event)
+		case "delete":
+			afterDelete(ctx, c.product.ID)
+		}
+	}
+
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	writeBulkResults(w, r, status, results)
+}
+
+// Utility - status reported for an item that failed while being applied
+func bulkErrorStatus(err error) int {
+	if errors.Is(err, errProductLimitReached) {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusConflict
+}
+
+func writeBulkResults(w http.ResponseWriter, r *http.Request, status int, results []BulkItemResult) {
+	w.Header().Set("Content-Type", "application/json")
+	projected := publicProjection(w, r)
+	w.WriteHeader(status)
+	if projected {
+		items := make([]map[string]interface{}, len(results))
+		for i, result := range results {
+			items[i] = projectWrapped(result, "product")
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": items})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
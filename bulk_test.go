@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestBulkUpdateReplacesPendingWriteBehind(t *testing.T) {
+	_, h := setupTest(t)
+	config.CacheUpdateMode = cacheUpdateWriteBehind
+	ctx := context.Background()
+
+	queued, err := saveProduct(ctx, Product{ID: 1, Name: "Queued Apple", Price: 110})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// What the worker read before the bulk request took the DB lock
+	raw, err := redisClient.HGet(ctx, redisWriteBehindPendingKey, "1").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := do(h, "POST", "/products/bulk", `[{"op":"update","product":{"id":1,"name":"Bulk Apple","price":120}}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bulk update: got %d %s", w.Code, w.Body.String())
+	}
+	stored, _ := dbProduct(1)
+	if stored.Name != "Bulk Apple" || stored.Version <= queued.Version {
+		t.Fatalf("bulk update stored %+v; want it named Bulk Apple with a version above %d", stored, queued.Version)
+	}
+	if n, _ := redisClient.HLen(ctx, redisWriteBehindPendingKey).Result(); n != 0 {
+		t.Fatal("the pending write-behind write was left queued")
+	}
+
+	// A worker that read the pending write before the bulk update must not
+	// store it afterwards, nor must the next pass of the queue
+	if err := storeWriteBehindProduct(ctx, queued, raw); !errors.Is(err, errWriteBehindSuperseded) {
+		t.Fatalf("storing the replaced write: got %v", err)
+	}
+	applyWriteBehind(ctx, "1")
+	if after, _ := dbProduct(1); after != stored {
+		t.Fatalf("write-behind overwrote the bulk update: %+v", after)
+	}
+}
+
+func TestBulkUpdateSupersedesQueuedAsyncWrite(t *testing.T) {
+	_, h := setupTest(t)
+	config.AsyncWrites = true
+	ctx := context.Background()
+
+	if _, err := enqueueProductWrite(ctx, Product{ID: 1, Name: "Queued Apple", Price: 110}); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(h, "POST", "/products/bulk", `[{"op":"update","product":{"id":1,"name":"Bulk Apple","price":120}}]`); w.Code != http.StatusOK {
+		t.Fatalf("bulk update: got %d %s", w.Code, w.Body.String())
+	}
+
+	raw, err := redisClient.RPopLPush(ctx, redisWriteQueueKey, redisWriteProcessingKey).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyQueuedWrite(ctx, raw)
+	if p, _ := dbProduct(1); p.Name != "Bulk Apple" {
+		t.Fatalf("a write queued before the bulk update was applied over it: %+v", p)
+	}
+
+	// Writes queued after the bulk update still apply
+	if _, err := enqueueProductWrite(ctx, Product{ID: 1, Name: "Later Apple", Price: 130}); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = redisClient.RPopLPush(ctx, redisWriteQueueKey, redisWriteProcessingKey).Result()
+	applyQueuedWrite(ctx, raw)
+	if p, _ := dbProduct(1); p.Name != "Later Apple" {
+		t.Fatalf("a write queued after the bulk update was not applied: %+v", p)
+	}
+	if n, _ := redisClient.HLen(ctx, redisWriteSupersededKey).Result(); n != 0 {
+		t.Fatal("supersede marker kept after a later write was applied")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Global budget for cache-bypassing reads, so clients can't turn the
+// bypass into unlimited DB load
+var cacheBypassLimiter *tokenBucket
+
+// Per-client budget for cache-bypassing reads, so one client can't use up
+// the global budget or hammer the DB on its own
+var cacheBypassIPLimiter *keyedTokenBuckets
+
+// Whether the client asked to skip the cache, via Cache-Control: no-cache
+// or ?no_cache=true
+func cacheBypassRequested(r *http.Request) bool {
+	if b, err := strconv.ParseBool(r.URL.Query().Get("no_cache")); err == nil && b {
+		return true
+	}
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a requested bypass is honoured. Over either the client's or the
+// global budget the request is served normally from the cache instead of
+// being rejected. The global budget is checked first so a bypass it would
+// refuse doesn't spend the client's token.
+func allowCacheBypass(r *http.Request) bool {
+	if !config.CacheBypassEnabled {
+		return false
+	}
+	if cacheBypassLimiter != nil && !cacheBypassLimiter.ready() {
+		return false
+	}
+	if cacheBypassIPLimiter != nil && !cacheBypassIPLimiter.allow(clientIP(r)) {
+		return false
+	}
+	return cacheBypassLimiter == nil || cacheBypassLimiter.allow()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Cache a stale copy of product 1, as if a write's invalidation was lost
+func cacheStaleApple(t *testing.T) {
+	t.Helper()
+	stale := Product{ID: 1, Name: "Old Apple", Price: 90, Version: 1}
+	if err := redisClient.Set(context.Background(), redisProductKey(1), encodeCachedProduct(stale, config.CacheSchemaVersion), 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheBypassReadsDBAndRefreshesCache(t *testing.T) {
+	for _, header := range [][]string{{"Cache-Control", "no-cache"}, nil} {
+		_, h := setupTest(t)
+		cacheStaleApple(t)
+		path := "/product/1"
+		if header == nil {
+			path += "?no_cache=true"
+		}
+
+		var p Product
+		decodeBody(t, do(h, "GET", path, "", header...), &p)
+		if p.Name != "Apple" {
+			t.Fatalf("bypassing GET %s %v: got %+v, want the DB copy", path, header, p)
+		}
+		decodeBody(t, do(h, "GET", "/product/1", ""), &p)
+		if p.Name != "Apple" {
+			t.Fatalf("GET after a bypass: got %+v, want the refreshed cache entry", p)
+		}
+	}
+}
+
+func TestCacheBypassDisabledServesCache(t *testing.T) {
+	_, h := setupTest(t)
+	config.CacheBypassEnabled = false
+	cacheStaleApple(t)
+
+	var p Product
+	decodeBody(t, do(h, "GET", "/product/1", "", "Cache-Control", "no-cache"), &p)
+	if p.Name != "Old Apple" {
+		t.Fatalf("no-cache with bypass disabled: got %+v, want the cached copy", p)
+	}
+}
+
+func TestCacheBypassOverBudgetServesCache(t *testing.T) {
+	_, h := setupTest(t)
+	cacheBypassLimiter = newTokenBucket(0, 1)
+	cacheStaleApple(t)
+
+	do(h, "GET", "/product/2", "", "Cache-Control", "no-cache")
+	var p Product
+	w := do(h, "GET", "/product/1", "", "Cache-Control", "no-cache")
+	decodeBody(t, w, &p)
+	if w.Code != http.StatusOK || p.Name != "Old Apple" {
+		t.Fatalf("bypass over the global budget: got %d %+v, want the cached copy", w.Code, p)
+	}
+}
+
+func TestCacheBypassPerIPLimitServesCache(t *testing.T) {
+	_, h := setupTest(t)
+	cacheBypassIPLimiter = newKeyedTokenBuckets(0, 2)
+	cacheStaleApple(t)
+
+	// The client's first two bypasses read the DB; once over its budget it
+	// gets the cached copy, not an error
+	for i, want := range []string{"Apple", "Apple", "Old Apple"} {
+		if i == 2 {
+			cacheStaleApple(t)
+		}
+		w := do(h, "GET", "/product/1", "", "Cache-Control", "no-cache")
+		var p Product
+		decodeBody(t, w, &p)
+		if w.Code != http.StatusOK || p.Name != want {
+			t.Fatalf("bypass %d: got %d %+v, want %s", i+1, w.Code, p, want)
+		}
+	}
+
+	// Another client still has its own budget
+	req := httptest.NewRequest("GET", "/product/1", nil)
+	req.Header.Set("Cache-Control", "no-cache")
+	req.RemoteAddr = "192.0.2.7:4000"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var p Product
+	decodeBody(t, w, &p)
+	if p.Name != "Apple" {
+		t.Fatalf("bypass from another client: got %+v, want the DB copy", p)
+	}
+}
+
+func TestCacheBypassOverGlobalBudgetKeepsClientToken(t *testing.T) {
+	_, h := setupTest(t)
+	cacheBypassLimiter = newTokenBucket(0, 1)
+	cacheBypassIPLimiter = newKeyedTokenBuckets(0, 1)
+
+	// Another client uses up the global budget; this one's refused bypass
+	// mustn't cost it its own token
+	req := httptest.NewRequest("GET", "/product/2", nil)
+	req.Header.Set("Cache-Control", "no-cache")
+	req.RemoteAddr = "192.0.2.7:4000"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	do(h, "GET", "/product/1", "", "Cache-Control", "no-cache")
+
+	if !cacheBypassIPLimiter.allow("192.0.2.1") {
+		t.Fatal("a bypass refused by the global budget spent the client's token")
+	}
+}
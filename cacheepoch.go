@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The fleet-wide cache epoch, bumped through the admin API
+const redisCacheEpochKey = "cache:epoch"
+
+// errStaleCacheEpoch marks a cache entry written before the current epoch
+var errStaleCacheEpoch = errors.New("cache entry is from an earlier cache epoch")
+
+// The epoch in effect on this instance: the larger of CACHE_EPOCH and the
+// one stored in Redis, as of the last poll
+var cacheEpoch int64
+
+// Set the epoch to the larger of ARGV[1] (CACHE_EPOCH) and the stored one,
+// plus one, and return it
+var bumpCacheEpochScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local floor = tonumber(ARGV[1])
+if floor > current then
+	current = floor
+end
+current = current + 1
+redis.call('SET', KEYS[1], current)
+return current
+`)
+
+func currentCacheEpoch() int {
+	return int(atomic.LoadInt64(&cacheEpoch))
+}
+
+// Raise this instance's epoch to n if n is newer. Entries written under an
+// earlier epoch stop being served from then on.
+func adoptCacheEpoch(n int) {
+	for {
+		cur := atomic.LoadInt64(&cacheEpoch)
+		if int64(n) <= cur {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&cacheEpoch, cur, int64(n)) {
+			log.Printf("Cache epoch is now %d; entries from earlier epochs will be reloaded from the DB", n)
+			return
+		}
+	}
+}
+
+// Utility - read the stored epoch and adopt it if newer
+func refreshCacheEpoch(ctx context.Context) error {
+	adoptCacheEpoch(config.CacheEpoch)
+	n, err := redisClient.Get(ctx, redisCacheEpochKey).Int()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	adoptCacheEpoch(n)
+	return nil
+}
+
+// Background goroutine - pick up epochs bumped by other instances
+func runCacheEpochPoller(ctx context.Context) {
+	ticker := time.NewTicker(config.CacheEpochPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := refreshCacheEpoch(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Cache epoch poll failed: %v", err)
+			}
+		}
+	}
+}
+
+// Handler - POST /admin/cache/epoch
+// Start a new cache epoch, e.g. after a DB schema change: every entry cached
+// so far is treated as stale, reloaded from the DB and rewritten on its next
+// read, without flushing Redis. Other instances follow within
+// CACHE_EPOCH_POLL_INTERVAL.
+func bumpCacheEpochHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := bumpCacheEpochScript.Run(r.Context(), redisClient, []string{redisCacheEpochKey}, config.CacheEpoch).Int()
+	if err != nil {
+		log.Printf("Cache epoch bump failed: %v", err)
+		http.Error(w, "Cache unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	adoptCacheEpoch(n)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"epoch": currentCacheEpoch()})
+}
+
+// Utility - check the epoch a cache entry was written under
+func checkCacheEpoch(entryEpoch int) error {
+	if entryEpoch < currentCacheEpoch() {
+		return errStaleCacheEpoch
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestBumpedCacheEpochReloadsEntries(t *testing.T) {
+	mr, h := setupTest(t)
+	config.AdminToken = "secret"
+	config.DebugToken = "debug"
+	do(h, "GET", "/product/1", "")
+
+	// A migration changes the DB behind the cache's back
+	fakeDBLock.Lock()
+	fakeProductDB[1] = &Product{ID: 1, Name: "Apple (migrated)", Price: 100, Version: 1}
+	fakeDBLock.Unlock()
+	if w := do(h, "GET", "/product/1", "", "X-Debug", "debug"); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("before the bump: X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+
+	if w := do(h, "POST", "/admin/cache/epoch", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("bump without the admin token: got %d, want 401", w.Code)
+	}
+	var resp map[string]int
+	decodeBody(t, do(h, "POST", "/admin/cache/epoch", "", "Authorization", "Bearer secret"), &resp)
+	if resp["epoch"] != 1 {
+		t.Fatalf("bump: got %v, want epoch 1", resp)
+	}
+	if !mr.Exists(redisProductKey(1)) {
+		t.Fatal("bump flushed the cache instead of invalidating it logically")
+	}
+
+	w := do(h, "GET", "/product/1", "", "X-Debug", "debug")
+	var p Product
+	decodeBody(t, w, &p)
+	if w.Header().Get("X-Cache") == "HIT" || p.Name != "Apple (migrated)" {
+		t.Fatalf("after the bump: got %+v, X-Cache %q; want a reload from the DB", p, w.Header().Get("X-Cache"))
+	}
+	// Rewritten under the new epoch, it is served again
+	if w := do(h, "GET", "/product/1", "", "X-Debug", "debug"); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("after the reload: X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestCacheEpochFollowsOtherInstances(t *testing.T) {
+	mr, h := setupTest(t)
+	config.AdminToken = "secret"
+	config.CacheEpoch = 10
+
+	mr.Set(redisCacheEpochKey, "4")
+	if err := refreshCacheEpoch(context.Background()); err != nil || currentCacheEpoch() != 10 {
+		t.Fatalf("CACHE_EPOCH over a lower stored epoch: got %d, %v", currentCacheEpoch(), err)
+	}
+	var resp map[string]int
+	decodeBody(t, do(h, "POST", "/admin/cache/epoch", "", "Authorization", "Bearer secret"), &resp)
+	if resp["epoch"] != 11 {
+		t.Fatalf("bump from CACHE_EPOCH=10: got %v, want 11", resp)
+	}
+
+	// Another instance bumps further
+	mr.Set(redisCacheEpochKey, "15")
+	refreshCacheEpoch(context.Background())
+	if currentCacheEpoch() != 15 {
+		t.Fatalf("after another instance's bump: got %d, want 15", currentCacheEpoch())
+	}
+}
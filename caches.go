@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// namedCache is one logical cache stored in Redis: its entries live under a
+// key prefix of their own and expire after its TTL. Entity types get their
+// key builders and TTL from here instead of formatting keys by hand.
+type namedCache struct {
+	name   string
+	prefix string
+	ttl    func() time.Duration // read on use, so config-driven TTLs apply once loaded
+}
+
+// Registry of named caches by name
+var cacheRegistry = map[string]*namedCache{}
+
+var (
+	productCache = registerCache("products", redisProductKeyPrefix, func() time.Duration {
+		return redisProductTTL
+	})
+	idempotencyCache = registerCache("idempotency", redisIdempotencyKeyPrefix, func() time.Duration {
+		return config.IdempotencyKeyTTL
+	})
+)
+
+// Add a cache to the registry. Names must be unique and no prefix may be a
+// prefix of another's, so two caches can never produce the same key; a
+// conflict is a programming error and panics at startup.
+func registerCache(name, prefix string, ttl func() time.Duration) *namedCache {
+	if _, ok := cacheRegistry[name]; ok {
+		panic(fmt.Sprintf("cache %q registered twice", name))
+	}
+	for _, other := range cacheRegistry {
+		if strings.HasPrefix(prefix, other.prefix) || strings.HasPrefix(other.prefix, prefix) {
+			panic(fmt.Sprintf("cache %q prefix %q overlaps cache %q prefix %q", name, prefix, other.name, other.prefix))
+		}
+	}
+	c := &namedCache{name: name, prefix: prefix, ttl: ttl}
+	cacheRegistry[name] = c
+	return c
+}
+
+// Utility - the key for an entry, its parts joined with ":" after the prefix
+func (c *namedCache) key(parts ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(c.prefix)
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		fmt.Fprint(&b, part)
+	}
+	return b.String()
+}
+
+// The TTL entries of this cache are written with
+func (c *namedCache) TTL() time.Duration {
+	return c.ttl()
+}
+
+// Utility - the registered caches, ordered by name
+func registeredCaches() []*namedCache {
+	caches := make([]*namedCache, 0, len(cacheRegistry))
+	for _, c := range cacheRegistry {
+		caches = append(caches, c)
+	}
+	sort.Slice(caches, func(i, j int) bool { return caches[i].name < caches[j].name })
+	return caches
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Utility - register a cache for one test only
+func registerTestCache(t *testing.T, name, prefix string, ttl time.Duration) *namedCache {
+	t.Helper()
+	c := registerCache(name, prefix, func() time.Duration { return ttl })
+	t.Cleanup(func() { delete(cacheRegistry, name) })
+	return c
+}
+
+func TestNamedCachesDontCollide(t *testing.T) {
+	pages := registerTestCache(t, "test-pages", "testpage:", time.Minute)
+	ranks := registerTestCache(t, "test-ranks", "testrank:", time.Hour)
+
+	if pages.key(1) == ranks.key(1) || pages.key(1) != "testpage:1" || ranks.key(1, "hits") != "testrank:1:hits" {
+		t.Fatalf("keys: %q, %q, %q", pages.key(1), ranks.key(1), ranks.key(1, "hits"))
+	}
+	if pages.TTL() != time.Minute || ranks.TTL() != time.Hour {
+		t.Fatalf("TTLs: %v, %v", pages.TTL(), ranks.TTL())
+	}
+	for _, c := range registeredCaches() {
+		if c != pages && c != ranks && (c.key(1) == pages.key(1) || c.key(1) == ranks.key(1)) {
+			t.Fatalf("cache %q collides with a test cache", c.name)
+		}
+	}
+}
+
+func TestRegisterCacheRejectsConflicts(t *testing.T) {
+	registerTestCache(t, "test-pages", "testpage:", time.Minute)
+	for _, tc := range []struct{ name, prefix string }{
+		{"test-pages", "othertest:"},      // duplicate name
+		{"test-pages-v2", "testpage:v2:"}, // prefix extends another's
+		{"test-short", "test"},            // prefix of another's
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %q with prefix %q didn't panic", tc.name, tc.prefix)
+					delete(cacheRegistry, tc.name)
+				}
+			}()
+			registerCache(tc.name, tc.prefix, func() time.Duration { return 0 })
+		}()
+	}
+}
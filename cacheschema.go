@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Cache entry schema versions. Each version has its own key namespace so
+// instances on different versions can run side by side during a rollout.
+const (
+	cacheSchemaV1 = 1 // bare product JSON at product:{id}
+	cacheSchemaV2 = 2 // envelope with metadata at product:v2:{id}
+)
+
+// cachedProductV2 is the schema v2 cache envelope
+type cachedProductV2 struct {
+	Schema   int       `json:"schema"`
+	CachedAt time.Time `json:"cached_at"`
+	Product  Product   `json:"product"`
+	Epoch    int       `json:"epoch,omitempty"` // cache epoch it was written under
+}
+
+// cachedProductV1 is a schema v1 entry: the product JSON, plus when it was
+// cached and the cache epoch it was written under once an epoch has been set
+type cachedProductV1 struct {
+	Product
+	CachedAt *time.Time `json:"cached_at,omitempty"`
+	Epoch    int        `json:"cache_epoch,omitempty"`
+}
+
+// errCacheEntryTooOld marks a cache entry cached more than CACHE_MAX_AGE ago
+var errCacheEntryTooOld = errors.New("cache entry is older than CACHE_MAX_AGE")
+
+// Utility - build the Redis key for a product under a given schema version
+func redisProductKeyForSchema(id, schema int) string {
+	if schema <= cacheSchemaV1 {
+		return productCache.key(id)
+	}
+	return productCache.key(fmt.Sprintf("v%d", schema), id)
+}
+
+// Utility - serialize a product for the cache in the given schema, stamped
+// with the current cache epoch
+func encodeCachedProduct(product Product, schema int) []byte {
+	var raw []byte
+	if schema <= cacheSchemaV1 {
+		now := clock.Now()
+		raw, _ = json.Marshal(cachedProductV1{Product: product, CachedAt: &now, Epoch: currentCacheEpoch()})
+	} else {
+		raw, _ = json.Marshal(cachedProductV2{Schema: schema, CachedAt: clock.Now(), Product: product, Epoch: currentCacheEpoch()})
+	}
+	return raw
+}
+
+// Utility - decode a cached product stored in the given schema. An entry
+// from an earlier cache epoch fails with errStaleCacheEpoch, one past
+// CACHE_MAX_AGE with errCacheEntryTooOld.
+func decodeCachedProduct(data string, schema int) (Product, error) {
+	if schema <= cacheSchemaV1 {
+		var entry cachedProductV1
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return Product{}, err
+		}
+		var cachedAt time.Time
+		if entry.CachedAt != nil {
+			cachedAt = *entry.CachedAt
+		}
+		if err := checkCacheEntryAge(cachedAt); err != nil {
+			return Product{}, err
+		}
+		return entry.Product, checkCacheEpoch(entry.Epoch)
+	}
+	var entry cachedProductV2
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return Product{}, err
+	}
+	if entry.Schema != schema {
+		return Product{}, fmt.Errorf("cache entry has schema %d, want %d", entry.Schema, schema)
+	}
+	if err := checkCacheEntryAge(entry.CachedAt); err != nil {
+		return Product{}, err
+	}
+	return entry.Product, checkCacheEpoch(entry.Epoch)
+}
+
+// Utility - with CACHE_MAX_AGE, reject an entry cached longer ago than that,
+// however often its TTL has been refreshed since. An entry with no cache time
+// (written before it was recorded) can't be shown to be young enough.
+func checkCacheEntryAge(cachedAt time.Time) error {
+	if config.CacheMaxAge <= 0 {
+		return nil
+	}
+	if cachedAt.IsZero() || clock.Now().Sub(cachedAt) > config.CacheMaxAge {
+		return errCacheEntryTooOld
+	}
+	return nil
+}
+
+// Utility - decode the cache entry stored for product id. With
+// CACHE_ID_CHECK an entry holding a different product is rejected like any
+// other corrupt entry, so a key/value desync can't serve the wrong product.
+func decodeCachedProductFor(data string, schema, id int) (Product, error) {
+	product, err := decodeCachedProduct(data, schema)
+	if err != nil {
+		return Product{}, err
+	}
+	if config.CacheIDCheck && product.ID != id {
+		log.Printf("Cache entry for product %d holds product %d; treating it as corrupt", id, product.ID)
+		metrics.IncrCounter("product_cache_id_mismatch_total", nil)
+		return Product{}, fmt.Errorf("cache entry for product %d holds product %d", id, product.ID)
+	}
+	return product, nil
+}
+
+// During a schema migration, fall back to the previous version's entry on a
+// miss and upgrade it into the current format, so a schema bump doesn't
+// start from a cold cache. The old entry is left for instances still on the
+// old version and expires on its own.
+func readLegacyCachedProduct(ctx context.Context, id int) (Product, bool) {
+	schema := config.CacheSchemaVersion
+	if !config.CacheMigrationMode || schema <= cacheSchemaV1 {
+		return Product{}, false
+	}
+	data, err := redisClient.Get(ctx, redisProductKeyForSchema(id, schema-1)).Result()
+	if err != nil || data == redisTombstoneValue {
+		return Product{}, false
+	}
+	product, err := decodeCachedProductFor(data, schema-1, id)
+	if err != nil {
+		return Product{}, false
+	}
+	redisClient.SetNX(ctx, redisProductKey(id), encodeCachedProduct(product, schema), productCache.TTL())
+	return product, true
+}
+
+// Utility - every key a product's cache entry may live under right now: the
+// current schema, plus the previous one while migrating
+func redisProductKeysAllSchemas(id int) []string {
+	keys := []string{redisProductKey(id)}
+	if config.CacheMigrationMode && config.CacheSchemaVersion > cacheSchemaV1 {
+		keys = append(keys, redisProductKeyForSchema(id, config.CacheSchemaVersion-1))
+	}
+	return keys
+}
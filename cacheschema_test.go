@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Cache product 1 under schema v1 with a name the DB doesn't have, so reads
+// show whether the entry was used
+func cacheV1Apple(t *testing.T) {
+	t.Helper()
+	v1 := Product{ID: 1, Name: "Cached Apple", Price: 100, Version: 1}
+	if err := redisClient.Set(context.Background(), redisProductKeyForSchema(1, cacheSchemaV1), encodeCachedProduct(v1, cacheSchemaV1), 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrationReadUpgradesOldSchemaEntry(t *testing.T) {
+	mr, h := setupTest(t)
+	cacheV1Apple(t)
+	config.CacheSchemaVersion = cacheSchemaV2
+	config.CacheMigrationMode = true
+
+	var p Product
+	decodeBody(t, do(h, "GET", "/product/1", ""), &p)
+	if p.Name != "Cached Apple" {
+		t.Fatalf("migrating read: got %+v, want the v1 entry", p)
+	}
+	raw, err := mr.Get(redisProductKeyForSchema(1, cacheSchemaV2))
+	if err != nil {
+		t.Fatalf("no v2 entry written: %v", err)
+	}
+	var entry cachedProductV2
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil || entry.Schema != cacheSchemaV2 || entry.Product.Name != "Cached Apple" {
+		t.Fatalf("upgraded entry %s: %+v, %v", raw, entry, err)
+	}
+	if !mr.Exists(redisProductKeyForSchema(1, cacheSchemaV1)) {
+		t.Fatal("v1 entry removed; instances still on v1 need it")
+	}
+}
+
+func TestNoMigrationIgnoresOldSchemaEntry(t *testing.T) {
+	_, h := setupTest(t)
+	cacheV1Apple(t)
+	config.CacheSchemaVersion = cacheSchemaV2
+
+	var p Product
+	decodeBody(t, do(h, "GET", "/product/1", ""), &p)
+	if p.Name != "Apple" {
+		t.Fatalf("read outside migration mode: got %+v, want the DB copy", p)
+	}
+}
+
+func TestCacheEntryHoldsOnlyServedFields(t *testing.T) {
+	mr, h := setupTest(t)
+	first := do(h, "GET", "/product/1", "").Body.String()
+
+	raw, err := mr.Get(redisProductKeyForSchema(1, cacheSchemaV1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+		t.Fatal(err)
+	}
+	// Envelope metadata aside, the entry is the served product
+	delete(entry, "cached_at")
+	delete(entry, "epoch")
+	for _, field := range []string{"id", "name", "price", "version"} {
+		if _, ok := entry[field]; !ok {
+			t.Errorf("cache entry lacks %q: %s", field, raw)
+		}
+		delete(entry, field)
+	}
+	if len(entry) != 0 {
+		t.Errorf("cache entry holds unserved fields: %s", raw)
+	}
+
+	if cached := do(h, "GET", "/product/1", "").Body.String(); cached != first {
+		t.Fatalf("read from the cache %q differs from the DB read %q", cached, first)
+	}
+}
+
+func TestCachedIDMismatchTreatedAsCorrupt(t *testing.T) {
+	for _, check := range []bool{false, true} {
+		mr, h := setupTest(t)
+		config.CacheIDCheck = check
+		config.DebugToken = "debug"
+		// product:1 holds product 2
+		mr.Set(redisProductKey(1), string(encodeCachedProduct(Product{ID: 2, Name: "Banana", Price: 50, Version: 1}, cacheSchemaV1)))
+
+		var p Product
+		w := do(h, "GET", "/product/1", "", "X-Debug", "debug")
+		decodeBody(t, w, &p)
+		if !check {
+			if p.Name != "Banana" {
+				t.Fatalf("without CACHE_ID_CHECK: got %+v", p)
+			}
+			continue
+		}
+		if p.ID != 1 || p.Name != "Apple" || w.Header().Get("X-Cache") != "MISS" {
+			t.Fatalf("mismatched entry: got %+v, X-Cache %q", p, w.Header().Get("X-Cache"))
+		}
+		// The entry was repaired
+		raw, _ := mr.Get(redisProductKey(1))
+		repaired, err := decodeCachedProduct(raw, cacheSchemaV1)
+		if err != nil || repaired.ID != 1 || repaired.Name != "Apple" {
+			t.Fatalf("cache entry after the read: %+v, %v", repaired, err)
+		}
+	}
+}
+
+func TestCacheMaxAgeReloadsRefreshedEntry(t *testing.T) {
+	mr, h := setupTest(t)
+	config.DebugToken = "debug"
+	config.CacheMaxAge = time.Minute
+	config.TTLRefreshInterval = 0
+	start := time.Now()
+	clock = fixedClock{start}
+
+	do(h, "GET", "/product/1", "")
+	// The source of truth changes out-of-band, unseen by the cache
+	fakeDBLock.Lock()
+	fakeProductDB[1] = &Product{ID: 1, Name: "Green Apple", Price: 100, Version: 1}
+	fakeDBLock.Unlock()
+
+	// A popular product: its TTL keeps being refreshed past CACHE_MAX_AGE
+	for elapsed := 20 * time.Second; elapsed <= 80*time.Second; elapsed += 20 * time.Second {
+		clock = fixedClock{start.Add(elapsed)}
+		mr.FastForward(20 * time.Second)
+		w := do(h, "GET", "/product/1", "", "X-Debug", "debug")
+		var p Product
+		decodeBody(t, w, &p)
+		fresh := elapsed > time.Minute
+		if hit := w.Header().Get("X-Cache") == "HIT"; hit == fresh || (p.Name == "Green Apple") != fresh {
+			t.Fatalf("%v after caching: got %q, X-Cache %q", elapsed, p.Name, w.Header().Get("X-Cache"))
+		}
+		if !fresh && mr.TTL(redisProductKey(1)) != productCache.TTL() {
+			t.Fatalf("%v after caching: TTL %v not refreshed", elapsed, mr.TTL(redisProductKey(1)))
+		}
+	}
+
+	// Reloaded and cached afresh, the entry is served again
+	if w := do(h, "GET", "/product/1", "", "X-Debug", "debug"); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("after the reload: X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+)
+
+// Outcomes of a canary read, as the result label of cache_canary_checks_total
+const (
+	canaryMatch    = "match"
+	canaryMismatch = "mismatch" // the DB holds a different product
+	canaryMissing  = "missing"  // the DB no longer holds the product
+	canarySkipped  = "skipped"  // a write raced the check, or the DB was busy
+)
+
+// Utility - whether to check this cache read against the DB, for a
+// CANARY_SAMPLE_RATE fraction of reads
+func sampleCanary() bool {
+	return config.CanarySampleRate > 0 && rand.Float64() < config.CanarySampleRate
+}
+
+// Background check of a product served from the cache against the DB. gen
+// is the product's write generation from before the cache was read: if it
+// has moved, a write raced the read and any difference is expected, so the
+// check is skipped. The response has already been served from the cache;
+// a divergence is only logged and counted, pointing at an invalidation bug.
+func checkCanary(id int, cached Product, gen uint64) {
+	result := canaryRead(id, cached, gen)
+	metrics.IncrCounter("cache_canary_checks_total", Labels{"result": result})
+}
+
+func canaryRead(id int, cached Product, gen uint64) string {
+	ctx := context.Background()
+	if _, pending := pendingWriteBehindVersion(ctx, id); pending {
+		return canarySkipped // the cache is ahead of the DB by design
+	}
+	dbProduct, dbGen, err := readProductWithGeneration(ctx, id)
+	if errors.Is(err, errProductNotFound) {
+		if !generationCurrent(ctx, id, gen) {
+			return canarySkipped
+		}
+		log.Printf("Cache canary: product %d is cached (version %d) but not in the DB", id, cached.Version)
+		return canaryMissing
+	}
+	if err != nil || dbGen != gen {
+		return canarySkipped
+	}
+	if dbProduct != cached {
+		log.Printf("Cache canary: product %d diverges from the DB: cached %+v, DB %+v", id, cached, dbProduct)
+		return canaryMismatch
+	}
+	return canaryMatch
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Utility - wait for a background canary check to count name
+func waitForCanary(t *testing.T, rec *countingRecorder, name string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for rec.counter(name) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no %s counted", name)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCanaryDetectsDivergence(t *testing.T) {
+	_, h := setupTest(t)
+	config.CanarySampleRate = 1
+	rec := newCountingRecorder()
+	metrics = rec
+
+	do(h, "GET", "/product/1", "")
+	do(h, "GET", "/product/1", "")
+	waitForCanary(t, rec, `cache_canary_checks_total{result="match"}`)
+
+	// An invalidation bug: the DB changes without the cache hearing of it
+	fakeDBLock.Lock()
+	fakeProductDB[1] = &Product{ID: 1, Name: "Green Apple", Price: 100, Version: 1}
+	fakeDBLock.Unlock()
+
+	var p Product
+	decodeBody(t, do(h, "GET", "/product/1", ""), &p)
+	if p.Name != "Apple" {
+		t.Fatalf("canary changed the served response: got %+v", p)
+	}
+	waitForCanary(t, rec, `cache_canary_checks_total{result="mismatch"}`)
+}
+
+func TestCanaryOffByDefault(t *testing.T) {
+	_, h := setupTest(t)
+	rec := newCountingRecorder()
+	metrics = rec
+
+	for i := 0; i < 3; i++ {
+		if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK {
+			t.Fatalf("GET: got %d", w.Code)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	for _, result := range []string{canaryMatch, canaryMismatch, canaryMissing, canarySkipped} {
+		if n := rec.counter(`cache_canary_checks_total{result="` + result + `"}`); n != 0 {
+			t.Fatalf("canary %s counted %d times with CANARY_SAMPLE_RATE unset", result, n)
+		}
+	}
+}
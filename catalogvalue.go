@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// CatalogValueError reports a write that would push the summed price of all
+// products above MAX_CATALOG_VALUE
+type CatalogValueError struct {
+	Total   Price // sum of prices before the write
+	Ceiling Price
+}
+
+func (e *CatalogValueError) Error() string {
+	return fmt.Sprintf("catalog value limit exceeded: current total %d, limit %d", e.Total, e.Ceiling)
+}
+
+// Utility - check that storing product, replacing any product with its ID,
+// keeps the catalog's total price within MAX_CATALOG_VALUE. Callers hold
+// fakeDBLock, so the total can't move between the check and the write.
+func checkCatalogValueLocked(product Product) error {
+	if config.MaxCatalogValue <= 0 {
+		return nil
+	}
+	var total Price
+	for _, p := range fakeProductDB {
+		total += p.Price
+	}
+	after := total + product.Price
+	if existing, ok := fakeProductDB[product.ID]; ok {
+		after -= existing.Price
+	}
+	// A write that doesn't raise the total is always allowed, so products
+	// can still be repriced downwards after the ceiling is lowered
+	if after > config.MaxCatalogValue && after > total {
+		return &CatalogValueError{Total: total, Ceiling: config.MaxCatalogValue}
+	}
+	return nil
+}
+
+// Utility - write err as 409 if it's a *CatalogValueError
+func writeCatalogValueError(w http.ResponseWriter, err error) bool {
+	var cerr *CatalogValueError
+	if !errors.As(err, &cerr) {
+		return false
+	}
+	http.Error(w, fmt.Sprintf("Catalog value limit exceeded: current total %d, limit %d", cerr.Total, cerr.Ceiling), http.StatusConflict)
+	return true
+}
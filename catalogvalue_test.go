@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMaxCatalogValue(t *testing.T) {
+	_, h := setupTest(t)
+	config.MaxCatalogValue = 400 // the seed products total 350
+
+	if w := do(h, "POST", "/product", `{"name":"Date","price":40}`); w.Code != http.StatusCreated {
+		t.Fatalf("create under the ceiling: got %d", w.Code)
+	}
+	w := do(h, "POST", "/product", `{"name":"Elderberry","price":20}`)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "current total 390") {
+		t.Fatalf("create over the ceiling: got %d %q", w.Code, w.Body)
+	}
+	if _, ok := dbProduct(5); ok {
+		t.Fatal("product created past the ceiling")
+	}
+
+	if w := do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":110}`); w.Code != http.StatusNoContent {
+		t.Fatalf("update to exactly the ceiling: got %d", w.Code)
+	}
+	if w := do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":111}`); w.Code != http.StatusConflict {
+		t.Fatalf("update over the ceiling: got %d", w.Code)
+	}
+
+	// Lowering the ceiling below the total still allows cheaper prices
+	config.MaxCatalogValue = 300
+	if w := do(h, "PUT", "/product/3", `{"id":3,"name":"Cherry","price":150}`); w.Code != http.StatusNoContent {
+		t.Fatalf("reprice downwards over the ceiling: got %d", w.Code)
+	}
+}
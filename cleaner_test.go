@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Run the cleaner goroutine until the test ends
+func startCleaner(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runCacheCleaner(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// How long until the cleaner sends its first SCAN, or false by timeout
+func waitForScan(counter *commandCounter, timeout time.Duration) (time.Duration, bool) {
+	start := time.Now()
+	for time.Since(start) < timeout {
+		if counter.count("scan") > 0 {
+			return time.Since(start), true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return 0, false
+}
+
+func TestCleanerFirstPassWithinJitter(t *testing.T) {
+	setupTest(t)
+	config.CleanerStartJitter = 100 * time.Millisecond
+	config.CleanerInterval = time.Hour
+	counter := countCommands(t)
+
+	startCleaner(t)
+	elapsed, ok := waitForScan(counter, time.Second)
+	if !ok {
+		t.Fatal("no cleaner pass within a second; the jitter bound is 100ms")
+	}
+	if elapsed > config.CleanerStartJitter+50*time.Millisecond {
+		t.Fatalf("first pass after %v, beyond the 100ms jitter bound", elapsed)
+	}
+}
+
+func TestCleanerWithoutJitterWaitsForFirstTick(t *testing.T) {
+	setupTest(t)
+	config.CleanerStartJitter = 0
+	config.CleanerInterval = 200 * time.Millisecond
+	counter := countCommands(t)
+
+	startCleaner(t)
+	elapsed, ok := waitForScan(counter, time.Second)
+	if !ok || elapsed < 150*time.Millisecond {
+		t.Fatalf("first pass after %v (ran: %v), want the 200ms interval", elapsed, ok)
+	}
+}
+
+func TestPausedCleanerDoesNoScanning(t *testing.T) {
+	_, h := setupTest(t)
+	config.AdminToken = "secret"
+	counter := countCommands(t)
+	ctx := context.Background()
+
+	var state map[string]bool
+	decodeBody(t, do(h, "POST", "/admin/cleaner/pause", "", "Authorization", "Bearer secret"), &state)
+	if !state["paused"] {
+		t.Fatalf("pause: got %v", state)
+	}
+	var stats ServiceStats
+	decodeBody(t, do(h, "GET", "/stats", ""), &stats)
+	if !stats.CleanerPaused {
+		t.Fatal("cleaner_paused not reported on /stats")
+	}
+	runCleanerPass(ctx)
+	if n := counter.count("scan"); n != 0 {
+		t.Fatalf("paused cleaner pass sent %d SCANs", n)
+	}
+
+	decodeBody(t, do(h, "POST", "/admin/cleaner/resume", "", "Authorization", "Bearer secret"), &state)
+	if state["paused"] {
+		t.Fatalf("resume: got %v", state)
+	}
+	runCleanerPass(ctx)
+	if n := counter.count("scan"); n == 0 {
+		t.Fatal("resumed cleaner pass didn't scan")
+	}
+}
+
+func TestCleanerRemovesOrphanKeys(t *testing.T) {
+	mr, _ := setupTest(t)
+	ctx := context.Background()
+	config.CleanerOrphanKeys = []string{orphanHits, orphanPopularity}
+	for _, id := range []int{1, 99} {
+		mr.Set(redisProductHitsKey(id), "5")
+		mr.SetTTL(redisProductHitsKey(id), time.Minute)
+		for _, key := range []string{redisPopularityKey, redisLastAccessKey} {
+			mr.ZAdd(key, 5, strconv.Itoa(id))
+		}
+	}
+
+	runCleanerPass(ctx)
+	if mr.Exists(redisProductHitsKey(99)) || !mr.Exists(redisProductHitsKey(1)) {
+		t.Fatalf("hits keys after the pass: %v; want only product 1's", mr.Keys())
+	}
+	if members, _ := mr.ZMembers(redisPopularityKey); !reflect.DeepEqual(members, []string{"1"}) {
+		t.Fatalf("popularity members after the pass: %v", members)
+	}
+	// last_access isn't in CLEANER_ORPHAN_KEYS
+	if members, _ := mr.ZMembers(redisLastAccessKey); len(members) != 2 {
+		t.Fatalf("last_access members after the pass: %v; want both kept", members)
+	}
+}
+
+// midScanFailureHook splits the first SCAN reply into two pages and fails
+// the SCAN continuing it, as a reconnect mid-pass would. It records the
+// cursor of every SCAN.
+type midScanFailureHook struct {
+	mu      sync.Mutex
+	split   bool
+	failed  bool
+	cursors []string
+}
+
+func (h *midScanFailureHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() != "scan" {
+		return ctx, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cursor := fmt.Sprint(cmd.Args()[1])
+	h.cursors = append(h.cursors, cursor)
+	if cursor != "0" && !h.failed {
+		h.failed = true
+		return ctx, errors.New("connection reset by peer")
+	}
+	return ctx, nil
+}
+
+func (h *midScanFailureHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	scan, ok := cmd.(*redis.ScanCmd)
+	if !ok || scan.Err() != nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.split {
+		h.split = true
+		keys, _ := scan.Val()
+		scan.SetVal(keys[:len(keys)/2], 42)
+	}
+	return nil
+}
+
+func (*midScanFailureHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (*midScanFailureHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func TestCleanerRestartsScanAfterMidPassError(t *testing.T) {
+	mr, _ := setupTest(t)
+	ctx := context.Background()
+	// Keys without a TTL are removed
+	for i := 100; i < 200; i++ {
+		mr.Set(redisProductKey(i), "{}")
+	}
+	hook := &midScanFailureHook{}
+	redisClient.AddHook(hook)
+
+	if err := cleanStaleProductKeys(ctx); err == nil {
+		t.Fatal("pass with a failed SCAN reported success")
+	}
+	if got := len(mr.Keys()); got != 50 {
+		t.Fatalf("after the interrupted pass: %d keys left, want the unscanned 50", got)
+	}
+	if err := cleanStaleProductKeys(ctx); err != nil {
+		t.Fatalf("next pass: %v", err)
+	}
+	if want := []string{"0", "42", "0"}; !reflect.DeepEqual(hook.cursors, want) {
+		t.Fatalf("SCAN cursors: got %v, want %v", hook.cursors, want)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatalf("keys survived the restarted pass: %v", keys)
+	}
+}
+
+func TestCleanerFailoverLogsOnceAndRecovers(t *testing.T) {
+	mr, _ := setupTest(t)
+	config.CleanerInterval = time.Millisecond
+	config.CleanerFailoverBackoff = 8 * time.Millisecond
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	counter := countCommands(t)
+	ctx := context.Background()
+
+	mr.SetError("ERR master link down")
+	for i := 0; i < 40; i++ {
+		runCleanerPass(ctx)
+		time.Sleep(time.Millisecond)
+	}
+	if n := strings.Count(logs.String(), "scan error"); n != 1 {
+		t.Fatalf("logged %d scan errors during the failover, want 1:\n%s", n, logs.String())
+	}
+	if n := counter.count("scan"); n == 0 || n >= 20 {
+		t.Fatalf("%d passes ran in 40 ticks; want backoff to skip most", n)
+	}
+
+	mr.SetError("")
+	for i := 0; i < 20 && !strings.Contains(logs.String(), "recovered"); i++ {
+		runCleanerPass(ctx)
+		time.Sleep(time.Millisecond)
+	}
+	if !strings.Contains(logs.String(), "Cache cleaner recovered after") {
+		t.Fatalf("no recovery logged:\n%s", logs.String())
+	}
+	if cleanerFailover.backingOff(time.Now()) {
+		t.Fatal("still backing off after a successful pass")
+	}
+}
+
+func TestCleanerThrottledToMaxOps(t *testing.T) {
+	mr, _ := setupTest(t)
+	const maxOps = 100
+	cleanerLimiter = newTokenBucket(maxOps, 1)
+	for id := 1; id <= 30; id++ {
+		mr.Set(redisProductKey(id), "x")
+		mr.SetTTL(redisProductKey(id), time.Minute)
+	}
+	counter := countCommands(t)
+
+	start := time.Now()
+	if err := cleanStaleProductKeys(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	ops := counter.count("scan") + counter.count("ttl") + counter.count("del")
+	// The burst of one is free; every command after it waits its turn
+	if rate := float64(ops-1) / elapsed.Seconds(); ops < 31 || rate > maxOps {
+		t.Fatalf("%d commands in %v: %.0f/s, want at most %d/s", ops, elapsed, rate, maxOps)
+	}
+
+	// A throttled pass stops when the cleaner is shut down
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := cleanStaleProductKeys(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("pass cut short by shutdown: got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// How often a cleaner that keeps failing may log, once backing off
+const cleanerFailureLogInterval = time.Minute
+
+// cleanerBackoff tracks consecutive failed cleaner passes. With
+// CLEANER_FAILOVER_BACKOFF set, a Redis outage or Sentinel failover makes the
+// cleaner wait exponentially longer between passes, up to that maximum, and
+// log the failure once and then at most every cleanerFailureLogInterval. The
+// first pass that succeeds again logs the recovery and resets it.
+type cleanerBackoff struct {
+	mu         sync.Mutex
+	failures   int       // consecutive failed passes
+	nextPass   time.Time // passes before this are skipped
+	lastLog    time.Time
+	suppressed int // error logs swallowed since lastLog
+}
+
+var cleanerFailover = &cleanerBackoff{}
+
+// Whether a pass due at now should be skipped to back off
+func (b *cleanerBackoff) backingOff(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Before(b.nextPass)
+}
+
+// Whether an error seen during the current pass should be logged. Without
+// CLEANER_FAILOVER_BACKOFF every error is.
+func (b *cleanerBackoff) allowLog(now time.Time) bool {
+	if config.CleanerFailoverBackoff <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures == 0 || now.Sub(b.lastLog) >= cleanerFailureLogInterval {
+		if b.suppressed > 0 {
+			log.Printf("Cache cleaner still failing after %d passes (%d errors not logged)", b.failures, b.suppressed)
+		}
+		b.lastLog = now
+		b.suppressed = 0
+		return true
+	}
+	b.suppressed++
+	return false
+}
+
+// Record the outcome of a pass started at now
+func (b *cleanerBackoff) record(now time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.failures > 0 && config.CleanerFailoverBackoff > 0 {
+			log.Printf("Cache cleaner recovered after %d failed passes (%d errors not logged)", b.failures, b.suppressed)
+		}
+		b.failures, b.suppressed = 0, 0
+		b.nextPass = time.Time{}
+		return
+	}
+	b.failures++
+	metrics.IncrCounter("cleaner_failed_passes_total", nil)
+	if config.CleanerFailoverBackoff <= 0 {
+		return
+	}
+	// The ticker already spaces passes one interval apart; wait out
+	// 2^(failures-1) intervals in total before the next one
+	delay := config.CleanerInterval
+	for i := 1; i < b.failures && delay < config.CleanerFailoverBackoff; i++ {
+		delay *= 2
+	}
+	if delay > config.CleanerFailoverBackoff {
+		delay = config.CleanerFailoverBackoff
+	}
+	b.nextPass = now.Add(delay - config.CleanerInterval)
+}
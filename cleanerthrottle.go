@@ -0,0 +1,22 @@
+package main
+
+import "context"
+
+// Shared budget for the cache cleaner's Redis commands; nil when
+// CLEANER_MAX_OPS is 0
+var cleanerLimiter *tokenBucket
+
+// Utility - wait until the cleaner may send n more Redis commands, so a pass
+// over a large keyspace is spread out instead of competing with requests.
+// Fails only when ctx ends.
+func cleanerOps(ctx context.Context, n int) error {
+	if cleanerLimiter == nil {
+		return ctx.Err()
+	}
+	for i := 0; i < n; i++ {
+		if err := cleanerLimiter.wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Clock is the source of the timestamps the service records (history,
+// cache envelopes, snapshots). Durations and deadlines use the time package
+// directly; only wall-clock values we store or return go through a Clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real clock, normalized to UTC
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now().UTC() }
+
+// The clock timestamps are taken from; replaceable for deterministic tests
+var clock Clock = systemClock{}
+
+// Clock sources for timestamps shared between instances
+const (
+	clockSourceLocal = "local" // this host's clock
+	clockSourceRedis = "redis" // the Redis server's clock (TIME)
+)
+
+// How often the offset to the Redis clock is re-measured
+const redisClockResync = time.Minute
+
+// redisClock tracks the offset between the local clock and Redis's, so
+// timestamps written to shared keys agree across a fleet whose hosts have
+// drifted apart. The offset is measured lazily with TIME and reused until
+// the next resync.
+type redisClock struct {
+	mu       sync.Mutex
+	offset   time.Duration
+	syncedAt time.Time
+}
+
+var sharedClock = &redisClock{}
+
+// Utility - the current time for data other instances also read: the
+// sliding hit window and cache snapshots. Expiry decisions don't use it;
+// they read the remaining TTL from Redis, which is authoritative.
+func sharedNow(ctx context.Context) time.Time {
+	if config.ClockSource != clockSourceRedis {
+		return clock.Now()
+	}
+	return sharedClock.now(ctx)
+}
+
+func (c *redisClock) now(ctx context.Context) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	local := time.Now()
+	if c.syncedAt.IsZero() || local.Sub(c.syncedAt) >= redisClockResync {
+		c.sync(ctx, local)
+	}
+	return clock.Now().Add(c.offset)
+}
+
+// Measure the offset, assuming the reply was produced halfway through the
+// round trip. On error the previous offset is kept until the next resync.
+func (c *redisClock) sync(ctx context.Context, start time.Time) {
+	c.syncedAt = start
+	server, err := redisClient.Time(ctx).Result()
+	if err != nil {
+		log.Printf("Redis clock sync failed, keeping offset %s: %v", c.offset, err)
+		return
+	}
+	rtt := time.Since(start)
+	offset := server.Sub(start.Add(rtt / 2))
+	if skewed(offset) && !skewed(c.offset) {
+		log.Printf("WARNING: local clock is %s off from Redis's", offset.Round(time.Millisecond))
+	}
+	c.offset = offset
+	metrics.SetGauge("redis_clock_offset_seconds", offset.Seconds(), nil)
+}
+
+// Utility - whether a clock offset is large enough to warn about
+func skewed(offset time.Duration) bool {
+	return offset > time.Second || offset < -time.Second
+}
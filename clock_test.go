@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// fixedClock is a Clock stopped at one instant
+type fixedClock struct{ t time.Time }
+
+func (c fixedClock) Now() time.Time { return c.t }
+
+func TestRedisClockSourceCorrectsSkew(t *testing.T) {
+	mr, _ := setupTest(t)
+	ctx := context.Background()
+	// This host's clock runs an hour behind the Redis server's
+	redisNow := time.Now().Add(time.Hour)
+	mr.SetTime(redisNow)
+
+	if got := sharedNow(ctx); got.Sub(time.Now()).Abs() > time.Second {
+		t.Fatalf("local clock source: got %v, want about now", got)
+	}
+	config.ClockSource = clockSourceRedis
+	if got := sharedNow(ctx); got.Sub(redisNow).Abs() > time.Second {
+		t.Fatalf("redis clock source: got %v, want about %v", got, redisNow)
+	}
+}
+
+func TestTTLRefreshIgnoresSkewedLocalClock(t *testing.T) {
+	for _, skew := range []time.Duration{-24 * time.Hour, 24 * time.Hour} {
+		mr, h := setupTest(t)
+		config.TTLRefreshInterval = 5 * time.Second
+		clock = fixedClock{time.Now().Add(skew)}
+
+		do(h, "GET", "/product/1", "")
+		counter := countCommands(t)
+		for i := 0; i < 10; i++ {
+			do(h, "GET", "/product/1", "")
+		}
+		if n := counter.count("expire"); n != 0 {
+			t.Fatalf("skew %v: %d Expires within the first interval, want none", skew, n)
+		}
+		// Only the TTL Redis reports decides when a refresh is due
+		mr.FastForward(config.TTLRefreshInterval)
+		do(h, "GET", "/product/1", "")
+		if n := counter.count("expire"); n != 2 {
+			t.Fatalf("skew %v: %d Expires once the interval passed, want one refresh (2)", skew, n)
+		}
+	}
+}
+
+func TestTimestampsComeFromClock(t *testing.T) {
+	mr, h := setupTest(t)
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock = fixedClock{at}
+
+	do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":120}`)
+	var changes []ProductChange
+	decodeBody(t, do(h, "GET", "/product/1/history", ""), &changes)
+	if len(changes) != 1 || !changes[0].At.Equal(at) {
+		t.Fatalf("history: got %+v, want one change at %v", changes, at)
+	}
+
+	do(h, "GET", "/product/2", "")
+	raw, _ := mr.Get(redisProductKey(2))
+	var entry cachedProductV1
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil || entry.CachedAt == nil || !entry.CachedAt.Equal(at) {
+		t.Fatalf("cache entry %s: cached_at %v, %v", raw, entry.CachedAt, err)
+	}
+}
+
+func TestSystemClockIsUTC(t *testing.T) {
+	if loc := (systemClock{}).Now().Location(); loc != time.UTC {
+		t.Fatalf("system clock location: got %v, want UTC", loc)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Kinds of error a Redis Cluster node gives a client that isn't cluster-aware
+const (
+	clusterErrMoved     = "moved"     // the key's slot lives on another node
+	clusterErrAsk       = "ask"       // the key's slot is migrating
+	clusterErrCrossSlot = "crossslot" // a multi-key command spans slots
+)
+
+// How often the cluster diagnostic is repeated while the errors continue
+const clusterWarnInterval = time.Minute
+
+var clusterWarn struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// Utility - which cluster error err is, or "" for any other error
+func clusterErrorKind(err error) string {
+	var reply redis.Error
+	if err == nil || !errors.As(err, &reply) {
+		return ""
+	}
+	msg := reply.Error()
+	switch {
+	case strings.HasPrefix(msg, "MOVED "):
+		return clusterErrMoved
+	case strings.HasPrefix(msg, "ASK "):
+		return clusterErrAsk
+	case strings.HasPrefix(msg, "CROSSSLOT"):
+		return clusterErrCrossSlot
+	}
+	return ""
+}
+
+// Count a cluster error and, at most once per clusterWarnInterval, explain
+// it: REDIS_ADDR is a cluster node but this client talks to it standalone
+func observeClusterError(cmd redis.Cmder) {
+	kind := clusterErrorKind(cmd.Err())
+	if kind == "" {
+		return
+	}
+	metrics.IncrCounter("redis_cluster_errors_total", Labels{"type": kind})
+	clusterWarn.mu.Lock()
+	due := time.Since(clusterWarn.last) >= clusterWarnInterval
+	if due {
+		clusterWarn.last = time.Now()
+	}
+	clusterWarn.mu.Unlock()
+	if !due {
+		return
+	}
+	hint := "; REDIS_CROSSSLOT_FALLBACK=true splits multi-key reads and deletes meanwhile"
+	if config.RedisCrossSlotFallback {
+		hint = ""
+	}
+	log.Printf("WARNING: Redis at REDIS_ADDR=%s answered %s with %q: it is a Redis Cluster node, but this service only "+
+		"speaks to a standalone Redis, so keys in other slots and multi-key commands fail. Point REDIS_ADDR at a standalone "+
+		"server or a cluster proxy%s.", redactAddr(config.RedisAddr), cmd.Name(), cmd.Err().Error(), hint)
+}
+
+// clusterHook is a go-redis hook reporting cluster errors
+type clusterHook struct{}
+
+func (clusterHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (clusterHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeClusterError(cmd)
+	return nil
+}
+
+func (clusterHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (clusterHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		observeClusterError(cmd)
+	}
+	return nil
+}
+
+// Utility - MGET keys. If the server rejects it as CROSSSLOT and
+// REDIS_CROSSSLOT_FALLBACK is on, the keys are read one GET each instead,
+// with any that fail (e.g. MOVED to another node) reported as missing.
+func cacheMGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if !config.RedisCrossSlotFallback || clusterErrorKind(err) != clusterErrCrossSlot {
+		return values, err
+	}
+	cmds := make([]*redis.StringCmd, len(keys))
+	redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	values = make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		if v, err := cmd.Result(); err == nil {
+			values[i] = v
+		}
+	}
+	return values, nil
+}
+
+// Utility - DEL keys, one DEL each under REDIS_CROSSSLOT_FALLBACK when the
+// server rejects the multi-key form as CROSSSLOT
+func cacheDel(ctx context.Context, keys ...string) error {
+	err := redisClient.Del(ctx, keys...).Err()
+	if !config.RedisCrossSlotFallback || clusterErrorKind(err) != clusterErrCrossSlot {
+		return err
+	}
+	_, err = redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
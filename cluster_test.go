@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// clusterReplyError is an error reply, like those a cluster node sends
+type clusterReplyError string
+
+func (e clusterReplyError) Error() string { return string(e) }
+func (clusterReplyError) RedisError()     {}
+
+// crossSlotHook answers like a cluster node: multi-key MGET and DEL get
+// CROSSSLOT and the key in another slot gets MOVED
+type crossSlotHook struct{ movedKey string }
+
+func (h crossSlotHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	args := cmd.Args()
+	switch {
+	case (cmd.Name() == "mget" || cmd.Name() == "del") && len(args) > 2:
+		return ctx, clusterReplyError("CROSSSLOT Keys in request don't hash to the same slot")
+	case len(args) > 1 && args[1] == h.movedKey:
+		return ctx, clusterReplyError("MOVED 3999 127.0.0.1:6381")
+	}
+	return ctx, nil
+}
+
+func (crossSlotHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (h crossSlotHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		if _, err := h.BeforeProcess(ctx, cmd); err != nil {
+			cmd.SetErr(err)
+		}
+	}
+	return ctx, nil
+}
+
+func (crossSlotHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+// Utility - point the service at a "cluster node" and capture the log
+func clusterTestSetup(t *testing.T) (*countingRecorder, *bytes.Buffer) {
+	t.Helper()
+	setupTest(t)
+	rec := newCountingRecorder()
+	metrics = rec
+	redisClient.AddHook(crossSlotHook{movedKey: redisProductKey(3)})
+	clusterWarn.mu.Lock()
+	clusterWarn.last = time.Time{}
+	clusterWarn.mu.Unlock()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return rec, &logs
+}
+
+func TestCrossSlotDiagnostic(t *testing.T) {
+	rec, logs := clusterTestSetup(t)
+
+	if _, err := cacheMGet(context.Background(), redisProductKey(1), redisProductKey(2)); clusterErrorKind(err) != clusterErrCrossSlot {
+		t.Fatalf("MGET against a cluster node: got %v", err)
+	}
+	if !strings.Contains(logs.String(), "Redis Cluster node") || !strings.Contains(logs.String(), "REDIS_CROSSSLOT_FALLBACK=true") {
+		t.Fatalf("no cluster diagnostic logged:\n%s", logs)
+	}
+	redisClient.Get(context.Background(), redisProductKey(3))
+	if strings.Count(logs.String(), "WARNING") != 1 {
+		t.Fatalf("diagnostic repeated within clusterWarnInterval:\n%s", logs)
+	}
+	if rec.counter(`redis_cluster_errors_total{type="crossslot"}`) != 1 || rec.counter(`redis_cluster_errors_total{type="moved"}`) != 1 {
+		t.Fatalf("cluster errors counted: %v", rec.counters)
+	}
+}
+
+func TestCrossSlotFallback(t *testing.T) {
+	clusterTestSetup(t)
+	config.RedisCrossSlotFallback = true
+	ctx := context.Background()
+	redisClient.Set(ctx, redisProductKey(1), "one", 0)
+	redisClient.Set(ctx, redisProductKey(2), "two", 0)
+
+	values, err := cacheMGet(ctx, redisProductKey(1), redisProductKey(2), redisProductKey(3))
+	if err != nil || values[0] != "one" || values[1] != "two" || values[2] != nil {
+		t.Fatalf("split MGET: got %v, %v; want one, two and the MOVED key missing", values, err)
+	}
+	if err := cacheDel(ctx, redisProductKey(1), redisProductKey(2)); err != nil {
+		t.Fatalf("split DEL: %v", err)
+	}
+	if n, _ := redisClient.Exists(ctx, redisProductKey(1)).Result(); n != 0 {
+		t.Fatal("split DEL left the key")
+	}
+}
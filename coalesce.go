@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// putCoalescer collapses identical full-replacement PUTs to the same ID:
+// concurrent ones share a single saveProduct call, and a repeat arriving
+// within PUT_COALESCE_WINDOW of a finished write is answered from that write
+// as long as the product hasn't changed since.
+type putCoalescer struct {
+	mu     sync.Mutex
+	recent map[int]coalescedPut
+	group  singleflight.Group
+}
+
+type coalescedPut struct {
+	hash    string
+	result  Product
+	savedAt time.Time
+}
+
+var putCoalescing = &putCoalescer{recent: map[int]coalescedPut{}}
+
+// Utility - saveProduct, collapsing identical PUTs when PUT_COALESCE_WINDOW is set
+func saveProductCoalesced(ctx context.Context, input Product) (Product, error) {
+	if config.PutCoalesceWindow <= 0 {
+		return saveProduct(ctx, input)
+	}
+	return putCoalescing.save(ctx, input)
+}
+
+func (c *putCoalescer) save(ctx context.Context, input Product) (Product, error) {
+	hash := putHash(input)
+	if product, ok := c.lookup(ctx, input.ID, hash); ok {
+		metrics.IncrCounter("product_put_coalesced_total", nil)
+		return product, nil
+	}
+
+	v, err, shared := awaitShared(ctx, c.group.DoChan(fmt.Sprintf("%d:%s", input.ID, hash), func() (interface{}, error) {
+		// Detach from the caller's context: the write is shared with other
+		// waiters, so one client disconnecting shouldn't fail them all.
+		updated, err := saveProduct(context.Background(), input)
+		if err == nil {
+			c.sweep()
+			c.mu.Lock()
+			c.recent[input.ID] = coalescedPut{hash: hash, result: updated, savedAt: time.Now()}
+			c.mu.Unlock()
+		}
+		return updated, err
+	}))
+	if shared {
+		metrics.IncrCounter("product_put_coalesced_total", nil)
+	}
+	if err != nil {
+		return Product{}, err
+	}
+	return v.(Product), nil
+}
+
+// The result of a recent identical write, if the product still has the
+// version that write produced
+func (c *putCoalescer) lookup(ctx context.Context, id int, hash string) (Product, bool) {
+	c.mu.Lock()
+	prev, ok := c.recent[id]
+	if ok && time.Since(prev.savedAt) > config.PutCoalesceWindow {
+		delete(c.recent, id)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok || prev.hash != hash {
+		return Product{}, false
+	}
+	current, err := readProductFromDB(ctx, id)
+	if err != nil || current.Version != prev.result.Version {
+		return Product{}, false
+	}
+	return current, true
+}
+
+// Drop remembered writes older than the window
+func (c *putCoalescer) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, prev := range c.recent {
+		if time.Since(prev.savedAt) > config.PutCoalesceWindow {
+			delete(c.recent, id)
+		}
+	}
+}
+
+// Utility - identity of a PUT body for coalescing, over every field of the
+// input, so PUTs differing in any of them (no_cache included) are each saved
+func putHash(p Product) string {
+	raw, _ := json.Marshal(p)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdenticalConcurrentPutsWriteOnce(t *testing.T) {
+	_, h := setupTest(t)
+	config.PutCoalesceWindow = time.Second
+	body := `{"id":1,"name":"Apricot","price":90}`
+
+	// Hold the DB so every PUT arrives while the first write is pending
+	fakeDBLock.Lock()
+	var wg sync.WaitGroup
+	codes := make([]int, 20)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = do(h, "PUT", "/product/1", body).Code
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	fakeDBLock.Unlock()
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusNoContent {
+			t.Fatalf("PUT %d: got %d", i, code)
+		}
+	}
+	if p, _ := dbProduct(1); p.Version != 2 {
+		t.Fatalf("identical PUTs wrote %d times", p.Version-1)
+	}
+
+	// A retry within the window is answered from the write
+	do(h, "PUT", "/product/1", body)
+	if p, _ := dbProduct(1); p.Version != 2 {
+		t.Fatalf("retry within the window wrote again (version %d)", p.Version)
+	}
+	// A different body is its own write
+	do(h, "PUT", "/product/1", `{"id":1,"name":"Apricot","price":95}`)
+	if p, _ := dbProduct(1); p.Version != 3 || p.Price != 95 {
+		t.Fatalf("different body: got %+v", p)
+	}
+}
+
+func TestPutsNotCoalescedWithoutWindow(t *testing.T) {
+	_, h := setupTest(t)
+	for i := 0; i < 3; i++ {
+		do(h, "PUT", "/product/1", `{"id":1,"name":"Apricot","price":90}`)
+	}
+	if p, _ := dbProduct(1); p.Version != 4 {
+		t.Fatalf("3 PUTs without PUT_COALESCE_WINDOW: version %d, want 4", p.Version)
+	}
+}
+
+func TestPutTogglingNoCacheNotCoalesced(t *testing.T) {
+	_, h := setupTest(t)
+	config.PutCoalesceWindow = time.Second
+
+	do(h, "PUT", "/product/1", `{"id":1,"name":"Apricot","price":90}`)
+	if w := do(h, "PUT", "/product/1", `{"id":1,"name":"Apricot","price":90,"no_cache":true}`); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT setting no_cache: got %d", w.Code)
+	}
+	if p, _ := dbProduct(1); !p.NoCache || p.Version != 3 {
+		t.Fatalf("PUT only setting no_cache was coalesced: stored %+v", p)
+	}
+}
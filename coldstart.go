@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const coldStartPollInterval = 25 * time.Millisecond // while waiting for another reader's entry
+
+// End of the COLD_START_GRACE window as unix nanos, set once initialization
+// finishes
+var coldStartUntil int64
+
+// Concurrent cold-start misses on this instance, by product ID
+var coldStartReads singleflight.Group
+
+// coldStartResult is a product read during the grace window
+type coldStartResult struct {
+	product   Product
+	fromCache bool // another reader populated it while we waited
+}
+
+// Utility - warm the cache with the COLD_START_WARM most popular products
+// that aren't cached yet, then open the COLD_START_GRACE window. Instances
+// starting together skip whatever another has already warmed.
+func warmColdStart(ctx context.Context) {
+	if config.ColdStartWarm > 0 {
+		warmPopularProducts(ctx, config.ColdStartWarm)
+	}
+	if config.ColdStartGrace > 0 {
+		atomic.StoreInt64(&coldStartUntil, time.Now().Add(config.ColdStartGrace).UnixNano())
+	}
+}
+
+func warmPopularProducts(ctx context.Context, n int) {
+	members, err := redisClient.ZRevRange(ctx, redisPopularityKey, 0, int64(n-1)).Result()
+	if err != nil {
+		log.Printf("Cache warming skipped: %v", err)
+		return
+	}
+	var ids []int
+	var keys []string
+	for _, m := range members {
+		if id, err := strconv.Atoi(m); err == nil {
+			ids = append(ids, id)
+			keys = append(keys, redisProductKey(id))
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	cached, err := cacheMGet(ctx, keys...)
+	if err != nil {
+		log.Printf("Cache warming skipped: %v", err)
+		return
+	}
+	var fills []cacheFill
+	for i, id := range ids {
+		if cached[i] != nil {
+			continue
+		}
+		product, gen, err := readProductWithGeneration(ctx, id)
+		if err != nil {
+			continue
+		}
+		fills = append(fills, cacheFill{product: product, gen: gen})
+	}
+	warmed, failed := populateProductCaches(ctx, fills)
+	if len(failed) > 0 {
+		log.Printf("Cache warming could not write products %v", failed)
+	}
+	log.Printf("Warmed the cache with %d of the %d most popular products", len(warmed), len(ids))
+}
+
+// Utility - whether cache misses still go through loadColdStart
+func inColdStartGrace() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&coldStartUntil)
+}
+
+// Read a product that missed the cache during the grace window. Concurrent
+// misses on this instance share one read; across instances only the holder
+// of the product's populate lock reads the DB and populates the cache, while
+// the others wait up to POPULATE_LOCK_TTL for its entry before reading the DB
+// themselves. That bounds the DB reads of a cold fleet to about one per
+// product.
+func loadColdStart(ctx context.Context, id int) (coldStartResult, error) {
+	v, err, _ := awaitShared(ctx, coldStartReads.DoChan(strconv.Itoa(id), func() (interface{}, error) {
+		// Detached: the result is shared with other waiters
+		return readColdStart(context.Background(), id)
+	}))
+	if err != nil {
+		return coldStartResult{}, err
+	}
+	return v.(coldStartResult), nil
+}
+
+func readColdStart(ctx context.Context, id int) (coldStartResult, error) {
+	if token, ok, err := acquirePopulateLock(ctx, id); ok || err != nil {
+		// Ours, or Redis is failing and there's nobody to wait for
+		if ok {
+			defer releasePopulateLock(ctx, id, token)
+		}
+		product, gen, err := readProductWithGeneration(ctx, id)
+		if err != nil {
+			return coldStartResult{}, err
+		}
+		if !product.NoCache {
+			writeProductCacheEntry(ctx, product, gen, false)
+		}
+		return coldStartResult{product: product}, nil
+	}
+
+	metrics.IncrCounter("cold_start_waits_total", nil)
+	deadline := time.Now().Add(config.PopulateLockTTL)
+	for time.Now().Before(deadline) {
+		time.Sleep(coldStartPollInterval)
+		data, err := redisClient.Get(ctx, redisProductKey(id)).Result()
+		if err != nil || data == redisTombstoneValue {
+			continue
+		}
+		if product, err := decodeCachedProductFor(data, config.CacheSchemaVersion, id); err == nil {
+			return coldStartResult{product: product, fromCache: true}, nil
+		}
+	}
+	product, err := readProductFromDB(ctx, id)
+	return coldStartResult{product: product}, err
+}
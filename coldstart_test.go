@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Send n concurrent GETs of path while the DB is held for hold, returning
+// their status codes
+func coldBurst(h http.Handler, path string, n int, hold time.Duration) []int {
+	fakeDBLock.Lock()
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = do(h, "GET", path, "").Code
+		}(i)
+	}
+	time.Sleep(hold)
+	fakeDBLock.Unlock()
+	wg.Wait()
+	return codes
+}
+
+func TestColdStartBurstReadsDBOnce(t *testing.T) {
+	_, h := setupTest(t)
+	config.ColdStartGrace = time.Second
+	warmColdStart(context.Background())
+	counter := countCommands(t)
+
+	for i, code := range coldBurst(h, "/product/1", 20, 50*time.Millisecond) {
+		if code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, code)
+		}
+	}
+	// Each DB read during the grace window first takes the populate lock
+	if n := counter.countKey("set", redisProductPopulateLockKey(1)); n != 1 {
+		t.Fatalf("cold burst read the DB %d times, want once", n)
+	}
+}
+
+func TestColdStartWaitsForAnotherInstancesEntry(t *testing.T) {
+	_, h := setupTest(t)
+	config.ColdStartGrace = time.Second
+	config.DBLockTimeout = 20 * time.Millisecond
+	warmColdStart(context.Background())
+
+	// Another instance holds the populate lock and is reading the DB
+	if _, ok, err := acquirePopulateLock(context.Background(), 1); !ok || err != nil {
+		t.Fatalf("acquiring the populate lock: %v, %v", ok, err)
+	}
+	go func() {
+		time.Sleep(60 * time.Millisecond)
+		apple := Product{ID: 1, Name: "Apple", Price: 100, Version: 1}
+		redisClient.Set(context.Background(), redisProductKey(1), encodeCachedProduct(apple, cacheSchemaV1), 0)
+	}()
+	// The DB stays unavailable: every answer must come from that entry
+	for i, code := range coldBurst(h, "/product/1", 10, 150*time.Millisecond) {
+		if code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, code)
+		}
+	}
+}
+
+func TestColdStartWarmsPopularProducts(t *testing.T) {
+	mr, _ := setupTest(t)
+	config.ColdStartWarm = 2
+	redisClient.ZAdd(context.Background(), redisPopularityKey,
+		&redis.Z{Score: 9, Member: "3"}, &redis.Z{Score: 5, Member: "2"}, &redis.Z{Score: 1, Member: "1"})
+
+	warmColdStart(context.Background())
+	if !mr.Exists(redisProductKey(3)) || !mr.Exists(redisProductKey(2)) || mr.Exists(redisProductKey(1)) {
+		t.Fatalf("warmed keys: %v, want products 2 and 3", mr.Keys())
+	}
+	if inColdStartGrace() {
+		t.Fatal("grace window opened without COLD_START_GRACE")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+)
+
+// Utility - choose a response content coding from Accept-Encoding per
+// RFC 7231 section 5.3.4. Codings not listed take the q of "*" if present;
+// identity is acceptable unless excluded by q=0, explicitly or through
+// "*;q=0". gzip wins ties. Returns "" when neither coding is acceptable.
+func negotiateEncoding(header string) string {
+	if strings.TrimSpace(header) == "" {
+		return encodingIdentity
+	}
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		for _, param := range fields[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || f < 0 || f > 1 {
+					f = 0 // malformed weights don't make a coding acceptable
+				}
+				weight = f
+			}
+		}
+		if coding == "x-gzip" {
+			coding = encodingGzip
+		}
+		q[coding] = weight
+	}
+	weight := func(coding string, def float64) float64 {
+		if w, ok := q[coding]; ok {
+			return w
+		}
+		if w, ok := q["*"]; ok {
+			return w
+		}
+		return def
+	}
+	gzipQ, identityQ := weight(encodingGzip, 0), weight(encodingIdentity, 1)
+	switch {
+	case gzipQ > 0 && gzipQ >= identityQ:
+		return encodingGzip
+	case identityQ > 0:
+		return encodingIdentity
+	default:
+		return ""
+	}
+}
+
+// Middleware - with COMPRESSION, gzip responses for clients that accept it.
+// A client that rules out both gzip and identity gets 406 rather than a body
+// it said it can't use.
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.Compression {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		switch negotiateEncoding(r.Header.Get("Accept-Encoding")) {
+		case encodingGzip:
+			gw := &gzipResponseWriter{ResponseWriter: w, head: r.Method == http.MethodHead}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		case encodingIdentity:
+			next.ServeHTTP(w, r)
+		default:
+			http.Error(w, "No acceptable content coding", http.StatusNotAcceptable)
+		}
+	})
+}
+
+// gzipResponseWriter compresses the body once the status is known. Responses
+// without a body (HEAD, 204, 304) and ones the handler already encoded pass
+// through untouched.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	head        bool
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	h := g.Header()
+	if !g.head && status != http.StatusNoContent && status != http.StatusNotModified &&
+		status >= http.StatusOK && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", encodingGzip)
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.gz.Write(b)
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+// Middleware - inflate request bodies sent with Content-Encoding: gzip, so
+// handlers always decode plain bodies. The body is inflated up front, and
+// one growing past MAX_DECOMPRESSED_BODY_BYTES is rejected with 413 before
+// it can exhaust memory (a decompression bomb). Other codings, and gzip when
+// the limit is 0, get 415.
+func requestDecompressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		coding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if coding == "" || coding == encodingIdentity {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if (coding != encodingGzip && coding != "x-gzip") || config.MaxDecompressedBodyBytes <= 0 {
+			if config.MaxDecompressedBodyBytes > 0 {
+				w.Header().Set("Accept-Encoding", encodingGzip)
+			}
+			http.Error(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body, err := io.ReadAll(io.LimitReader(gz, int64(config.MaxDecompressedBodyBytes)+1))
+		if err != nil {
+			http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > config.MaxDecompressedBodyBytes {
+			metrics.IncrCounter("request_decompression_rejected_total", nil)
+			http.Error(w, "Decompressed body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Del("Content-Encoding")
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		next.ServeHTTP(w, r)
+	})
+}
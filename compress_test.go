@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                           encodingIdentity,
+		"gzip":                       encodingGzip,
+		"identity;q=0, gzip":         encodingGzip,
+		"gzip;q=0.5, identity;q=1":   encodingIdentity,
+		"gzip;q=0":                   encodingIdentity,
+		"*;q=0":                      "",
+		"*;q=0, gzip;q=0.1":          encodingGzip,
+		"br, identity;q=0":           "",
+		"gzip;q=abc, identity;q=0":   "",
+		"X-GZIP;Q=0.8, identity;q=0": encodingGzip,
+	} {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("%q: got %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCompressionHonoursForbiddenIdentity(t *testing.T) {
+	_, h := setupTest(t)
+	config.Compression = true
+
+	w := do(h, "GET", "/product/1", "", "Accept-Encoding", "identity;q=0, gzip")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != encodingGzip {
+		t.Fatalf("identity;q=0, gzip: got %d, Content-Encoding %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if len(body) == 0 {
+		t.Fatal("empty gzip body")
+	}
+
+	if w := do(h, "GET", "/product/1", "", "Accept-Encoding", "*;q=0"); w.Code != http.StatusNotAcceptable {
+		t.Fatalf("*;q=0: got %d, want 406", w.Code)
+	}
+	w = do(h, "GET", "/product/1", "", "Accept-Encoding", "gzip;q=0")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("gzip;q=0: got %d, Content-Encoding %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+}
+
+// Send body gzip-compressed, with the given Content-Encoding
+func doGzip(h http.Handler, path, body, encoding string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(body))
+	gz.Close()
+	req := httptest.NewRequest("POST", path, &buf)
+	req.Header.Set("Content-Encoding", encoding)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestGzipRequestBody(t *testing.T) {
+	_, h := setupTest(t)
+
+	w := doGzip(h, "/products/bulk", `[{"op":"update","product":{"id":1,"name":"Green Apple","price":120}}]`, "gzip")
+	if w.Code != http.StatusOK {
+		t.Fatalf("gzip bulk import: got %d %s", w.Code, w.Body)
+	}
+	if p, _ := dbProduct(1); p.Name != "Green Apple" {
+		t.Fatalf("gzip bulk import not applied: got %+v", p)
+	}
+	if w := doGzip(h, "/product", `{"name":"Date","price":5}`, "br"); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Content-Encoding br: got %d, want 415", w.Code)
+	}
+}
+
+func TestGzipBombRejected(t *testing.T) {
+	_, h := setupTest(t)
+	config.MaxDecompressedBodyBytes = 1 << 10
+
+	// Well under the limit compressed, far over it inflated
+	bomb := `{"name":"` + strings.Repeat("a", 1<<20) + `","price":5}`
+	if w := doGzip(h, "/product", bomb, "gzip"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("decompression bomb: got %d, want 413", w.Code)
+	}
+	if len(fakeProductDB) != 3 {
+		t.Fatal("product created from a decompression bomb")
+	}
+}
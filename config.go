@@ -0,0 +1,695 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds runtime settings, read at startup from an optional config
+// file (see configfile.go) overridden by environment variables
+type Config struct {
+	RedisAddr string
+
+	// HTTPAddr and GRPCAddr are the listen addresses; an empty GRPCAddr
+	// disables the gRPC server. ShutdownTimeout bounds draining on exit.
+	HTTPAddr        string
+	GRPCAddr        string
+	ShutdownTimeout time.Duration
+
+	// PriceParseMode controls how fractional prices are handled on input:
+	// "cents" rejects any non-zero fraction, "round" rounds to the nearest unit
+	PriceParseMode string
+
+	// MaxProducts caps the number of products in the DB; creates beyond it
+	// are rejected. Zero means unlimited.
+	MaxProducts int
+
+	// TombstoneTTL is how long a mutated product's cache key holds a
+	// tombstone instead of being deleted. Zero disables tombstoning.
+	TombstoneTTL time.Duration
+
+	// AdminToken is the bearer token for /admin endpoints; empty disables them
+	AdminToken string
+
+	// MaxCacheTTL bounds any TTL set on a product cache key
+	MaxCacheTTL time.Duration
+
+	// PopulateLock makes concurrent cache misses for the same product take a
+	// short SETNX lock so only the first reader writes the cache
+	PopulateLock    bool
+	PopulateLockTTL time.Duration
+
+	// IDStrategy selects how POST /product assigns IDs: "max_plus_one",
+	// "redis_incr" or "random"
+	IDStrategy string
+
+	// Request size limits: URIs or query strings beyond these get 414, header
+	// blocks beyond MaxHeaderBytes get 431. Zero disables the URI checks.
+	MaxURLLength   int
+	MaxQueryLength int
+	MaxHeaderBytes int
+
+	// CORSAllowedOrigins lists origins allowed cross-origin access ("*" for
+	// any); empty disables CORS. CORSMaxAge is sent on preflight responses.
+	CORSAllowedOrigins []string
+	CORSMaxAge         time.Duration
+
+	// KnownVersionResponse is what GET ?known_version=N returns when N is
+	// current: "not_modified" (bare 304) or "minimal" (small 200 body)
+	KnownVersionResponse string
+
+	// MetricsBackend is "none", "prometheus" (served on /metrics) or "statsd"
+	MetricsBackend string
+	StatsDAddr     string
+	StatsDPrefix   string
+
+	// CacheReadOnlyRecheck is how often a write is retried once Redis has
+	// answered READONLY; in between, cache writes are skipped
+	CacheReadOnlyRecheck time.Duration
+
+	// CacheBypassEnabled honours Cache-Control: no-cache / ?no_cache=true on
+	// GET, limited to CacheBypassRate per second (burst CacheBypassBurst)
+	// across all clients. Zero rate means unlimited.
+	CacheBypassEnabled bool
+	CacheBypassRate    float64
+	CacheBypassBurst   int
+
+	// CacheBypassIPRate/Burst is a stricter per-client-IP budget applied to
+	// bypassing reads on top of the global one
+	CacheBypassIPRate  float64
+	CacheBypassIPBurst int
+
+	// CacheUpdateMode is "invalidate" (drop or tombstone the entry on PUT) or
+	// "write_through" (store the updated product). HitsOnUpdate decides the
+	// hit counter under write-through: "reset", "preserve" or "one".
+	CacheUpdateMode string
+	HitsOnUpdate    string
+
+	// DBLockTimeout bounds how long a request waits for the product DB lock
+	// before failing with 503. Zero waits indefinitely.
+	DBLockTimeout time.Duration
+
+	// InvalidationPubSub publishes changed product IDs on InvalidationChannel
+	// so every instance drops in-process copies. Changes within
+	// InvalidationBatchWindow are coalesced into one message.
+	InvalidationPubSub      bool
+	InvalidationChannel     string
+	InvalidationBatchWindow time.Duration
+
+	// TTLRefreshInterval throttles popular-item TTL refreshes to at most one
+	// per interval per key. Zero refreshes on every hit.
+	TTLRefreshInterval time.Duration
+
+	// CacheOnHead makes HEAD /product/{id} populate the cache and count a
+	// hit like GET; by default HEAD only peeks
+	CacheOnHead bool
+
+	// AdminReadsFromDB makes /admin read endpoints always read the DB,
+	// never the cache
+	AdminReadsFromDB bool
+
+	// CleanerInterval is the period of the stale-key cleaner; its first pass
+	// is delayed by a random amount up to CleanerStartJitter
+	CleanerInterval    time.Duration
+	CleanerStartJitter time.Duration
+
+	// CleanerOrphanKeys lists auxiliary key types ("hits", "popularity",
+	// "last_access") the cleaner reaps once their product is gone
+	CleanerOrphanKeys []string
+
+	// CleanerFailoverBackoff, when set, is the longest the cleaner backs off
+	// between passes while Redis keeps failing; it also rate-limits the
+	// cleaner's error logs meanwhile
+	CleanerFailoverBackoff time.Duration
+
+	// CacheSchemaVersion selects the cache entry format and key namespace.
+	// CacheMigrationMode lets readers fall back to (and upgrade) entries in
+	// the previous version while a schema change rolls out.
+	CacheSchemaVersion int
+	CacheMigrationMode bool
+
+	// NotFoundBody is a custom JSON body for product 404s; "{id}" and
+	// "{suggestions}" are substituted. Empty keeps the plain-text default.
+	NotFoundBody string
+
+	// ResponseDedupWindow is how long a serialized GET /product/{id} body
+	// is reused for identical requests; zero disables the response cache
+	ResponseDedupWindow time.Duration
+
+	// EmptyListItems is how an empty /products page is encoded: "array"
+	// ([]) or "null" for clients written against the old behaviour
+	EmptyListItems string
+
+	// EventBufferSize bounds each /products/events subscriber's queue;
+	// SlowConsumerPolicy decides what happens when it is full
+	EventBufferSize    int
+	SlowConsumerPolicy string
+
+	// IdempotencyKeyTTL is how long a completed request's response is kept
+	// for replay. IdempotencyInFlightTTL bounds the in-progress marker, so a
+	// crashed request doesn't block its key for the full lifetime, and
+	// IdempotencyWait is how long a concurrent retry waits for it.
+	IdempotencyKeyTTL      time.Duration
+	IdempotencyInFlightTTL time.Duration
+	IdempotencyWait        time.Duration
+
+	// BatchDuplicateIDs is "dedup" or "preserve", for repeated IDs in
+	// /products/batch
+	BatchDuplicateIDs string
+
+	// LocationStyle is "relative" or "absolute" for the Location of created
+	// products. X-Forwarded-Host/Proto are trusted only from TrustedProxies
+	// (IPs or CIDRs).
+	LocationStyle  string
+	TrustedProxies []string
+
+	// NameWhitespace is "trim", "reject" or "keep" for padded product names
+	NameWhitespace string
+
+	// CacheSnapshotPath, if set, is where the product cache is saved on
+	// shutdown and reloaded from on startup
+	CacheSnapshotPath string
+
+	// RequireUserAgent rejects requests without a User-Agent header
+	RequireUserAgent bool
+
+	// BaseCurrency is the currency prices are stored in; ExchangeRates
+	// ("EUR=0.92", units per base unit) enable ?currency= conversion
+	BaseCurrency  string
+	ExchangeRates []string
+
+	// ValidationStatus is the status (400 or 422) for products that parse
+	// but break a business rule
+	ValidationStatus int
+
+	// BreakerFailures consecutive Redis connection failures open the
+	// circuit breaker for BreakerOpenTimeout; 0 disables it.
+	// ReadyzFailOnBreakerOpen makes /readyz report 503 while it is open.
+	// BreakerOpenResponse is "fallback" (serve from the DB) or
+	// "unavailable" (503 with Retry-After) while it is open.
+	BreakerFailures         int
+	BreakerOpenTimeout      time.Duration
+	ReadyzFailOnBreakerOpen bool
+	BreakerOpenResponse     string
+
+	// BatchPopulateRetries is how many times cache writes that failed in a
+	// batch populate pipeline are sent again
+	BatchPopulateRetries int
+
+	// CanarySampleRate is the fraction of cache hits also read from the DB
+	// in the background and compared (0 = off)
+	CanarySampleRate float64
+
+	// MaxDecompressedBodyBytes caps gzip request bodies once inflated;
+	// 0 rejects compressed bodies
+	MaxDecompressedBodyBytes int
+
+	// AccessLog logs every request; with LogSampling, successful requests
+	// faster than SlowRequestThreshold only at LogSampleRate
+	AccessLog            bool
+	LogSampling          bool
+	LogSampleRate        float64
+	SlowRequestThreshold time.Duration
+
+	// RedisCrossSlotFallback splits multi-key reads and deletes into one
+	// command per key when Redis rejects them as CROSSSLOT
+	RedisCrossSlotFallback bool
+
+	// CacheSizeMemorySamples is how many keys GET /admin/cache/size
+	// measures with MEMORY USAGE to estimate the cache's memory
+	CacheSizeMemorySamples int
+
+	// CleanerMaxOps caps the cache cleaner's Redis commands per second
+	// (0 = unlimited)
+	CleanerMaxOps float64
+
+	// CacheEpoch is the lowest cache epoch entries must carry to be served;
+	// POST /admin/cache/epoch raises the fleet's epoch past it at runtime.
+	// CacheEpochPollInterval is how often other instances pick that up.
+	CacheEpoch             int
+	CacheEpochPollInterval time.Duration
+
+	// CacheMaxAge is the longest a product cache entry is served after it
+	// was cached, TTL refreshes notwithstanding (0 = no limit)
+	CacheMaxAge time.Duration
+
+	// MetricsExemplars attaches the trace ID of sampled requests to the
+	// request duration histogram as OpenMetrics exemplars
+	MetricsExemplars bool
+
+	// HitsMode is "cumulative" or "sliding"; in sliding mode the popularity
+	// threshold applies to hits within the last HitsWindow
+	HitsMode   string
+	HitsWindow time.Duration
+
+	// ServeStaleOnError answers reads that fail (cache and DB both
+	// unavailable) with the last good copy, up to StaleMaxAge old
+	ServeStaleOnError bool
+	StaleMaxAge       time.Duration
+
+	// Deprecations lists deprecated routes and parameters, as
+	// path[?param][@sunset-date]
+	Deprecations []string
+
+	// PutIDPolicy is how PUT /product/{id} treats the body's id: "strict",
+	// "path_wins" or "body_optional"
+	PutIDPolicy string
+
+	// BulkMode is "all_or_nothing" or "best_effort" for /products/bulk
+	BulkMode string
+
+	// ReadOnly permanently refuses product mutations, for read replicas
+	ReadOnly bool
+
+	// DebugToken, sent as X-Debug, unlocks cache diagnostic headers
+	DebugToken string
+
+	// PutCoalesceWindow collapses identical PUTs to one ID arriving within
+	// it into a single write; 0 disables coalescing
+	PutCoalesceWindow time.Duration
+
+	// ClockSource is the clock for timestamps shared between instances:
+	// "local" or "redis"
+	ClockSource string
+
+	// HistorySize is how many changes are kept per product for
+	// /product/{id}/history, and the most it returns; 0 disables history
+	HistorySize int
+
+	// CacheIDCheck treats a cache entry whose product ID differs from the
+	// key's as corrupt
+	CacheIDCheck bool
+
+	// RateLimit/Burst is the per-client-IP request budget (requests per
+	// second); 0 disables rate limiting
+	RateLimit      float64
+	RateLimitBurst int
+
+	// LogEffectiveConfig logs the effective settings once at startup
+	LogEffectiveConfig bool
+
+	// Compression gzips responses for clients whose Accept-Encoding allows it
+	Compression bool
+
+	// PopularityEvents publishes a "popular" event when a product's hits
+	// first reach the popularity threshold
+	PopularityEvents bool
+
+	// StartupGate starts the HTTP listener before initialization and answers
+	// 503 until it completes, instead of listening only once ready
+	StartupGate bool
+
+	// RequestTimeout is the total time budget of a request, shared by every
+	// wait it makes; 0 disables it
+	RequestTimeout time.Duration
+
+	// Tracing exports OpenTelemetry spans over OTLP/HTTP, sampling
+	// TraceSampleRatio of new traces, under TracingServiceName
+	Tracing            bool
+	TraceSampleRatio   float64
+	TracingServiceName string
+
+	// ListMaxOffset rejects GET /products offsets beyond it (0 = no cap)
+	ListMaxOffset int
+
+	// StrictAccept answers 406 to a product request whose Accept header
+	// matches no supported representation, instead of sending JSON anyway
+	StrictAccept bool
+
+	// PublicFields, when set, is the JSON field set of products shown to
+	// clients without one of APITokens as their bearer token
+	PublicFields []string
+	APITokens    []string
+
+	// MaxCatalogValue rejects creates and updates that would push the sum
+	// of all product prices above it (0 = no limit)
+	MaxCatalogValue Price
+
+	// BatchStreamChunk is how many IDs a streamed batch looks up (one MGET)
+	// between flushes
+	BatchStreamChunk int
+
+	// DeletedHits is "delete", "archive" or "event": what becomes of a
+	// product's hit count when it's deleted
+	DeletedHits string
+
+	// MaxStaleness is the oldest product data operators accept being
+	// served; settings exceeding it are warned about at startup (0 = off)
+	MaxStaleness time.Duration
+
+	// AsyncWrites queues PUTs in Redis and applies them in the background,
+	// answering 202; write statuses are kept for AsyncWriteStatusTTL
+	AsyncWrites         bool
+	AsyncWriteStatusTTL time.Duration
+
+	// ColdStartWarm caches the most popular products during startup, and
+	// for ColdStartGrace afterwards cache misses are read once per product
+	// across the fleet, under the populate lock
+	ColdStartWarm  int
+	ColdStartGrace time.Duration
+
+	// ProductLinks adds HAL-style _links to product responses for every
+	// client, not only those asking for application/hal+json
+	ProductLinks bool
+
+	// ShutdownDrainDelay is how long shutdown waits with /readyz failing
+	// before closing listeners. With ConsulAddr and ConsulServiceID set the
+	// service is first deregistered from that Consul agent.
+	ShutdownDrainDelay time.Duration
+	ConsulAddr         string
+	ConsulServiceID    string
+
+	// MemoryPollInterval is how often Redis INFO memory is polled (0 = off).
+	// At MemoryPressureThreshold of maxmemory, MemoryPressureAction
+	// ("none", "shorten_ttl" or "pause") kicks in; shorten_ttl writes new
+	// cache entries with MemoryPressureTTLFactor of their usual TTL.
+	MemoryPollInterval      time.Duration
+	MemoryPressureThreshold float64
+	MemoryPressureAction    string
+	MemoryPressureTTLFactor float64
+
+	// RequireIfMatchDelete rejects DELETEs without an If-Match precondition
+	RequireIfMatchDelete bool
+}
+
+const (
+	priceParseCents = "cents"
+	priceParseRound = "round"
+
+	knownVersionNotModified = "not_modified"
+	knownVersionMinimal     = "minimal"
+
+	cacheUpdateInvalidate   = "invalidate"
+	cacheUpdateWriteThrough = "write_through"
+	cacheUpdateWriteBehind  = "write_behind"
+
+	hitsOnUpdateReset    = "reset"
+	hitsOnUpdatePreserve = "preserve"
+	hitsOnUpdateOne      = "one"
+
+	putIDStrict       = "strict"        // body ID must equal the path ID
+	putIDPathWins     = "path_wins"     // body ID is ignored
+	putIDBodyOptional = "body_optional" // body ID may be omitted (or 0)
+
+	emptyListArray = "array"
+	emptyListNull  = "null"
+)
+
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		RedisAddr:                "localhost:6379",
+		HTTPAddr:                 ":8080",
+		ShutdownTimeout:          10 * time.Second,
+		PriceParseMode:           priceParseCents,
+		TombstoneTTL:             2 * time.Second,
+		MaxCacheTTL:              time.Hour,
+		PopulateLockTTL:          time.Second,
+		IDStrategy:               idStrategyMaxPlusOne,
+		MaxURLLength:             8192,
+		MaxQueryLength:           4096,
+		MaxHeaderBytes:           http.DefaultMaxHeaderBytes,
+		CORSMaxAge:               600 * time.Second,
+		KnownVersionResponse:     knownVersionNotModified,
+		MetricsBackend:           metricsBackendNone,
+		StatsDAddr:               "localhost:8125",
+		StatsDPrefix:             "gorediscache",
+		CacheReadOnlyRecheck:     30 * time.Second,
+		CacheBypassEnabled:       true,
+		CacheBypassRate:          10,
+		CacheBypassBurst:         20,
+		CacheBypassIPRate:        1,
+		CacheBypassIPBurst:       5,
+		CacheUpdateMode:          cacheUpdateInvalidate,
+		HitsOnUpdate:             hitsOnUpdateReset,
+		DBLockTimeout:            2 * time.Second,
+		InvalidationChannel:      "products:invalidations",
+		InvalidationBatchWindow:  50 * time.Millisecond,
+		TTLRefreshInterval:       5 * time.Second,
+		AdminReadsFromDB:         true,
+		CleanerInterval:          10 * time.Second,
+		CacheSchemaVersion:       cacheSchemaV1,
+		EmptyListItems:           emptyListArray,
+		EventBufferSize:          64,
+		SlowConsumerPolicy:       slowConsumerDropOldest,
+		IdempotencyKeyTTL:        24 * time.Hour,
+		IdempotencyInFlightTTL:   30 * time.Second,
+		IdempotencyWait:          2 * time.Second,
+		BatchDuplicateIDs:        batchDuplicatesDedup,
+		BatchStreamChunk:         100,
+		DeletedHits:              deletedHitsDelete,
+		MemoryPressureThreshold:  0.9,
+		MemoryPressureAction:     memoryPressureNone,
+		MemoryPressureTTLFactor:  0.25,
+		AsyncWriteStatusTTL:      time.Hour,
+		LocationStyle:            locationRelative,
+		NameWhitespace:           nameWhitespaceTrim,
+		BaseCurrency:             "USD",
+		ValidationStatus:         validationStatus400,
+		BreakerFailures:          5,
+		BreakerOpenTimeout:       10 * time.Second,
+		BreakerOpenResponse:      breakerFallback,
+		BatchPopulateRetries:     1,
+		MaxDecompressedBodyBytes: 10 << 20,
+		LogSampleRate:            0.01,
+		SlowRequestThreshold:     time.Second,
+		CacheSizeMemorySamples:   20,
+		HitsMode:                 hitsModeCumulative,
+		HitsWindow:               time.Minute,
+		ClockSource:              clockSourceLocal,
+		HistorySize:              50,
+		CacheIDCheck:             true,
+		RateLimitBurst:           20,
+		TraceSampleRatio:         1,
+		TracingServiceName:       "gorediscache",
+		StaleMaxAge:              10 * time.Minute,
+		PutIDPolicy:              putIDStrict,
+		BulkMode:                 bulkAllOrNothing,
+	}
+}
+
+// Build the config from defaults, overridden by the config file at path (if
+// any), overridden in turn by environment variables
+func loadConfig(path string) (Config, error) {
+	if path != "" {
+		f, err := readConfigFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		activeConfigFile = f
+		defer func() { activeConfigFile = nil }()
+	}
+
+	c := defaultConfig()
+	c.RedisAddr = envString("REDIS_ADDR", c.RedisAddr)
+	c.HTTPAddr = envString("HTTP_ADDR", c.HTTPAddr)
+	if v, _, ok := lookupSetting("GRPC_ADDR"); ok {
+		c.GRPCAddr = v // may be empty, to disable gRPC
+	}
+	c.ShutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+	c.PriceParseMode = envString("PRICE_PARSE_MODE", c.PriceParseMode)
+	c.MaxProducts = envInt("MAX_PRODUCTS", c.MaxProducts)
+	c.TombstoneTTL = envDuration("TOMBSTONE_TTL", c.TombstoneTTL)
+	c.AdminToken = envString("ADMIN_TOKEN", c.AdminToken)
+	c.MaxCacheTTL = envDuration("MAX_CACHE_TTL", c.MaxCacheTTL)
+	c.PopulateLock = envBool("POPULATE_LOCK", c.PopulateLock)
+	c.PopulateLockTTL = envDuration("POPULATE_LOCK_TTL", c.PopulateLockTTL)
+	c.IDStrategy = envString("ID_STRATEGY", c.IDStrategy)
+	c.MaxURLLength = envInt("MAX_URL_LENGTH", c.MaxURLLength)
+	c.MaxQueryLength = envInt("MAX_QUERY_LENGTH", c.MaxQueryLength)
+	c.MaxHeaderBytes = envInt("MAX_HEADER_BYTES", c.MaxHeaderBytes)
+	c.CORSAllowedOrigins = envList("CORS_ALLOWED_ORIGINS", c.CORSAllowedOrigins)
+	c.CORSMaxAge = envDuration("CORS_MAX_AGE", c.CORSMaxAge)
+	c.KnownVersionResponse = envString("KNOWN_VERSION_RESPONSE", c.KnownVersionResponse)
+	c.MetricsBackend = envString("METRICS_BACKEND", c.MetricsBackend)
+	c.StatsDAddr = envString("STATSD_ADDR", c.StatsDAddr)
+	c.StatsDPrefix = envString("STATSD_PREFIX", c.StatsDPrefix)
+	c.CacheReadOnlyRecheck = envDuration("CACHE_READONLY_RECHECK", c.CacheReadOnlyRecheck)
+	c.CacheBypassEnabled = envBool("CACHE_BYPASS_ENABLED", c.CacheBypassEnabled)
+	c.CacheBypassRate = envFloat("CACHE_BYPASS_RATE", c.CacheBypassRate)
+	c.CacheBypassBurst = envInt("CACHE_BYPASS_BURST", c.CacheBypassBurst)
+	c.CacheBypassIPRate = envFloat("CACHE_BYPASS_IP_RATE", c.CacheBypassIPRate)
+	c.CacheBypassIPBurst = envInt("CACHE_BYPASS_IP_BURST", c.CacheBypassIPBurst)
+	c.CacheUpdateMode = envString("CACHE_UPDATE_MODE", c.CacheUpdateMode)
+	c.HitsOnUpdate = envString("HITS_ON_UPDATE", c.HitsOnUpdate)
+	c.DBLockTimeout = envDuration("DB_LOCK_TIMEOUT", c.DBLockTimeout)
+	c.InvalidationPubSub = envBool("INVALIDATION_PUBSUB", c.InvalidationPubSub)
+	c.InvalidationChannel = envString("INVALIDATION_CHANNEL", c.InvalidationChannel)
+	c.InvalidationBatchWindow = envDuration("INVALIDATION_BATCH_WINDOW", c.InvalidationBatchWindow)
+	c.TTLRefreshInterval = envDuration("TTL_REFRESH_INTERVAL", c.TTLRefreshInterval)
+	c.CacheOnHead = envBool("CACHE_ON_HEAD", c.CacheOnHead)
+	c.AdminReadsFromDB = envBool("ADMIN_READS_FROM_DB", c.AdminReadsFromDB)
+	c.CleanerInterval = envDuration("CLEANER_INTERVAL", c.CleanerInterval)
+	c.CleanerStartJitter = envDuration("CLEANER_START_JITTER", c.CleanerStartJitter)
+	c.CleanerOrphanKeys = envList("CLEANER_ORPHAN_KEYS", c.CleanerOrphanKeys)
+	c.CleanerFailoverBackoff = envDuration("CLEANER_FAILOVER_BACKOFF", c.CleanerFailoverBackoff)
+	c.CacheSchemaVersion = envInt("CACHE_SCHEMA_VERSION", c.CacheSchemaVersion)
+	c.CacheMigrationMode = envBool("CACHE_MIGRATION_MODE", c.CacheMigrationMode)
+	c.NotFoundBody = envString("NOT_FOUND_BODY", c.NotFoundBody)
+	c.ResponseDedupWindow = envDuration("RESPONSE_DEDUP_WINDOW", c.ResponseDedupWindow)
+	c.EmptyListItems = envString("EMPTY_LIST_ITEMS", c.EmptyListItems)
+	c.EventBufferSize = envInt("EVENT_BUFFER_SIZE", c.EventBufferSize)
+	c.SlowConsumerPolicy = envString("SLOW_CONSUMER_POLICY", c.SlowConsumerPolicy)
+	c.IdempotencyKeyTTL = envDuration("IDEMPOTENCY_KEY_TTL", c.IdempotencyKeyTTL)
+	c.IdempotencyInFlightTTL = envDuration("IDEMPOTENCY_INFLIGHT_TTL", c.IdempotencyInFlightTTL)
+	c.IdempotencyWait = envDuration("IDEMPOTENCY_WAIT", c.IdempotencyWait)
+	c.BatchDuplicateIDs = envString("BATCH_DUPLICATE_IDS", c.BatchDuplicateIDs)
+	c.LocationStyle = envString("LOCATION_STYLE", c.LocationStyle)
+	c.TrustedProxies = envList("TRUSTED_PROXIES", c.TrustedProxies)
+	c.NameWhitespace = envString("NAME_WHITESPACE", c.NameWhitespace)
+	c.CacheSnapshotPath = envString("CACHE_SNAPSHOT_PATH", c.CacheSnapshotPath)
+	c.RequireUserAgent = envBool("REQUIRE_USER_AGENT", c.RequireUserAgent)
+	c.BaseCurrency = envString("BASE_CURRENCY", c.BaseCurrency)
+	c.ExchangeRates = envList("EXCHANGE_RATES", c.ExchangeRates)
+	c.ValidationStatus = envInt("VALIDATION_STATUS", c.ValidationStatus)
+	c.BreakerFailures = envInt("BREAKER_FAILURES", c.BreakerFailures)
+	c.BreakerOpenTimeout = envDuration("BREAKER_OPEN_TIMEOUT", c.BreakerOpenTimeout)
+	c.ReadyzFailOnBreakerOpen = envBool("READYZ_FAIL_ON_BREAKER_OPEN", c.ReadyzFailOnBreakerOpen)
+	c.BreakerOpenResponse = envString("BREAKER_OPEN_RESPONSE", c.BreakerOpenResponse)
+	c.BatchPopulateRetries = envInt("BATCH_POPULATE_RETRIES", c.BatchPopulateRetries)
+	c.CanarySampleRate = envFloat("CANARY_SAMPLE_RATE", c.CanarySampleRate)
+	c.MaxDecompressedBodyBytes = envInt("MAX_DECOMPRESSED_BODY_BYTES", c.MaxDecompressedBodyBytes)
+	c.AccessLog = envBool("ACCESS_LOG", c.AccessLog)
+	c.LogSampling = envBool("LOG_SAMPLING", c.LogSampling)
+	c.LogSampleRate = envFloat("LOG_SAMPLE_RATE", c.LogSampleRate)
+	c.SlowRequestThreshold = envDuration("SLOW_REQUEST_THRESHOLD", c.SlowRequestThreshold)
+	c.RedisCrossSlotFallback = envBool("REDIS_CROSSSLOT_FALLBACK", c.RedisCrossSlotFallback)
+	c.CacheSizeMemorySamples = envInt("CACHE_SIZE_MEMORY_SAMPLES", c.CacheSizeMemorySamples)
+	c.CleanerMaxOps = envFloat("CLEANER_MAX_OPS", c.CleanerMaxOps)
+	c.CacheEpoch = envInt("CACHE_EPOCH", c.CacheEpoch)
+	c.CacheEpochPollInterval = envDuration("CACHE_EPOCH_POLL_INTERVAL", c.CacheEpochPollInterval)
+	c.CacheMaxAge = envDuration("CACHE_MAX_AGE", c.CacheMaxAge)
+	c.MetricsExemplars = envBool("METRICS_EXEMPLARS", c.MetricsExemplars)
+	c.HitsMode = envString("HITS_MODE", c.HitsMode)
+	c.HitsWindow = envDuration("HITS_WINDOW", c.HitsWindow)
+	c.ServeStaleOnError = envBool("SERVE_STALE_ON_ERROR", c.ServeStaleOnError)
+	c.StaleMaxAge = envDuration("STALE_MAX_AGE", c.StaleMaxAge)
+	c.Deprecations = envList("DEPRECATIONS", c.Deprecations)
+	c.PutIDPolicy = envString("PUT_ID_POLICY", c.PutIDPolicy)
+	c.BulkMode = envString("BULK_MODE", c.BulkMode)
+	c.ReadOnly = envBool("READ_ONLY", c.ReadOnly)
+	c.DebugToken = envString("DEBUG_TOKEN", c.DebugToken)
+	c.PutCoalesceWindow = envDuration("PUT_COALESCE_WINDOW", c.PutCoalesceWindow)
+	c.ClockSource = envString("CLOCK_SOURCE", c.ClockSource)
+	c.HistorySize = envInt("HISTORY_SIZE", c.HistorySize)
+	c.CacheIDCheck = envBool("CACHE_ID_CHECK", c.CacheIDCheck)
+	c.RateLimit = envFloat("RATE_LIMIT", c.RateLimit)
+	c.RateLimitBurst = envInt("RATE_LIMIT_BURST", c.RateLimitBurst)
+	c.LogEffectiveConfig = envBool("LOG_EFFECTIVE_CONFIG", c.LogEffectiveConfig)
+	c.Compression = envBool("COMPRESSION", c.Compression)
+	c.PopularityEvents = envBool("POPULARITY_EVENTS", c.PopularityEvents)
+	c.StartupGate = envBool("STARTUP_GATE", c.StartupGate)
+	c.RequestTimeout = envDuration("REQUEST_TIMEOUT", c.RequestTimeout)
+	c.Tracing = envBool("TRACING", c.Tracing)
+	c.TraceSampleRatio = envFloat("TRACE_SAMPLE_RATIO", c.TraceSampleRatio)
+	c.TracingServiceName = envString("TRACING_SERVICE_NAME", c.TracingServiceName)
+	c.ListMaxOffset = envInt("LIST_MAX_OFFSET", c.ListMaxOffset)
+	c.StrictAccept = envBool("STRICT_ACCEPT", c.StrictAccept)
+	c.PublicFields = envList("PUBLIC_FIELDS", c.PublicFields)
+	c.APITokens = envList("API_TOKENS", c.APITokens)
+	c.MaxCatalogValue = Price(envInt("MAX_CATALOG_VALUE", int(c.MaxCatalogValue)))
+	c.BatchStreamChunk = envInt("BATCH_STREAM_CHUNK", c.BatchStreamChunk)
+	c.DeletedHits = envString("DELETED_HITS", c.DeletedHits)
+	c.MaxStaleness = envDuration("MAX_STALENESS", c.MaxStaleness)
+	c.AsyncWrites = envBool("ASYNC_WRITES", c.AsyncWrites)
+	c.AsyncWriteStatusTTL = envDuration("ASYNC_WRITE_STATUS_TTL", c.AsyncWriteStatusTTL)
+	c.ColdStartWarm = envInt("COLD_START_WARM", c.ColdStartWarm)
+	c.ColdStartGrace = envDuration("COLD_START_GRACE", c.ColdStartGrace)
+	c.ProductLinks = envBool("PRODUCT_LINKS", c.ProductLinks)
+	c.ShutdownDrainDelay = envDuration("SHUTDOWN_DRAIN_DELAY", c.ShutdownDrainDelay)
+	c.ConsulAddr = envString("CONSUL_ADDR", c.ConsulAddr)
+	c.ConsulServiceID = envString("CONSUL_SERVICE_ID", c.ConsulServiceID)
+	c.MemoryPollInterval = envDuration("MEMORY_POLL_INTERVAL", c.MemoryPollInterval)
+	c.MemoryPressureThreshold = envFloat("MEMORY_PRESSURE_THRESHOLD", c.MemoryPressureThreshold)
+	c.MemoryPressureAction = envString("MEMORY_PRESSURE_ACTION", c.MemoryPressureAction)
+	c.MemoryPressureTTLFactor = envFloat("MEMORY_PRESSURE_TTL_FACTOR", c.MemoryPressureTTLFactor)
+	c.RequireIfMatchDelete = envBool("REQUIRE_IF_MATCH_DELETE", c.RequireIfMatchDelete)
+	if activeConfigFile != nil {
+		if err := activeConfigFile.err(); err != nil {
+			return Config{}, err
+		}
+	}
+	return c, nil
+}
+
+// Utility - read a string setting, falling back to def when unset or empty
+func envString(key, def string) string {
+	if v, _, _ := lookupSetting(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Utility - read a comma-separated setting, falling back to def when unset
+func envList(key string, def []string) []string {
+	v, _, _ := lookupSetting(key)
+	if v == "" {
+		return def
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Utility - read an integer setting, falling back to def when unset or invalid
+func envInt(key string, def int) int {
+	v, where, _ := lookupSetting(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		invalidSetting(key, v, where, err)
+		return def
+	}
+	return n
+}
+
+// Utility - read a float setting, falling back to def when unset or invalid
+func envFloat(key string, def float64) float64 {
+	v, where, _ := lookupSetting(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		invalidSetting(key, v, where, err)
+		return def
+	}
+	return f
+}
+
+// Utility - read a boolean setting ("true", "1", ...), falling back to def when unset or invalid
+func envBool(key string, def bool) bool {
+	v, where, _ := lookupSetting(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		invalidSetting(key, v, where, err)
+		return def
+	}
+	return b
+}
+
+// Utility - read a duration setting (e.g. "2s"), falling back to def when unset or invalid
+func envDuration(key string, def time.Duration) time.Duration {
+	v, where, _ := lookupSetting(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		invalidSetting(key, v, where, err)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write a config file into the test's temp dir and return its path
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	yamlPath := writeConfigFile(t, "config.yaml", `
+redis_addr: redis.internal:6379
+MAX_PRODUCTS: 500
+TOMBSTONE_TTL: 5s
+CORS_ALLOWED_ORIGINS:
+  - https://shop.example
+  - https://admin.example
+`)
+	jsonPath := writeConfigFile(t, "config.json", `{"REDIS_ADDR": "redis.internal:6379", "MAX_PRODUCTS": 500, "TOMBSTONE_TTL": "5s",
+"CORS_ALLOWED_ORIGINS": ["https://shop.example", "https://admin.example"]}`)
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		c, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(path), err)
+		}
+		if c.RedisAddr != "redis.internal:6379" || c.MaxProducts != 500 || c.TombstoneTTL != 5*time.Second ||
+			!reflect.DeepEqual(c.CORSAllowedOrigins, []string{"https://shop.example", "https://admin.example"}) {
+			t.Fatalf("%s: got %+v", filepath.Base(path), c)
+		}
+		if c.HTTPAddr != defaultConfig().HTTPAddr {
+			t.Fatalf("%s: unset HTTP_ADDR got %q, want the default", filepath.Base(path), c.HTTPAddr)
+		}
+	}
+}
+
+func TestEnvOverridesConfigFile(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "MAX_PRODUCTS: 500\nTOMBSTONE_TTL: 5s\n")
+	t.Setenv("MAX_PRODUCTS", "20")
+
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MaxProducts != 20 || c.TombstoneTTL != 5*time.Second {
+		t.Fatalf("got MAX_PRODUCTS %d and TOMBSTONE_TTL %v, want 20 from the env and 5s from the file", c.MaxProducts, c.TombstoneTTL)
+	}
+}
+
+func TestMalformedConfigFile(t *testing.T) {
+	for name, tc := range map[string]struct{ content, want string }{
+		"syntax":        {"MAX_PRODUCTS: 500\nTOMBSTONE_TTL: [5s\n", "config.yaml"},
+		"not a mapping": {"- MAX_PRODUCTS\n", "config.yaml:1: expected a mapping"},
+		"duplicate":     {"MAX_PRODUCTS: 5\nmax_products: 6\n", "config.yaml:2: max_products already set on line 1"},
+		"bad value":     {"MAX_PRODUCTS: lots\n", "config.yaml:1: invalid MAX_PRODUCTS"},
+		"unknown":       {"MAX_PRODCUTS: 5\n", "config.yaml:1: unknown setting MAX_PRODCUTS"},
+	} {
+		path := writeConfigFile(t, "config.yaml", tc.content)
+		_, err := loadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: got error %v, want it to mention %q", name, err, tc.want)
+		}
+	}
+}
+
+func TestOptionalServicesOffByDefault(t *testing.T) {
+	c := defaultConfig()
+	if c.GRPCAddr != "" || c.InvalidationPubSub || c.CacheEpochPollInterval != 0 || c.MemoryPollInterval != 0 ||
+		c.CleanerStartJitter != 0 || c.LogEffectiveConfig {
+		t.Fatalf("optional listener or background work on by default: %+v", c)
+	}
+
+	path := writeConfigFile(t, "config.yaml", "GRPC_ADDR: ':9090'\nINVALIDATION_PUBSUB: true\nMEMORY_POLL_INTERVAL: 15s\n")
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GRPCAddr != ":9090" || !c.InvalidationPubSub || c.MemoryPollInterval != 15*time.Second {
+		t.Fatalf("enabled in the config file: got %+v", c)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile holds settings read from a --config file. Keys are the
+// environment variable names (case-insensitive), so the file and the
+// environment describe the same settings and parse through the same helpers.
+type configFile struct {
+	path     string
+	settings map[string]fileSetting
+	used     map[string]bool // keys consulted while building the Config
+	errs     []string
+}
+
+type fileSetting struct {
+	value string
+	line  int
+}
+
+// The file being applied by loadConfig, if any
+var activeConfigFile *configFile
+
+// Parse a YAML or JSON config file (JSON is valid YAML). The top level must
+// be a mapping of setting names to scalars or lists of scalars.
+func readConfigFile(path string) (*configFile, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	f := &configFile{path: path, settings: map[string]fileSetting{}, used: map[string]bool{}}
+	if len(doc.Content) == 0 {
+		return f, nil // empty file
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected a mapping of settings", path, root.Line)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valueNode := root.Content[i], root.Content[i+1]
+		key := strings.ToUpper(keyNode.Value)
+		if prev, dup := f.settings[key]; dup {
+			return nil, fmt.Errorf("%s:%d: %s already set on line %d", path, keyNode.Line, keyNode.Value, prev.line)
+		}
+		value, err := configNodeValue(valueNode)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", path, valueNode.Line, keyNode.Value, err)
+		}
+		f.settings[key] = fileSetting{value: value, line: keyNode.Line}
+	}
+	return f, nil
+}
+
+// Utility - flatten a setting's node to the string form its env var would
+// have; lists become comma-separated
+func configNodeValue(n *yaml.Node) (string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			return "", nil
+		}
+		return n.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(n.Content))
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", errors.New("list items must be scalars")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", errors.New("expected a scalar or a list")
+}
+
+// Utility - look up a setting: the environment wins over the config file.
+// where is empty for env values and "file:line" for file values.
+func lookupSetting(key string) (value, where string, ok bool) {
+	if activeConfigFile != nil {
+		activeConfigFile.used[key] = true
+	}
+	if v, ok := os.LookupEnv(key); ok {
+		return v, "", true
+	}
+	if activeConfigFile == nil {
+		return "", "", false
+	}
+	s, ok := activeConfigFile.settings[key]
+	if !ok {
+		return "", "", false
+	}
+	return s.value, fmt.Sprintf("%s:%d", activeConfigFile.path, s.line), true
+}
+
+// Record an unparseable setting. Bad env values are logged and ignored as
+// before; bad file values fail the load, with the offending line.
+func invalidSetting(key, value, where string, err error) {
+	if where == "" {
+		log.Printf("Ignoring invalid %s=%q: %v", key, value, err)
+		return
+	}
+	activeConfigFile.errs = append(activeConfigFile.errs, fmt.Sprintf("%s: invalid %s %q: %v", where, key, value, err))
+}
+
+// Collect load errors, including settings the loader never asked for
+func (f *configFile) err() error {
+	errs := f.errs
+	var unknown []string
+	for key, s := range f.settings {
+		if !f.used[key] {
+			unknown = append(unknown, fmt.Sprintf("%s:%d: unknown setting %s", f.path, s.line, key))
+		}
+	}
+	sort.Strings(unknown)
+	errs = append(errs, unknown...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(errs, "\n"))
+}
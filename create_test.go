@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentCreatesGetUniqueIDs(t *testing.T) {
+	for _, strategy := range []string{idStrategyMaxPlusOne, idStrategyRedisIncr, idStrategyRandom} {
+		t.Run(strategy, func(t *testing.T) {
+			_, h := setupTest(t)
+			config.IDStrategy = strategy
+			if err := initProductIDSeq(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			before := len(fakeProductDB)
+
+			const creates = 50
+			ids := make(chan int, creates)
+			var wg sync.WaitGroup
+			for i := 0; i < creates; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					w := do(h, "POST", "/product", fmt.Sprintf(`{"name":"Product %d","price":%d}`, i, i))
+					if w.Code != http.StatusCreated {
+						t.Errorf("create %d: got %d %s", i, w.Code, w.Body.String())
+						return
+					}
+					var created Product
+					decodeBody(t, w, &created)
+					ids <- created.ID
+				}(i)
+			}
+			wg.Wait()
+			close(ids)
+
+			seen := map[int]bool{}
+			for id := range ids {
+				if seen[id] {
+					t.Fatalf("ID %d was assigned twice", id)
+				}
+				seen[id] = true
+			}
+			if len(seen) != creates {
+				t.Fatalf("%d of %d creates succeeded", len(seen), creates)
+			}
+			if got := len(fakeProductDB); got != before+creates {
+				t.Fatalf("DB holds %d products, want %d: writes were lost", got, before+creates)
+			}
+			for id := range seen {
+				if p, ok := dbProduct(id); !ok || p.ID != id {
+					t.Fatalf("product %d is missing from the DB", id)
+				}
+			}
+		})
+	}
+}
+
+// Create a product through the API and return its assigned ID
+func postProduct(t *testing.T, h http.Handler, name string) int {
+	t.Helper()
+	w := do(h, "POST", "/product", fmt.Sprintf(`{"name":%q,"price":10}`, name))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create %s: got %d %s", name, w.Code, w.Body.String())
+	}
+	var created Product
+	decodeBody(t, w, &created)
+	return created.ID
+}
+
+func TestRedisIncrNeverReusesDeletedIDs(t *testing.T) {
+	mr, h := setupTest(t)
+	config.IDStrategy = idStrategyRedisIncr
+	if err := initProductIDSeq(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mr.Get(redisProductIDSeqKey); v != "3" {
+		t.Fatalf("counter initialized to %q, want the highest existing ID 3", v)
+	}
+
+	first := postProduct(t, h, "Date")
+	do(h, "DELETE", fmt.Sprintf("/product/%d", first), "")
+	second := postProduct(t, h, "Elder")
+	if first != 4 || second != 5 {
+		t.Fatalf("IDs after a delete: got %d then %d, want 4 then 5", first, second)
+	}
+
+	// Starting up again never lowers the counter
+	if err := initProductIDSeq(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if id := postProduct(t, h, "Fig"); id != 6 {
+		t.Fatalf("ID after reinitializing: got %d, want 6", id)
+	}
+}
+
+func TestMaxPlusOneReusesDeletedIDs(t *testing.T) {
+	_, h := setupTest(t)
+
+	first := postProduct(t, h, "Date")
+	do(h, "DELETE", fmt.Sprintf("/product/%d", first), "")
+	if second := postProduct(t, h, "Elder"); second != first {
+		t.Fatalf("max_plus_one after a delete: got %d, want %d again", second, first)
+	}
+}
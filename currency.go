@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errUnknownCurrency = errors.New("unknown currency")
+
+// Rates converts amounts between currencies. Conversion is display-only:
+// stored prices always stay in BASE_CURRENCY.
+type Rates interface {
+	Convert(amount Price, from, to string) (Price, error)
+}
+
+// staticRates converts with fixed rates, expressed as units of each
+// currency per one unit of the base currency
+type staticRates struct {
+	base  string
+	rates map[string]float64
+}
+
+// Build a static provider from "EUR=0.92,GBP=0.79"-style entries
+func newStaticRates(base string, entries []string) (*staticRates, error) {
+	base = strings.ToUpper(base)
+	s := &staticRates{base: base, rates: map[string]float64{base: 1}}
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid exchange rate %q", entry)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid exchange rate %q", entry)
+		}
+		s.rates[strings.ToUpper(strings.TrimSpace(parts[0]))] = rate
+	}
+	return s, nil
+}
+
+func (s *staticRates) Convert(amount Price, from, to string) (Price, error) {
+	fromRate, ok := s.rates[strings.ToUpper(from)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", errUnknownCurrency, from)
+	}
+	toRate, ok := s.rates[strings.ToUpper(to)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", errUnknownCurrency, to)
+	}
+	return Price(math.Round(float64(amount) / fromRate * toRate)), nil
+}
+
+// The exchange-rate provider used for ?currency=; nil disables conversion
+var exchangeRates Rates
+
+// displayProduct is a product whose price was converted for display
+type displayProduct struct {
+	Product
+	Currency string `json:"currency"`
+}
+
+// Utility - convert a product's price into the requested currency
+func convertProductPrice(product Product, currency string) (Product, error) {
+	if exchangeRates == nil {
+		return Product{}, fmt.Errorf("%w: %s", errUnknownCurrency, currency)
+	}
+	price, err := exchangeRates.Convert(product.Price, config.BaseCurrency, currency)
+	if err != nil {
+		return Product{}, err
+	}
+	product.Price = price
+	return product, nil
+}
+
+// Utility - write a product converted to the currency asked for with
+// ?currency=; Content-Currency names it for protobuf clients
+func writeProductInCurrency(w http.ResponseWriter, r *http.Request, product Product, currency string) {
+	converted, err := convertProductPrice(product, currency)
+	if err != nil {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+	currency = strings.ToUpper(currency)
+	w.Header().Set("Content-Currency", currency)
+	w.Header().Add("Vary", "Accept")
+	projected := publicProjection(w, r)
+	if negotiateContentType(r.Header.Get("Accept"), productContentTypes) == contentTypeProtobuf {
+		if projected {
+			converted = hideProductFields(converted)
+		}
+		writeProductProtobuf(w, converted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if projected {
+		json.NewEncoder(w).Encode(projectProduct(displayProduct{Product: converted, Currency: currency}))
+		return
+	}
+	json.NewEncoder(w).Encode(displayProduct{Product: converted, Currency: currency})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestStaticRatesConvert(t *testing.T) {
+	rates, err := newStaticRates("usd", []string{"EUR=0.5", " gbp = 0.25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		amount   Price
+		from, to string
+		want     Price
+	}{
+		{100, "USD", "EUR", 50},
+		{50, "EUR", "GBP", 25},
+		{25, "gbp", "usd", 100},
+		{3, "EUR", "GBP", 2}, // 1.5 rounds half away from zero
+	} {
+		got, err := rates.Convert(tc.amount, tc.from, tc.to)
+		if err != nil || got != tc.want {
+			t.Fatalf("%d %s in %s: got %d, %v; want %d", tc.amount, tc.from, tc.to, got, err, tc.want)
+		}
+	}
+	if _, err := rates.Convert(100, "USD", "JPY"); !errors.Is(err, errUnknownCurrency) {
+		t.Fatalf("unknown currency: got %v", err)
+	}
+	if _, err := newStaticRates("USD", []string{"EUR=-1"}); err == nil {
+		t.Fatal("negative rate accepted")
+	}
+}
+
+func TestGetProductInCurrency(t *testing.T) {
+	_, h := setupTest(t)
+	config.BaseCurrency = "USD"
+	exchangeRates, _ = newStaticRates("USD", []string{"EUR=0.5"})
+
+	w := do(h, "GET", "/product/1?currency=eur", "")
+	var p displayProduct
+	decodeBody(t, w, &p)
+	if p.Price != 50 || p.Currency != "EUR" || w.Header().Get("Content-Currency") != "EUR" {
+		t.Fatalf("GET in EUR: got %+v, Content-Currency %q", p, w.Header().Get("Content-Currency"))
+	}
+	if stored, _ := dbProduct(1); stored.Price != 100 {
+		t.Fatalf("conversion changed the stored price to %d", stored.Price)
+	}
+	if w := do(h, "GET", "/product/1?currency=JPY", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown currency: got %d, want 400", w.Code)
+	}
+}
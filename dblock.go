@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var errDBLockTimeout = errors.New("timed out waiting for the product DB lock")
+
+// Utility - take fakeDBLock for reading, giving up after DB_LOCK_TIMEOUT or
+// when ctx is done, so a stuck writer surfaces as 503s instead of hung requests
+func rlockDB(ctx context.Context) error {
+	if config.DBLockTimeout <= 0 {
+		fakeDBLock.RLock()
+		return nil
+	}
+	return waitForDBLock(ctx, fakeDBLock.TryRLock)
+}
+
+// Utility - take fakeDBLock for writing, with the same timeout as rlockDB
+func lockDB(ctx context.Context) error {
+	if config.DBLockTimeout <= 0 {
+		fakeDBLock.Lock()
+		return nil
+	}
+	return waitForDBLock(ctx, fakeDBLock.TryLock)
+}
+
+// Poll try with backoff until it succeeds or the timeout expires. Unlike a
+// blocking Lock, a polling writer doesn't hold back new readers, so writers
+// can lose out under sustained read load; the timeout bounds that too.
+func waitForDBLock(ctx context.Context, try func() bool) error {
+	if try() {
+		return nil
+	}
+	timer := time.NewTimer(config.DBLockTimeout)
+	defer timer.Stop()
+	backoff := 50 * time.Microsecond
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return errDBLockTimeout
+		case <-time.After(backoff):
+		}
+		if try() {
+			return nil
+		}
+		if backoff < 5*time.Millisecond {
+			backoff *= 2
+		}
+	}
+}
+
+// Utility - report a DB lock acquisition failure
+func writeDBLockError(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Product store busy, try again", http.StatusServiceUnavailable)
+}
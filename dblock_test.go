@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHeldDBLockGives503(t *testing.T) {
+	_, h := setupTest(t)
+	config.DBLockTimeout = 50 * time.Millisecond
+
+	fakeDBLock.Lock()
+	defer fakeDBLock.Unlock()
+	for _, req := range []struct{ method, path, body string }{
+		{"GET", "/product/1", ""},
+		{"GET", "/products", ""},
+		{"PUT", "/product/1", `{"id":1,"name":"Green Apple","price":120}`},
+		{"POST", "/product", `{"name":"Date","price":5}`},
+	} {
+		start := time.Now()
+		w := do(h, req.method, req.path, req.body)
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s %s with the lock held: got %d, Retry-After %q", req.method, req.path, w.Code, w.Header().Get("Retry-After"))
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("%s %s took %v to give up", req.method, req.path, elapsed)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// deprecation marks a route, or one query parameter of it, as deprecated
+type deprecation struct {
+	path   string    // mux path template, e.g. /product/{id:[0-9]+}
+	param  string    // query parameter; empty deprecates the whole route
+	sunset time.Time // zero if no removal date is announced
+}
+
+// Deprecations from DEPRECATIONS, parsed at startup
+var deprecations []deprecation
+
+// Parse DEPRECATIONS entries of the form path[?param][@YYYY-MM-DD]
+func parseDeprecations(entries []string) ([]deprecation, error) {
+	var out []deprecation
+	for _, entry := range entries {
+		var d deprecation
+		spec := entry
+		if i := strings.LastIndexByte(spec, '@'); i >= 0 {
+			sunset, err := time.Parse("2006-01-02", spec[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid deprecation %q: bad sunset date", entry)
+			}
+			d.sunset = sunset
+			spec = spec[:i]
+		}
+		if i := strings.IndexByte(spec, '?'); i >= 0 {
+			d.param = spec[i+1:]
+			spec = spec[:i]
+		}
+		if !strings.HasPrefix(spec, "/") {
+			return nil, fmt.Errorf("invalid deprecation %q: path must start with /", entry)
+		}
+		d.path = spec
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// Middleware - flag requests using a deprecated route or parameter with
+// Deprecation and Sunset headers, and log them so operators can see who
+// still depends on them
+func deprecationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(deprecations) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path, _ := route.GetPathTemplate()
+		for _, d := range deprecations {
+			if d.path != path || (d.param != "" && !r.URL.Query().Has(d.param)) {
+				continue
+			}
+			used := d.path
+			if d.param != "" {
+				used += "?" + d.param
+			}
+			w.Header().Set("Deprecation", "true")
+			if !d.sunset.IsZero() {
+				w.Header().Set("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+			}
+			log.Printf("Deprecated usage: %s %s by %s (%s)", r.Method, used, clientIP(r), r.UserAgent())
+			metrics.IncrCounter("deprecated_requests_total", Labels{"route": used})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDeprecatedParamHeaders(t *testing.T) {
+	_, h := setupTest(t)
+	var err error
+	deprecations, err = parseDeprecations([]string{"/product/{id:[0-9]+}?known_version@2027-01-31", "/stats"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := do(h, "GET", "/product/1?known_version=0", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("deprecated param: got %d", w.Code)
+	}
+	if w.Header().Get("Deprecation") != "true" || w.Header().Get("Sunset") != "Sun, 31 Jan 2027 00:00:00 GMT" {
+		t.Fatalf("deprecated param: Deprecation %q, Sunset %q", w.Header().Get("Deprecation"), w.Header().Get("Sunset"))
+	}
+
+	w = do(h, "GET", "/product/1", "")
+	if w.Header().Get("Deprecation") != "" {
+		t.Fatal("Deprecation set without the deprecated param")
+	}
+
+	w = do(h, "GET", "/stats", "")
+	if w.Header().Get("Deprecation") != "true" || w.Header().Get("Sunset") != "" {
+		t.Fatalf("deprecated route: Deprecation %q, Sunset %q", w.Header().Get("Deprecation"), w.Header().Get("Sunset"))
+	}
+}
+
+func TestParseDeprecationsRejectsMalformed(t *testing.T) {
+	for _, entry := range []string{"products?sort", "/products@31-01-2027"} {
+		if _, err := parseDeprecations([]string{entry}); err == nil {
+			t.Errorf("%q: accepted", entry)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+)
+
+// Whether the request carries the debug token (X-Debug) that unlocks cache
+// diagnostic headers. Without a configured DEBUG_TOKEN nobody qualifies.
+func debugAuthorized(r *http.Request) bool {
+	if config.DebugToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Debug")), []byte(config.DebugToken)) == 1
+}
+
+// Utility - describe how a read was answered (X-Cache, X-Cache-TTL,
+// X-Data-Source), to authorized debug clients only
+func writeCacheDiagnostics(w http.ResponseWriter, r *http.Request, info readInfo) {
+	if !debugAuthorized(r) {
+		return
+	}
+	h := w.Header()
+	if info.Cache != "" {
+		h.Set("X-Cache", info.Cache)
+	}
+	if info.TTL > 0 {
+		h.Set("X-Cache-TTL", strconv.Itoa(int(info.TTL.Seconds())))
+	}
+	h.Set("X-Data-Source", info.Source)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDiagnosticsOnlyWithDebugToken(t *testing.T) {
+	diagnostics := []string{"X-Cache", "X-Cache-TTL", "X-Data-Source"}
+	for _, tc := range []struct {
+		name, token string
+		header      []string
+		visible     bool
+	}{
+		{"no token configured", "", []string{"X-Debug", ""}, false},
+		{"no header", "debug", nil, false},
+		{"wrong token", "debug", []string{"X-Debug", "guess"}, false},
+		{"correct token", "debug", []string{"X-Debug", "debug"}, true},
+	} {
+		_, h := setupTest(t)
+		config.DebugToken = tc.token
+		do(h, "GET", "/product/1", "")
+		w := do(h, "GET", "/product/1", "", tc.header...)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d", tc.name, w.Code)
+		}
+		for _, name := range diagnostics {
+			if got := w.Header().Get(name) != ""; got != tc.visible {
+				t.Errorf("%s: %s present = %v, want %v", tc.name, name, got, tc.visible)
+			}
+		}
+		if tc.visible && (w.Header().Get("X-Cache") != "HIT" || w.Header().Get("X-Data-Source") != "cache") {
+			t.Errorf("%s: X-Cache %q, X-Data-Source %q", tc.name, w.Header().Get("X-Cache"), w.Header().Get("X-Data-Source"))
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// What the broadcaster does when a subscriber's buffer is full
+const (
+	slowConsumerDropOldest = "drop_oldest" // discard the oldest queued event to make room
+	slowConsumerDropNewest = "drop_newest" // discard the event being published
+	slowConsumerDisconnect = "disconnect"  // close the subscriber's stream
+)
+
+// productEvent describes a change made through this instance, or a product
+// becoming popular on it
+type productEvent struct {
+	Type    string   `json:"type"` // "created", "updated", "deleted", "popular" or "final_hits"
+	ID      int      `json:"id"`
+	Product *Product `json:"product,omitempty"`
+	Hits    *int64   `json:"hits,omitempty"` // final_hits only
+}
+
+// eventSubscriber is one stream's bounded queue. done is closed when the
+// broadcaster disconnects it.
+type eventSubscriber struct {
+	events chan productEvent
+	done   chan struct{}
+}
+
+// eventBroadcaster fans product events out to stream subscribers. Publishing
+// never blocks: a subscriber that can't keep up is handled per
+// SLOW_CONSUMER_POLICY, so one slow client can't stall writers or the others.
+type eventBroadcaster struct {
+	mu   sync.Mutex
+	subs map[*eventSubscriber]struct{}
+}
+
+var productEvents = &eventBroadcaster{subs: map[*eventSubscriber]struct{}{}}
+
+func (b *eventBroadcaster) subscribe() *eventSubscriber {
+	size := config.EventBufferSize
+	if size < 1 {
+		size = 1
+	}
+	sub := &eventSubscriber{events: make(chan productEvent, size), done: make(chan struct{})}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *eventBroadcaster) unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.done)
+	}
+}
+
+func (b *eventBroadcaster) publish(ev productEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub.events <- ev:
+			continue
+		default:
+		}
+
+		switch config.SlowConsumerPolicy {
+		case slowConsumerDisconnect:
+			delete(b.subs, sub)
+			close(sub.done)
+			metrics.IncrCounter("event_subscribers_disconnected_total", nil)
+			continue
+		case slowConsumerDropNewest:
+		default: // drop_oldest
+			select {
+			case <-sub.events:
+			default:
+			}
+			select {
+			case sub.events <- ev:
+			default:
+			}
+		}
+		metrics.IncrCounter("events_dropped_total", Labels{"policy": config.SlowConsumerPolicy})
+	}
+}
+
+// Utility - record a committed product change in its history and announce
+// it to stream subscribers
+func publishProductEvent(eventType string, id int, product *Product) {
+	productHistory.record(eventType, id, product)
+	productEvents.publish(productEvent{Type: eventType, ID: id, Product: product})
+}
+
+// Handler - GET /products/events
+// Server-sent events for products created, updated or deleted through this
+// instance.
+func productEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	sub := productEvents.subscribe()
+	defer productEvents.unsubscribe(sub)
+
+	projected := publicProjection(w, r)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.done:
+			return
+		case ev := <-sub.events:
+			var data []byte
+			if projected {
+				data, _ = json.Marshal(projectWrapped(ev, "product"))
+			} else {
+				data, _ = json.Marshal(ev)
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
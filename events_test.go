@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The IDs of the events queued for sub, draining it
+func queuedEventIDs(sub *eventSubscriber) []int {
+	var ids []int
+	for {
+		select {
+		case ev := <-sub.events:
+			ids = append(ids, ev.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestSlowConsumerPolicy(t *testing.T) {
+	for policy, want := range map[string][]int{
+		slowConsumerDropOldest: {2, 3},
+		slowConsumerDropNewest: {1, 2},
+		slowConsumerDisconnect: {1, 2},
+	} {
+		t.Run(policy, func(t *testing.T) {
+			setupTest(t)
+			config.EventBufferSize = 2
+			config.SlowConsumerPolicy = policy
+			b := &eventBroadcaster{subs: map[*eventSubscriber]struct{}{}}
+			slow, fast := b.subscribe(), b.subscribe()
+
+			var got []int
+			for id := 1; id <= 3; id++ {
+				b.publish(productEvent{Type: "updated", ID: id})
+				got = append(got, queuedEventIDs(fast)...)
+			}
+			if !reflect.DeepEqual(got, []int{1, 2, 3}) {
+				t.Fatalf("subscriber keeping up got %v, want every event", got)
+			}
+			if got := queuedEventIDs(slow); !reflect.DeepEqual(got, want) {
+				t.Fatalf("slow subscriber got %v, want %v", got, want)
+			}
+
+			disconnected := false
+			select {
+			case <-slow.done:
+				disconnected = true
+			default:
+			}
+			if disconnected != (policy == slowConsumerDisconnect) {
+				t.Fatalf("slow subscriber disconnected: %v", disconnected)
+			}
+			b.unsubscribe(slow) // safe after a disconnect too
+			b.unsubscribe(fast)
+		})
+	}
+}
+
+// The IDs of the queued events of one type, draining sub
+func queuedEventIDsOfType(sub *eventSubscriber, eventType string) []int {
+	var ids []int
+	for {
+		select {
+		case ev := <-sub.events:
+			if ev.Type == eventType {
+				ids = append(ids, ev.ID)
+			}
+		default:
+			return ids
+		}
+	}
+}
+
+func TestPopularEventOncePerEpisode(t *testing.T) {
+	mr, h := setupTest(t)
+	config.PopularityEvents = true
+	config.EventBufferSize = 100
+	sub := productEvents.subscribe()
+	t.Cleanup(func() { productEvents.unsubscribe(sub) })
+
+	do(h, "GET", "/product/1", "")
+	for i := 0; i < popularThreshold+5; i++ {
+		do(h, "GET", "/product/1", "")
+	}
+	if got := queuedEventIDsOfType(sub, "popular"); !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("crossing the threshold: popular events %v, want one for product 1", got)
+	}
+	for i := 0; i < 5; i++ {
+		do(h, "GET", "/product/1", "")
+	}
+	if got := queuedEventIDsOfType(sub, "popular"); len(got) != 0 {
+		t.Fatalf("hits above the threshold: popular events %v, want none", got)
+	}
+
+	// A write resets the counters, starting a new episode
+	do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":120}`)
+	mr.FastForward(config.TombstoneTTL)
+	do(h, "GET", "/product/1", "")
+	for i := 0; i < popularThreshold+5; i++ {
+		do(h, "GET", "/product/1", "")
+	}
+	if got := queuedEventIDsOfType(sub, "popular"); !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("after a write: popular events %v, want one more", got)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+const exportFlushEvery = 100 // products written between flushes when streaming
+
+// Utility - copy all products out of the DB, ordered by ID. The copy is taken
+// under the read lock and the lock released before returning, so callers can
+// do slow work on it, like fetching popularity scores from Redis, without
+// holding up writers or seeing their changes mid-way.
+func snapshotProducts(ctx context.Context) ([]Product, error) {
+	if err := rlockDB(ctx); err != nil {
+		return nil, err
+	}
+	products := make([]Product, 0, len(fakeProductDB))
+	for _, p := range fakeProductDB {
+		products = append(products, *p)
+	}
+	fakeDBLock.RUnlock()
+
+	sort.Slice(products, func(i, j int) bool { return products[i].ID < products[j].ID })
+	return products, nil
+}
+
+// Handler - GET /products/export
+func exportProductsHandler(w http.ResponseWriter, r *http.Request) {
+	products, err := snapshotProducts(r.Context())
+	if err != nil {
+		writeDBLockError(w)
+		return
+	}
+
+	projected := publicProjection(w, r)
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		if projected {
+			json.NewEncoder(w).Encode(projectProducts(products))
+			return
+		}
+		json.NewEncoder(w).Encode(products)
+	case "ndjson":
+		writeNDJSON(w, products, projected)
+	default:
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+	}
+}
+
+// Stream products as newline-delimited JSON, flushing periodically so
+// consumers can start processing before the export finishes
+func writeNDJSON(w http.ResponseWriter, products []Product, projected bool) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w) // Encode terminates each value with '\n'
+	for i, p := range products {
+		var line interface{} = p
+		if projected {
+			line = projectProduct(p)
+		}
+		if err := enc.Encode(line); err != nil {
+			return
+		}
+		if flusher != nil && (i+1)%exportFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestExportNDJSONParsesLineByLine(t *testing.T) {
+	_, h := setupTest(t)
+
+	w := do(h, "GET", "/products/export?format=ndjson", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("ndjson export: got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var got []Product
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var p Product
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			t.Fatalf("line %d %q: %v", len(got)+1, scanner.Text(), err)
+		}
+		got = append(got, p)
+	}
+	want := []string{"Apple", "Banana", "Cherry"}
+	if len(got) != len(want) {
+		t.Fatalf("ndjson export: got %d products, want %d", len(got), len(want))
+	}
+	for i, p := range got {
+		if p.ID != i+1 || p.Name != want[i] {
+			t.Fatalf("line %d: got %+v", i+1, p)
+		}
+	}
+}
+
+func TestExportRejectsUnknownFormat(t *testing.T) {
+	_, h := setupTest(t)
+
+	if w := do(h, "GET", "/products/export?format=csv", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("csv export: got %d, want 400", w.Code)
+	}
+	var products []Product
+	decodeBody(t, do(h, "GET", "/products/export", ""), &products)
+	if len(products) != 3 {
+		t.Fatalf("default JSON export: got %+v", products)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"example.com/gorediscache/productpb"
+)
+
+// grpcProductServer implements productpb.ProductService on top of the same
+// store functions the REST handlers use
+type grpcProductServer struct {
+	productpb.UnimplementedProductServiceServer
+}
+
+func newGRPCServer() *grpc.Server {
+	s := grpc.NewServer()
+	productpb.RegisterProductServiceServer(s, &grpcProductServer{})
+	return s
+}
+
+func (s *grpcProductServer) GetProduct(ctx context.Context, req *productpb.GetProductRequest) (*productpb.Product, error) {
+	product, err := loadProduct(ctx, int(req.GetId()), false)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return publicProductToProto(ctx, product), nil
+}
+
+func (s *grpcProductServer) UpdateProduct(ctx context.Context, req *productpb.UpdateProductRequest) (*productpb.Product, error) {
+	if config.ReadOnly {
+		return nil, status.Error(codes.FailedPrecondition, "read-only instance")
+	}
+	if req.GetProduct() == nil || req.GetProduct().GetId() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "product with a positive id is required")
+	}
+	updated, err := saveProduct(ctx, productFromProto(req.GetProduct()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return publicProductToProto(ctx, updated), nil
+}
+
+func (s *grpcProductServer) ListProducts(ctx context.Context, req *productpb.ListProductsRequest) (*productpb.ListProductsResponse, error) {
+	limit, offset := int(req.GetLimit()), int(req.GetOffset())
+	if limit < 0 || offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit and offset must not be negative")
+	}
+	if limit == 0 {
+		limit = listDefaultLimit
+	}
+	if limit > listMaxLimit {
+		limit = listMaxLimit
+	}
+
+	matched, err := queryProducts(ctx, newProductFilter(req.GetName()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &productpb.ListProductsResponse{Total: int32(len(matched))}
+	for _, p := range paginate(matched, limit, offset) {
+		resp.Items = append(resp.Items, publicProductToProto(ctx, p))
+	}
+	return resp, nil
+}
+
+// Utility - a product for a gRPC response, cut down to PUBLIC_FIELDS unless
+// the call's authorization metadata carries one of API_TOKENS
+func publicProductToProto(ctx context.Context, p Product) *productpb.Product {
+	if len(config.PublicFields) > 0 && !grpcAuthenticated(ctx) {
+		p = hideProductFields(p)
+	}
+	return productToProto(p)
+}
+
+func grpcAuthenticated(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, authorization := range md.Get("authorization") {
+		if bearerTokenValid(authorization) {
+			return true
+		}
+	}
+	return false
+}
+
+// Utility - map store errors to gRPC status codes
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errProductNotFound):
+		return status.Error(codes.NotFound, "product not found")
+	case errors.As(err, new(*ValidationError)):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, new(*CatalogValueError)):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errProductLimitReached):
+		return status.Error(codes.ResourceExhausted, "product limit reached")
+	case errors.Is(err, errDBLockTimeout), errors.Is(err, errWriteBehindContended):
+		return status.Error(codes.Unavailable, "product store busy, try again")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
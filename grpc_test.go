@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"example.com/gorediscache/productpb"
+)
+
+// Serve the gRPC API in-process and return a client connected to it
+func grpcTestClient(t *testing.T) productpb.ProductServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := newGRPCServer()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return productpb.NewProductServiceClient(conn)
+}
+
+func TestGRPCGetAndUpdateProduct(t *testing.T) {
+	_, h := setupTest(t)
+	client := grpcTestClient(t)
+	ctx := context.Background()
+
+	got, err := client.GetProduct(ctx, &productpb.GetProductRequest{Id: 1})
+	if err != nil || got.GetName() != "Apple" || got.GetPrice() != 100 {
+		t.Fatalf("GetProduct: got %v, %v", got, err)
+	}
+	if _, err := client.GetProduct(ctx, &productpb.GetProductRequest{Id: 99}); status.Code(err) != codes.NotFound {
+		t.Fatalf("GetProduct of a missing product: got %v, want NotFound", err)
+	}
+
+	updated, err := client.UpdateProduct(ctx, &productpb.UpdateProductRequest{
+		Product: &productpb.Product{Id: 1, Name: "Green Apple", Price: 120},
+	})
+	if err != nil || updated.GetName() != "Green Apple" || updated.GetVersion() != 2 {
+		t.Fatalf("UpdateProduct: got %v, %v", updated, err)
+	}
+	// The REST API sees the update: both go through the same store and cache
+	var p Product
+	decodeBody(t, do(h, "GET", "/product/1", ""), &p)
+	if p.Name != "Green Apple" || p.Version != 2 {
+		t.Fatalf("REST read after a gRPC update: got %+v", p)
+	}
+
+	if _, err := client.UpdateProduct(ctx, &productpb.UpdateProductRequest{
+		Product: &productpb.Product{Id: 1, Name: "Green Apple", Price: -1},
+	}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("UpdateProduct with a negative price: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestGRPCListProducts(t *testing.T) {
+	setupTest(t)
+	client := grpcTestClient(t)
+
+	resp, err := client.ListProducts(context.Background(), &productpb.ListProductsRequest{Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetTotal() != 3 || len(resp.GetItems()) != 2 || resp.GetItems()[0].GetId() != 2 {
+		t.Fatalf("ListProducts: got %v", resp)
+	}
+}
+
+func TestGRPCUpdateRefusedWhenReadOnly(t *testing.T) {
+	setupTest(t)
+	config.ReadOnly = true
+	client := grpcTestClient(t)
+
+	_, err := client.UpdateProduct(context.Background(), &productpb.UpdateProductRequest{
+		Product: &productpb.Product{Id: 1, Name: "Green Apple", Price: 120},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("UpdateProduct on a read-only instance: got %v, want FailedPrecondition", err)
+	}
+}
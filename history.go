@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const historyDefaultLimit = 10
+
+// ProductChange is one entry in a product's change history
+type ProductChange struct {
+	Type    string    `json:"type"` // "created" or "updated"
+	Product *Product  `json:"product,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// productHistoryLog keeps the last HISTORY_SIZE changes per product, oldest
+// first. Like the DB it lives in process memory; a product's history goes
+// with it when it's deleted, so the log only holds existing products.
+type productHistoryLog struct {
+	mu      sync.Mutex
+	entries map[int][]ProductChange
+}
+
+var productHistory = &productHistoryLog{entries: map[int][]ProductChange{}}
+
+func (h *productHistoryLog) record(changeType string, id int, product *Product) {
+	if config.HistorySize <= 0 {
+		return
+	}
+	change := ProductChange{Type: changeType, At: clock.Now()}
+	if product != nil {
+		// Keep our own copy; callers may reuse the value they point to
+		snapshot := *product
+		change.Product = &snapshot
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if changeType == "deleted" {
+		delete(h.entries, id)
+		return
+	}
+	changes := append(h.entries[id], change)
+	if len(changes) > config.HistorySize {
+		changes = append([]ProductChange(nil), changes[len(changes)-config.HistorySize:]...)
+	}
+	h.entries[id] = changes
+}
+
+// The most recent changes to a product, newest first, and whether any were
+// recorded
+func (h *productHistoryLog) recent(id, limit int) ([]ProductChange, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	changes, ok := h.entries[id]
+	if limit > len(changes) {
+		limit = len(changes)
+	}
+	out := make([]ProductChange, 0, limit)
+	for i := len(changes) - 1; i >= len(changes)-limit; i-- {
+		out = append(out, changes[i])
+	}
+	return out, ok
+}
+
+// Handler - GET /product/{id}/history
+// Most recent changes first. limit defaults to 10 and is capped at
+// HISTORY_SIZE, the number of changes kept per product.
+func productHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+	limit, ok := queryInt(r.URL.Query().Get("limit"), historyDefaultLimit)
+	if !ok || limit <= 0 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	if limit > config.HistorySize {
+		limit = config.HistorySize
+	}
+
+	changes, recorded := productHistory.recent(id, limit)
+	if !recorded {
+		// No changes yet: an empty history for an existing product
+		if _, err := readProductFromDB(r.Context(), id); errors.Is(err, errProductNotFound) {
+			writeProductNotFound(w, r, id)
+			return
+		} else if err != nil {
+			writeDBLockError(w)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if publicProjection(w, r) {
+		projected := make([]map[string]interface{}, len(changes))
+		for i, change := range changes {
+			projected[i] = projectWrapped(change, "product")
+		}
+		json.NewEncoder(w).Encode(projected)
+		return
+	}
+	json.NewEncoder(w).Encode(changes)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// Utility - PUT product 1 with prices 101..100+n
+func updateApple(t *testing.T, h http.Handler, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		body := fmt.Sprintf(`{"id":1,"name":"Apple","price":%d}`, 100+i)
+		if w := do(h, "PUT", "/product/1", body); w.Code != http.StatusNoContent {
+			t.Fatalf("PUT %d: got %d", i, w.Code)
+		}
+	}
+}
+
+func TestHistoryNewestFirstWithLimit(t *testing.T) {
+	_, h := setupTest(t)
+	updateApple(t, h, 15)
+
+	var changes []ProductChange
+	decodeBody(t, do(h, "GET", "/product/1/history", ""), &changes)
+	if len(changes) != historyDefaultLimit {
+		t.Fatalf("default limit: got %d changes, want %d", len(changes), historyDefaultLimit)
+	}
+	decodeBody(t, do(h, "GET", "/product/1/history?limit=3", ""), &changes)
+	if len(changes) != 3 {
+		t.Fatalf("limit=3: got %d changes", len(changes))
+	}
+	for i, c := range changes {
+		if want := Price(115 - i); c.Type != "updated" || c.Product.Price != want {
+			t.Fatalf("change %d: got %s at %v, want updated at %v", i, c.Type, c.Product.Price, want)
+		}
+	}
+
+	for _, limit := range []string{"0", "-1", "ten"} {
+		if w := do(h, "GET", "/product/1/history?limit="+limit, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("limit=%s: got %d, want 400", limit, w.Code)
+		}
+	}
+}
+
+func TestHistoryLimitCappedAtStoredSize(t *testing.T) {
+	_, h := setupTest(t)
+	config.HistorySize = 5
+	updateApple(t, h, 8)
+
+	var changes []ProductChange
+	decodeBody(t, do(h, "GET", "/product/1/history?limit=100", ""), &changes)
+	if len(changes) != 5 || changes[0].Product.Price != 108 || changes[4].Product.Price != 104 {
+		t.Fatalf("limit past HISTORY_SIZE: got %+v", changes)
+	}
+}
+
+func TestHistoryOfUnchangedAndMissingProducts(t *testing.T) {
+	_, h := setupTest(t)
+	w := do(h, "GET", "/product/2/history", "")
+	if w.Code != http.StatusOK || w.Body.String() != "[]\n" {
+		t.Fatalf("unchanged product: got %d %q", w.Code, w.Body)
+	}
+	if w := do(h, "GET", "/product/99/history", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("missing product: got %d", w.Code)
+	}
+}
+
+func TestHistoryDroppedWithProduct(t *testing.T) {
+	_, h := setupTest(t)
+	updateApple(t, h, 2)
+	if w := do(h, "DELETE", "/product/1", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d", w.Code)
+	}
+	if _, recorded := productHistory.recent(1, 1); recorded {
+		t.Fatal("history of a deleted product still kept")
+	}
+	if w := do(h, "GET", "/product/1/history", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("history of a deleted product: got %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// How a product's popularity is judged for TTL refresh
+const (
+	hitsModeCumulative = "cumulative" // hits since the entry was cached
+	hitsModeSliding    = "sliding"    // hits within the last HITS_WINDOW
+)
+
+// Queue recording one hit in a product's sliding window: a sorted set of
+// request timestamps trimmed to the window. The returned command yields the
+// number of hits still inside it, this one included.
+func queueWindowHit(ctx context.Context, pipe redis.Pipeliner, key string, now time.Time) *redis.IntCmd {
+	score := float64(now.UnixNano())
+	// Timestamps can collide under load; the suffix keeps members distinct
+	member := strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.FormatInt(rand.Int63(), 36)
+	cutoff := strconv.FormatInt(now.Add(-config.HitsWindow).UnixNano(), 10)
+	pipe.ZAdd(ctx, key, &redis.Z{Score: score, Member: member})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+cutoff)
+	count := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, config.HitsWindow)
+	return count
+}
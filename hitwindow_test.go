@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Utility - record one windowed hit at now and return the hits in the window
+func windowHit(t *testing.T, key string, now time.Time) int64 {
+	t.Helper()
+	ctx := context.Background()
+	pipe := redisClient.Pipeline()
+	count := queueWindowHit(ctx, pipe, key, now)
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return count.Val()
+}
+
+func TestWindowHitsDecay(t *testing.T) {
+	setupTest(t)
+	config.HitsWindow = time.Minute
+	key := redisProductRecentHitsKey(1)
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		windowHit(t, key, start.Add(time.Duration(i)*time.Second))
+	}
+	if n := windowHit(t, key, start.Add(10*time.Second)); n != 6 {
+		t.Fatalf("hits within the window: got %d, want 6", n)
+	}
+	// The first two hits have aged out
+	if n := windowHit(t, key, start.Add(62*time.Second)); n != 5 {
+		t.Fatalf("hits after the oldest aged out: got %d, want 5", n)
+	}
+	if n := windowHit(t, key, start.Add(5*time.Minute)); n != 1 {
+		t.Fatalf("hits after a quiet window: got %d, want 1", n)
+	}
+	if ttl := redisClient.TTL(context.Background(), key).Val(); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("window key TTL: got %v", ttl)
+	}
+}
+
+func TestSlidingModeCountsRecentHits(t *testing.T) {
+	_, h := setupTest(t)
+	config.HitsMode = hitsModeSliding
+	ctx := context.Background()
+	do(h, "GET", "/product/1", "")
+
+	// Hits from long ago don't count toward popularity
+	old := float64(time.Now().Add(-time.Hour).UnixNano())
+	for i := 0; i < popularThreshold; i++ {
+		redisClient.ZAdd(ctx, redisProductRecentHitsKey(1), &redis.Z{Score: old + float64(i), Member: i})
+	}
+	do(h, "GET", "/product/1", "")
+	if n := redisClient.ZCard(ctx, redisProductRecentHitsKey(1)).Val(); n != 1 {
+		t.Fatalf("recent hits after a cache hit: got %d, want 1", n)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Prefix for stored idempotency records, which expire after
+	// IDEMPOTENCY_KEY_TTL
+	redisIdempotencyKeyPrefix = "idempotency:"
+
+	idempotencyHeader       = "Idempotency-Key"
+	idempotencyMaxKeyLength = 255
+	idempotencyPollInterval = 50 * time.Millisecond
+
+	idempotencyInProgress = "in_progress"
+	idempotencyDone       = "done"
+)
+
+// idempotencyRecord is what's stored per key: a marker while the first
+// request runs, then its response for replay to retries
+type idempotencyRecord struct {
+	State       string            `json:"state"`
+	Fingerprint string            `json:"fingerprint"` // hash of the request body
+	Status      int               `json:"status,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// Response headers worth replaying
+var idempotencyReplayHeaders = []string{"Content-Type", "Location"}
+
+func redisIdempotencyKey(key string) string {
+	return idempotencyCache.key(key)
+}
+
+// Middleware - make a mutating endpoint safe to retry. A request carrying an
+// Idempotency-Key runs once; retries with the same key and body get the
+// stored response. A retry arriving while the original is still running
+// waits up to IDEMPOTENCY_WAIT for it, then gets 409.
+func idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > idempotencyMaxKeyLength {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Could not read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		ctx := r.Context()
+		redisKey := redisIdempotencyKey(key)
+		marker, _ := json.Marshal(idempotencyRecord{State: idempotencyInProgress, Fingerprint: fingerprint})
+		for {
+			claimed, err := redisClient.SetNX(ctx, redisKey, marker, config.IdempotencyInFlightTTL).Result()
+			if err != nil {
+				// Without Redis we can't dedupe; serve the request rather than fail it
+				log.Printf("Idempotency claim for %q failed: %v", key, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if claimed {
+				break
+			}
+			if !replayIdempotentResponse(w, r, redisKey, fingerprint) {
+				return
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+
+		if rec.Code >= http.StatusInternalServerError {
+			// Let the client retry a failure for real
+			redisClient.Del(ctx, redisKey)
+		} else {
+			done := idempotencyRecord{
+				State:       idempotencyDone,
+				Fingerprint: fingerprint,
+				Status:      rec.Code,
+				Header:      map[string]string{},
+				Body:        rec.Body.Bytes(),
+			}
+			for _, h := range idempotencyReplayHeaders {
+				if v := rec.Header().Get(h); v != "" {
+					done.Header[h] = v
+				}
+			}
+			raw, _ := json.Marshal(done)
+			if err := redisClient.Set(ctx, redisKey, raw, idempotencyCache.TTL()).Err(); err != nil {
+				log.Printf("Idempotency store for %q failed: %v", key, err)
+			}
+		}
+
+		for h, values := range rec.Header() {
+			w.Header()[h] = values
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	})
+}
+
+// Answer a request whose key was already claimed: replay the stored
+// response, or wait for the in-flight original to finish. Returns true,
+// without writing, if the key was released and the caller should claim it.
+func replayIdempotentResponse(w http.ResponseWriter, r *http.Request, redisKey, fingerprint string) bool {
+	ctx := r.Context()
+	deadline := time.Now().Add(config.IdempotencyWait)
+	for {
+		raw, err := redisClient.Get(ctx, redisKey).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return true // the original failed or expired in the meantime
+		}
+		if err != nil {
+			log.Printf("Idempotency lookup failed: %v", err)
+			http.Error(w, "Could not check Idempotency-Key", http.StatusServiceUnavailable)
+			return false
+		}
+		var stored idempotencyRecord
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			http.Error(w, "Could not check Idempotency-Key", http.StatusServiceUnavailable)
+			return false
+		}
+		if stored.Fingerprint != fingerprint {
+			http.Error(w, "Idempotency-Key reused with a different request", http.StatusUnprocessableEntity)
+			return false
+		}
+		if stored.State == idempotencyDone {
+			for h, v := range stored.Header {
+				w.Header().Set(h, v)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return false
+		}
+
+		if !time.Now().Before(deadline) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Request with this Idempotency-Key is still in progress", http.StatusConflict)
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			writeTimeoutError(w, ctx.Err())
+			return false
+		case <-time.After(idempotencyPollInterval):
+		}
+	}
+}
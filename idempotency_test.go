@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const idempotentCreate = `{"name":"Date","price":5}`
+
+// Store a record for key as if another request creating idempotentCreate
+// had claimed it
+func storeIdempotencyRecord(key string, rec idempotencyRecord) error {
+	sum := sha256.Sum256([]byte(idempotentCreate))
+	rec.Fingerprint = hex.EncodeToString(sum[:])
+	raw, _ := json.Marshal(rec)
+	return redisClient.Set(context.Background(), redisIdempotencyKey(key), raw, time.Minute).Err()
+}
+
+func TestIdempotentRetryReplaysUntilExpiry(t *testing.T) {
+	mr, h := setupTest(t)
+	config.IdempotencyKeyTTL = time.Hour
+
+	first := do(h, "POST", "/product", idempotentCreate, idempotencyHeader, "k1")
+	retry := do(h, "POST", "/product", idempotentCreate, idempotencyHeader, "k1")
+	if first.Code != http.StatusCreated || retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Fatalf("retry: got %d %q, first %d %q", retry.Code, retry.Body.String(), first.Code, first.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Header().Get("Location") != first.Header().Get("Location") {
+		t.Fatalf("retry headers: %v", retry.Header())
+	}
+	if len(fakeProductDB) != 4 {
+		t.Fatalf("%d products after a create and its retry, want 4", len(fakeProductDB))
+	}
+	if ttl := mr.TTL(redisIdempotencyKey("k1")); ttl != time.Hour {
+		t.Fatalf("stored response TTL: got %v, want IDEMPOTENCY_KEY_TTL", ttl)
+	}
+
+	if w := do(h, "POST", "/product", `{"name":"Elder","price":5}`, idempotencyHeader, "k1"); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused with another body: got %d, want 422", w.Code)
+	}
+
+	// After the lifetime the key is forgotten and the request runs again
+	mr.FastForward(time.Hour)
+	if w := do(h, "POST", "/product", idempotentCreate, idempotencyHeader, "k1"); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("request after expiry: got %d %v", w.Code, w.Header())
+	}
+	if len(fakeProductDB) != 5 {
+		t.Fatalf("%d products after expiry, want 5", len(fakeProductDB))
+	}
+}
+
+func TestIdempotentRetryWhileInProgress(t *testing.T) {
+	_, h := setupTest(t)
+	config.IdempotencyWait = 100 * time.Millisecond
+	if err := storeIdempotencyRecord("k1", idempotencyRecord{State: idempotencyInProgress}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := do(h, "POST", "/product", idempotentCreate, idempotencyHeader, "k1")
+	if w.Code != http.StatusConflict || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("retry while in progress: got %d %v, want 409 with Retry-After", w.Code, w.Header())
+	}
+	if len(fakeProductDB) != 3 {
+		t.Fatal("retry created a duplicate while the original was in progress")
+	}
+
+	// A retry waiting when the original finishes gets its response
+	config.IdempotencyWait = 2 * time.Second
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		time.Sleep(100 * time.Millisecond)
+		storeIdempotencyRecord("k1", idempotencyRecord{State: idempotencyDone, Status: http.StatusCreated, Body: []byte(`{"id":4}`)})
+	}()
+	w = do(h, "POST", "/product", idempotentCreate, idempotencyHeader, "k1")
+	<-finished // its SET may still be in the redis hooks when the retry returns
+	if w.Code != http.StatusCreated || w.Body.String() != `{"id":4}` {
+		t.Fatalf("retry waiting for the original: got %d %q", w.Code, w.Body.String())
+	}
+	if len(fakeProductDB) != 3 {
+		t.Fatal("retry created a duplicate")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ID generation strategies for POST /product
+const (
+	idStrategyMaxPlusOne = "max_plus_one" // highest existing ID + 1; may reuse IDs after deletes
+	idStrategyRedisIncr  = "redis_incr"   // fleet-wide monotonic counter, never reuses IDs
+	idStrategyRandom     = "random"       // random unused positive ID
+)
+
+// Monotonic ID counter for the redis_incr strategy, kept without a TTL
+const redisProductIDSeqKey = "products:id:seq"
+
+var errProductIDTaken = errors.New("allocated product id already in use")
+
+// Raise the counter to at least ARGV[1], never lowering it
+var raiseIDSeqScript = redis.NewScript(`
+local cur = tonumber(redis.call('GET', KEYS[1]) or '0')
+if cur < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], ARGV[1])
+	return tonumber(ARGV[1])
+end
+return cur
+`)
+
+// Make sure the Redis ID counter is at or above the highest existing ID
+func initProductIDSeq(ctx context.Context) error {
+	if config.IDStrategy != idStrategyRedisIncr {
+		return nil
+	}
+	if err := rlockDB(ctx); err != nil {
+		return err
+	}
+	maxID := 0
+	for id := range fakeProductDB {
+		if id > maxID {
+			maxID = id
+		}
+	}
+	fakeDBLock.RUnlock()
+	return raiseIDSeqScript.Run(ctx, redisClient, []string{redisProductIDSeqKey}, maxID).Err()
+}
+
+// Reserve an ID ahead of taking the DB lock, for strategies that need a
+// network round trip. Returns 0 when the ID is assigned under the lock.
+func reserveProductID(ctx context.Context) (int, error) {
+	if config.IDStrategy != idStrategyRedisIncr {
+		return 0, nil
+	}
+	n, err := redisClient.Incr(ctx, redisProductIDSeqKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("incr id sequence: %w", err)
+	}
+	return int(n), nil
+}
+
+// Pick the ID for a new product. Callers must hold fakeDBLock for writing so
+// the choice and the insert are atomic.
+func assignProductID(reserved int) (int, error) {
+	switch config.IDStrategy {
+	case idStrategyRedisIncr:
+		if _, taken := fakeProductDB[reserved]; taken {
+			return 0, errProductIDTaken
+		}
+		return reserved, nil
+	case idStrategyRandom:
+		for {
+			id := rand.Intn(1<<31-1) + 1
+			if _, taken := fakeProductDB[id]; !taken {
+				return id, nil
+			}
+		}
+	default:
+		id := 1
+		for existing := range fakeProductDB {
+			if existing >= id {
+				id = existing + 1
+			}
+		}
+		return id, nil
+	}
+}
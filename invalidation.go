@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// invalidationMessage is published on the invalidation channel whenever
+// cached products change, so other instances can drop in-process copies.
+// IDs mutated within one batch window share a single message.
+type invalidationMessage struct {
+	IDs []int `json:"ids"`
+}
+
+// invalidationBatcher coalesces invalidated IDs over a short window
+type invalidationBatcher struct {
+	mu      sync.Mutex
+	pending map[int]struct{}
+	timer   *time.Timer
+}
+
+var invalidations = &invalidationBatcher{pending: map[int]struct{}{}}
+
+// Queue an ID for the next invalidation message
+func (b *invalidationBatcher) add(id int) {
+	if !config.InvalidationPubSub {
+		return
+	}
+	if config.InvalidationBatchWindow <= 0 {
+		publishInvalidation([]int{id})
+		return
+	}
+	b.mu.Lock()
+	b.pending[id] = struct{}{}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(config.InvalidationBatchWindow, b.flush)
+	}
+	b.mu.Unlock()
+}
+
+// Publish everything queued so far as one message
+func (b *invalidationBatcher) flush() {
+	b.mu.Lock()
+	ids := make([]int, 0, len(b.pending))
+	for id := range b.pending {
+		ids = append(ids, id)
+	}
+	b.pending = map[int]struct{}{}
+	b.timer = nil
+	b.mu.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+	sort.Ints(ids)
+	publishInvalidation(ids)
+}
+
+func publishInvalidation(ids []int) {
+	payload, _ := json.Marshal(invalidationMessage{IDs: ids})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := redisClient.Publish(ctx, config.InvalidationChannel, payload).Err(); err != nil {
+		log.Printf("Invalidation publish error: %v", err)
+	}
+}
+
+// Background goroutine - apply invalidations published by any instance
+func runInvalidationSubscriber(ctx context.Context) {
+	sub := redisClient.Subscribe(ctx, config.InvalidationChannel)
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var m invalidationMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				log.Printf("Ignoring malformed invalidation message: %v", err)
+				continue
+			}
+			dropLocalProductState(m.IDs)
+		}
+	}
+}
+
+// Forget in-process data derived from the given products
+func dropLocalProductState(ids []int) {
+	popular.drop(ids)
+	productResponses.drop(ids)
+	staleProducts.drop(ids)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBulkUpdatePublishesOneBatchedInvalidation(t *testing.T) {
+	_, h := setupTest(t)
+	config.InvalidationPubSub = true
+	config.InvalidationBatchWindow = time.Hour // flushed by hand below
+	ctx := context.Background()
+	sub := redisClient.Subscribe(ctx, config.InvalidationChannel)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	w := do(h, "POST", "/products/bulk", `[
+		{"op":"update","product":{"id":1,"name":"Green Apple","price":120}},
+		{"op":"update","product":{"id":2,"name":"Plantain","price":60}},
+		{"op":"update","product":{"id":3,"name":"Sour Cherry","price":210}}
+	]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bulk update: got %d %s", w.Code, w.Body.String())
+	}
+	invalidations.mu.Lock()
+	invalidations.timer.Stop()
+	invalidations.mu.Unlock()
+	invalidations.flush()
+
+	ch := sub.Channel()
+	var msg invalidationMessage
+	select {
+	case m := <-ch:
+		if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no invalidation published")
+	}
+	if !reflect.DeepEqual(msg.IDs, []int{1, 2, 3}) {
+		t.Fatalf("invalidation message: got IDs %v, want [1 2 3]", msg.IDs)
+	}
+	select {
+	case m := <-ch:
+		t.Fatalf("a second invalidation was published: %s", m.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	listDefaultLimit = 50
+	listMaxLimit     = 1000
+
+	listShapeEnvelope = "envelope"
+	listShapeArray    = "array"
+
+	listSortID         = "id"
+	listSortPopularity = "popularity"
+)
+
+// ProductList is the paginated envelope returned by GET /products
+type ProductList struct {
+	Items  []Product `json:"items"`
+	Total  int       `json:"total"`
+	Limit  int       `json:"limit"`
+	Offset int       `json:"offset"`
+}
+
+// productFilter narrows the list endpoint by name and price range, and
+// afterID starts a page after a given product for cursor-style paging
+type productFilter struct {
+	name     string // case-insensitive substring
+	minPrice *Price
+	maxPrice *Price
+	afterID  int
+}
+
+func newProductFilter(name string) productFilter {
+	return productFilter{name: strings.ToLower(name)}
+}
+
+func (f productFilter) match(p Product) bool {
+	if p.ID <= f.afterID {
+		return false
+	}
+	if f.name != "" && !strings.Contains(strings.ToLower(p.Name), f.name) {
+		return false
+	}
+	if f.minPrice != nil && p.Price < *f.minPrice {
+		return false
+	}
+	if f.maxPrice != nil && p.Price > *f.maxPrice {
+		return false
+	}
+	return true
+}
+
+// Handler - GET, HEAD /products
+func listProductsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	shape := q.Get("shape")
+	if shape == "" {
+		shape = listShapeEnvelope
+	}
+	if shape != listShapeEnvelope && shape != listShapeArray {
+		http.Error(w, "Invalid shape", http.StatusBadRequest)
+		return
+	}
+
+	limit, ok := queryInt(q.Get("limit"), listDefaultLimit)
+	if !ok || limit <= 0 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	if limit > listMaxLimit {
+		limit = listMaxLimit
+	}
+	offset, ok := queryInt(q.Get("offset"), 0)
+	if !ok || offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	if config.ListMaxOffset > 0 && offset > config.ListMaxOffset {
+		http.Error(w, fmt.Sprintf("offset exceeds the maximum of %d; page with after_id=<last ID of the previous page> instead", config.ListMaxOffset), http.StatusBadRequest)
+		return
+	}
+
+	sortBy := q.Get("sort")
+	if sortBy == "" {
+		sortBy = listSortID
+	}
+	if sortBy != listSortID && sortBy != listSortPopularity {
+		http.Error(w, "Invalid sort", http.StatusBadRequest)
+		return
+	}
+	if sortBy == listSortPopularity && q.Get("after_id") != "" {
+		http.Error(w, "after_id pages by ID and can't be combined with sort=popularity", http.StatusBadRequest)
+		return
+	}
+
+	filter := newProductFilter(q.Get("name"))
+	if s := q.Get("after_id"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid after_id", http.StatusBadRequest)
+			return
+		}
+		filter.afterID = n
+	}
+	for key, dst := range map[string]**Price{"min_price": &filter.minPrice, "max_price": &filter.maxPrice} {
+		if s := q.Get(key); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				http.Error(w, "Invalid "+key, http.StatusBadRequest)
+				return
+			}
+			p := Price(n)
+			*dst = &p
+		}
+	}
+
+	matched, err := queryProducts(r.Context(), filter)
+	if err != nil {
+		writeDBLockError(w)
+		return
+	}
+	if sortBy == listSortPopularity {
+		if err := sortByPopularity(r.Context(), matched); err != nil {
+			log.Printf("Popularity sort error: %v", err)
+			http.Error(w, "Could not load popularity", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	// The ETag covers the whole matching set, in order, plus the page/shape
+	// requested, so any create, update, delete or reranking affecting the
+	// result changes it
+	projected := publicProjection(w, r)
+	etag := collectionETag(matched, fmt.Sprintf("%s:%d:%d:%t", shape, limit, offset, projected))
+	w.Header().Set("ETag", etag)
+	// Total matches after filtering, before pagination
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(matched)))
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == http.MethodHead {
+		// Headers only; clients use this to cheaply check for changes
+		w.Header().Set("Content-Type", "application/json")
+		return
+	}
+
+	page := paginate(matched, limit, offset)
+	if len(page) == 0 && config.EmptyListItems == emptyListNull {
+		page = nil // legacy clients that expect "items": null
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if projected {
+		items := projectProducts(page)
+		if page == nil {
+			items = nil
+		}
+		if shape == listShapeArray {
+			json.NewEncoder(w).Encode(items)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items, "total": len(matched), "limit": limit, "offset": offset})
+		return
+	}
+	if shape == listShapeArray {
+		json.NewEncoder(w).Encode(page)
+		return
+	}
+	json.NewEncoder(w).Encode(ProductList{Items: page, Total: len(matched), Limit: limit, Offset: offset})
+}
+
+// Utility - weak ETag over the IDs and versions of a product set
+func collectionETag(products []Product, variant string) string {
+	h := fnv.New64a()
+	io.WriteString(h, variant)
+	for _, p := range products {
+		fmt.Fprintf(h, "|%d:%d", p.ID, p.Version)
+	}
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// Utility - whether an If-None-Match header matches the given ETag, using
+// weak comparison as RFC 7232 requires for If-None-Match
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// Utility - parse an optional integer query param
+func queryInt(s string, def int) (int, bool) {
+	if s == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestListShapes(t *testing.T) {
+	_, h := setupTest(t)
+
+	var envelope ProductList
+	decodeBody(t, do(h, "GET", "/products?limit=2&offset=1", ""), &envelope)
+	var array []Product
+	decodeBody(t, do(h, "GET", "/products?shape=array&limit=2&offset=1", ""), &array)
+
+	if envelope.Total != 3 || envelope.Limit != 2 || envelope.Offset != 1 {
+		t.Fatalf("envelope: got total %d limit %d offset %d", envelope.Total, envelope.Limit, envelope.Offset)
+	}
+	if len(array) != 2 || array[0].ID != 2 || !reflect.DeepEqual(array, envelope.Items) {
+		t.Fatalf("array %+v doesn't match envelope items %+v", array, envelope.Items)
+	}
+	if w := do(h, "GET", "/products?shape=table", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown shape: got %d, want 400", w.Code)
+	}
+}
+
+func TestListHeadHasHeadersOnly(t *testing.T) {
+	_, h := setupTest(t)
+
+	get := do(h, "GET", "/products", "")
+	head := do(h, "HEAD", "/products", "")
+	if head.Code != http.StatusOK || head.Body.Len() != 0 {
+		t.Fatalf("HEAD /products: got %d with %d body bytes", head.Code, head.Body.Len())
+	}
+	if head.Header().Get("ETag") == "" || head.Header().Get("ETag") != get.Header().Get("ETag") {
+		t.Fatalf("HEAD ETag %q doesn't match GET's %q", head.Header().Get("ETag"), get.Header().Get("ETag"))
+	}
+	if head.Header().Get("X-Total-Count") != "3" {
+		t.Fatalf("HEAD X-Total-Count: got %q", head.Header().Get("X-Total-Count"))
+	}
+
+	do(h, "PUT", "/product/2", `{"id":2,"name":"Plantain","price":60}`)
+	if etag := do(h, "HEAD", "/products", "").Header().Get("ETag"); etag == head.Header().Get("ETag") {
+		t.Fatal("HEAD ETag unchanged by an update")
+	}
+}
+
+func TestListTotalCountHeaderUnderFilter(t *testing.T) {
+	_, h := setupTest(t)
+
+	w := do(h, "GET", "/products?min_price=60&limit=1", "")
+	var list ProductList
+	decodeBody(t, w, &list)
+	if got := w.Header().Get("X-Total-Count"); got != "2" || list.Total != 2 || len(list.Items) != 1 {
+		t.Fatalf("X-Total-Count %q, total %d, %d items; want 2, 2, 1", got, list.Total, len(list.Items))
+	}
+	if got := do(h, "GET", "/products?shape=array&name=an", "").Header().Get("X-Total-Count"); got != "1" {
+		t.Fatalf("X-Total-Count for name=an: got %q, want 1", got)
+	}
+}
+
+func TestEmptyListItems(t *testing.T) {
+	_, h := setupTest(t)
+
+	for path, want := range map[string]string{
+		"/products?name=kiwi":             `"items":[]`,
+		"/products?shape=array&name=kiwi": `[]`,
+		"/products?offset=10":             `"items":[]`,
+	} {
+		if body := do(h, "GET", path, "").Body.String(); !strings.Contains(body, want) {
+			t.Fatalf("GET %s: got %s, want %s", path, body, want)
+		}
+	}
+
+	config.EmptyListItems = emptyListNull
+	if body := do(h, "GET", "/products?name=kiwi", "").Body.String(); !strings.Contains(body, `"items":null`) {
+		t.Fatalf("EMPTY_LIST_ITEMS=null: got %s", body)
+	}
+}
+
+func TestListMaxOffset(t *testing.T) {
+	_, h := setupTest(t)
+	if w := do(h, "GET", "/products?offset=1000000", ""); w.Code != http.StatusOK {
+		t.Fatalf("deep offset without a cap: got %d", w.Code)
+	}
+
+	config.ListMaxOffset = 2
+	var list ProductList
+	decodeBody(t, do(h, "GET", "/products?offset=2", ""), &list)
+	if len(list.Items) != 1 || list.Items[0].ID != 3 {
+		t.Fatalf("offset at the cap: got %+v", list)
+	}
+	w := do(h, "GET", "/products?offset=3", "")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "after_id") {
+		t.Fatalf("offset past the cap: got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestListSortedByPopularity(t *testing.T) {
+	_, h := setupTest(t)
+	do(h, "POST", "/product", `{"name":"Date","price":5}`)
+	redisClient.ZAdd(context.Background(), redisPopularityKey,
+		&redis.Z{Score: 5, Member: "3"}, &redis.Z{Score: 9, Member: "2"}, &redis.Z{Score: 5, Member: "1"})
+
+	var list ProductList
+	decodeBody(t, do(h, "GET", "/products?sort=popularity", ""), &list)
+	var ids []int
+	for _, p := range list.Items {
+		ids = append(ids, p.ID)
+	}
+	// Ties by ID; unranked products score 0
+	if want := []int{2, 1, 3, 4}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("popularity order: got %v, want %v", ids, want)
+	}
+
+	decodeBody(t, do(h, "GET", "/products?sort=popularity&limit=2&offset=1", ""), &list)
+	if len(list.Items) != 2 || list.Items[0].ID != 1 || list.Items[1].ID != 3 {
+		t.Fatalf("second page: got %+v", list.Items)
+	}
+	if w := do(h, "GET", "/products?sort=popularity&after_id=1", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("sort=popularity with after_id: got %d", w.Code)
+	}
+	if w := do(h, "GET", "/products?sort=price", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown sort: got %d", w.Code)
+	}
+}
+
+func TestListUnderConcurrentMutations(t *testing.T) {
+	_, h := setupTest(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				id := 10 + i*20 + j
+				do(h, "PUT", fmt.Sprintf("/product/%d", id), fmt.Sprintf(`{"id":%d,"name":"Fig","price":%d}`, id, j+1))
+				do(h, "PUT", "/product/1", fmt.Sprintf(`{"id":1,"name":"Apple","price":%d}`, j+1))
+				do(h, "DELETE", fmt.Sprintf("/product/%d", id), "")
+			}
+		}(i)
+	}
+	errs := make(chan string, 80)
+	for _, path := range []string{"/products", "/products?sort=popularity", "/products?min_price=10"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				w := do(h, "GET", path, "")
+				var list ProductList
+				if err := json.Unmarshal(w.Body.Bytes(), &list); w.Code != http.StatusOK || err != nil {
+					errs <- fmt.Sprintf("GET %s: got %d, %v", path, w.Code, err)
+					return
+				}
+				for _, p := range list.Items {
+					if p.Name == "" || p.Version == 0 {
+						errs <- fmt.Sprintf("GET %s: torn product %+v", path, p)
+						return
+					}
+				}
+			}
+		}(path)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
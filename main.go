@@ -3,16 +3,24 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
 )
 
 // Product represents a product entity
@@ -20,25 +28,35 @@ import (
 // In practice, you might want to expand this struct
 //
 type Product struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Price int    `json:"price"`
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Price   Price  `json:"price"`
+	Version int    `json:"version"` // bumped on every update
+	// NoCache keeps the product out of the cache: every read goes to the DB
+	NoCache bool `json:"no_cache,omitempty"`
 }
 
-// Simulated DB
+// Simulated DB. Stored products are never modified in place: writers
+// replace the pointer under the write lock, and readers copy the value out
+// before releasing the read lock, so nothing read from the map can change
+// underneath them.
 var (
 	fakeProductDB = map[int]*Product{
-		1: {ID: 1, Name: "Apple", Price: 100},
-		2: {ID: 2, Name: "Banana", Price: 50},
-		3: {ID: 3, Name: "Cherry", Price: 200},
+		1: {ID: 1, Name: "Apple", Price: 100, Version: 1},
+		2: {ID: 2, Name: "Banana", Price: 50, Version: 1},
+		3: {ID: 3, Name: "Cherry", Price: 200, Version: 1},
 	}
 	fakeDBLock = &sync.RWMutex{}
 )
 
 const (
+	// The cache cleaner scans this prefix and reaps what it finds there, so
+	// state that isn't a cache entry (counters, rankings, queues, records
+	// with their own TTL) lives under other prefixes
 	redisProductKeyPrefix = "product:"
 	redisProductTTL       = 30 * time.Second // e.g., 30s TTL
 	popularThreshold      = 2                // min hits to refresh TTL
+	redisTombstoneValue   = "__tombstone__"  // marks a recently mutated product
 )
 
 var (
@@ -47,44 +65,270 @@ var (
 )
 
 func main() {
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "localhost:6379"
+	configPath := flag.String("config", "", "path to a YAML or JSON config file; environment variables override it")
+	flag.Parse()
+	var err error
+	config, err = loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if config.LogEffectiveConfig {
+		logEffectiveConfig(slog.Default(), config, *configPath)
 	}
+	warnStalenessBound(config)
 	redisClient = redis.NewClient(&redis.Options{
-		Addr: redisAddr,
+		Addr: config.RedisAddr,
 	})
+	redisClient.AddHook(breakerHook{})
+	redisClient.AddHook(readOnlyHook{})
+	redisClient.AddHook(clusterHook{})
 
-	ctx := context.Background()
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		log.Fatalf("Could not connect to Redis: %v", err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if config.StartupGate {
+		// Listen first and hold requests at the gate while initializing
+		atomic.StoreInt32(&serviceReady, 0)
+	} else if err := initializeService(ctx); err != nil {
+		log.Fatalf("Startup failed: %v", err)
 	}
 
 	// Start the cache cleaner background goroutine
+	if config.CleanerMaxOps > 0 {
+		// A burst of one spreads the commands evenly over each second
+		cleanerLimiter = newTokenBucket(config.CleanerMaxOps, 1)
+	}
 	bgWg.Add(1)
 	go func() {
 		defer bgWg.Done()
 		runCacheCleaner(ctx)
 	}()
 
+	if config.CacheEpochPollInterval > 0 {
+		bgWg.Add(1)
+		go func() {
+			defer bgWg.Done()
+			runCacheEpochPoller(ctx)
+		}()
+	}
+
+	if config.MemoryPollInterval > 0 {
+		bgWg.Add(1)
+		go func() {
+			defer bgWg.Done()
+			runRedisMemoryMonitor(ctx)
+		}()
+	}
+
+	if config.CacheUpdateMode == cacheUpdateWriteBehind {
+		bgWg.Add(1)
+		go func() {
+			defer bgWg.Done()
+			runWriteBehindWorker(ctx)
+		}()
+	}
+
+	registerConfiguredShutdownHooks()
+
+	if config.AsyncWrites {
+		bgWg.Add(1)
+		go func() {
+			defer bgWg.Done()
+			runAsyncWriteWorker(ctx)
+		}()
+	}
+
+	if config.InvalidationPubSub {
+		bgWg.Add(1)
+		go func() {
+			defer bgWg.Done()
+			runInvalidationSubscriber(ctx)
+		}()
+	}
+
+	rec, metricsHandler, err := newRecorder(config)
+	if err != nil {
+		log.Fatalf("Could not set up metrics: %v", err)
+	}
+	metrics = rec
+
+	if config.CacheBypassRate > 0 {
+		cacheBypassLimiter = newTokenBucket(config.CacheBypassRate, config.CacheBypassBurst)
+	}
+	if trustedProxyNets, err = parseTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if deprecations, err = parseDeprecations(config.Deprecations); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if exchangeRates, err = newStaticRates(config.BaseCurrency, config.ExchangeRates); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if config.CacheBypassIPRate > 0 {
+		cacheBypassIPLimiter = newKeyedTokenBuckets(config.CacheBypassIPRate, config.CacheBypassIPBurst)
+	}
+	if config.RateLimit > 0 {
+		requestRateLimiter = newKeyedTokenBuckets(config.RateLimit, config.RateLimitBurst)
+	}
+
+	if config.Tracing {
+		shutdownTracing, err := setupTracing(ctx)
+		if err != nil {
+			log.Fatalf("Could not set up tracing: %v", err)
+		}
+		defer shutdownTracing(context.Background())
+	}
+
+	// Oversized headers are rejected by the server itself with 431
+	srv := &http.Server{
+		Addr:           config.HTTPAddr,
+		Handler:        newHandler(rec, metricsHandler),
+		MaxHeaderBytes: config.MaxHeaderBytes,
+	}
+
+	go func() {
+		log.Printf("Listening on %s...", config.HTTPAddr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server error: %v", err)
+		}
+	}()
+
+	if config.StartupGate {
+		if err := initializeService(ctx); err != nil {
+			log.Fatalf("Startup failed: %v", err)
+		}
+		atomic.StoreInt32(&serviceReady, 1)
+		log.Println("Initialization complete; accepting requests")
+	}
+
+	var grpcServer *grpc.Server
+	if config.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", config.GRPCAddr)
+		if err != nil {
+			log.Fatalf("gRPC listen error: %v", err)
+		}
+		grpcServer = newGRPCServer()
+		go func() {
+			log.Printf("gRPC listening on %s...", config.GRPCAddr)
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatalf("gRPC server error: %v", err)
+			}
+		}()
+	}
+
+	// Wait for a termination signal, then drain both servers and stop the
+	// background goroutines
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancelShutdown()
+	shutdownServers(shutdownCtx, srv, grpcServer)
+	if config.CacheSnapshotPath != "" {
+		if err := dumpCacheSnapshot(shutdownCtx, config.CacheSnapshotPath); err != nil {
+			log.Printf("Cache snapshot: could not save: %v", err)
+		}
+	}
+	cancel()
+	bgWg.Wait()
+	invalidations.flush()
+}
+
+// Build the HTTP handler: every route, wrapped in the middleware chain.
+// metricsHandler serves /metrics and is nil for push-based backends.
+func newHandler(rec Recorder, metricsHandler http.Handler) http.Handler {
 	r := mux.NewRouter()
+	r.Use(traceRouteMiddleware)
+	r.Use(metricsMiddleware)
+	r.Use(deprecationMiddleware)
+	r.Use(breakerOpenMiddleware)
+	if metricsHandler != nil {
+		r.Handle("/metrics", metricsHandler).Methods("GET")
+		if p, ok := rec.(*prometheusRecorder); ok {
+			r.HandleFunc("/metrics-summary", p.summaryHandler).Methods("GET")
+		}
+	}
 	r.HandleFunc("/product/{id:[0-9]+}", getProductHandler).Methods("GET")
+	r.HandleFunc("/product/{id:[0-9]+}", headProductHandler).Methods("HEAD")
 	r.HandleFunc("/product/{id:[0-9]+}", updateProductHandler).Methods("PUT")
+	r.HandleFunc("/product/{id:[0-9]+}", deleteProductHandler).Methods("DELETE")
+	r.Handle("/product", idempotencyMiddleware(http.HandlerFunc(createProductHandler))).Methods("POST")
+	r.HandleFunc("/product/{id:[0-9]+}/stats", productStatsHandler).Methods("GET")
+	r.HandleFunc("/product/{id:[0-9]+}/history", productHistoryHandler).Methods("GET")
+	r.HandleFunc("/products", listProductsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/products/popular", popularProductsHandler).Methods("GET")
+	r.HandleFunc("/products/events", productEventsHandler).Methods("GET")
+	r.HandleFunc("/products/batch", batchProductsHandler).Methods("GET")
+	r.HandleFunc("/products/batch", postBatchProductsHandler).Methods("POST")
+	r.HandleFunc("/products/bulk", bulkProductsHandler).Methods("POST")
+	r.HandleFunc("/writes/{id:[0-9a-f]+}", writeStatusHandler).Methods("GET")
+	r.HandleFunc("/stats", statsHandler).Methods("GET")
+	r.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	r.HandleFunc("/readyz", readyzHandler).Methods("GET")
+	r.HandleFunc("/products/export", exportProductsHandler).Methods("GET")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(adminAuthMiddleware)
+	admin.HandleFunc("/cache/extend", extendCacheHandler).Methods("POST")
+	admin.HandleFunc("/cache/size", cacheSizeHandler).Methods("GET")
+	admin.HandleFunc("/cache/epoch", bumpCacheEpochHandler).Methods("POST")
+	admin.HandleFunc("/product/{id:[0-9]+}", adminGetProductHandler).Methods("GET")
+	admin.HandleFunc("/cleaner/pause", pauseCleanerHandler).Methods("POST")
+	admin.HandleFunc("/cleaner/resume", resumeCleanerHandler).Methods("POST")
 
-	log.Println("Listening on :8080...")
-	if err := http.ListenAndServe(":8080", r); err != nil {
-		log.Fatalf("HTTP server error: %v", err)
+	return tracingMiddleware(accessLogMiddleware(requestLimitsMiddleware(requestDecompressionMiddleware(startupGateMiddleware(requestTimeoutMiddleware(userAgentMiddleware(compressionMiddleware(traceErrorBodyMiddleware(corsMiddleware(rateLimitMiddleware(readOnlyModeMiddleware(r))))))))))))
+}
+
+// Stop serving: run the shutdown hooks while still accepting requests, then
+// drain both servers
+func shutdownServers(ctx context.Context, srv *http.Server, grpcServer *grpc.Server) {
+	runShutdownHooks(ctx)
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP shutdown error: %v", err)
+	}
+	if grpcServer != nil {
+		stopGRPCServer(ctx, grpcServer)
 	}
 }
 
-// Utility - build Redis key for a product
+// Utility - drain in-flight RPCs, forcing a stop if ctx expires first
+func stopGRPCServer(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// Utility - build Redis key for a product, under the configured cache schema
 func redisProductKey(id int) string {
-	return fmt.Sprintf("%s%d", redisProductKeyPrefix, id)
+	return redisProductKeyForSchema(id, config.CacheSchemaVersion)
 }
 
 // Utility - build Redis hit count key for a product
 func redisProductHitsKey(id int) string {
-	return fmt.Sprintf("%s%d:hits", redisProductKeyPrefix, id)
+	return productCache.key(id, "hits")
+}
+
+// Utility - build Redis key for a product's sliding-window hit timestamps
+func redisProductRecentHitsKey(id int) string {
+	return productCache.key(id, "recent")
+}
+
+// Utility - build Redis key marking a product's current popularity episode
+func redisProductPopularMarkerKey(id int) string {
+	return productCache.key(id, "popular")
+}
+
+// Utility - build Redis populate lock key for a product
+func redisProductPopulateLockKey(id int) string {
+	return productCache.key(id, "populate")
 }
 
 // Handler - GET /product/{id}
@@ -97,44 +341,240 @@ func getProductHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid product id", http.StatusBadRequest)
 		return
 	}
+	if !checkAcceptable(w, r, productContentTypes) {
+		return
+	}
 
-	redisKey := redisProductKey(id)
-	redisHitsKey := redisProductHitsKey(id)
-	var product Product
+	knownVersion := -1
+	if s := r.URL.Query().Get("known_version"); s != "" {
+		knownVersion, err = strconv.Atoi(s)
+		if err != nil || knownVersion < 0 {
+			http.Error(w, "Invalid known_version", http.StatusBadRequest)
+			return
+		}
+	}
 
-	cacheHit := false
-	data, err := redisClient.Get(ctx, redisKey).Result()
-	if err == nil {
-		if err := json.Unmarshal([]byte(data), &product); err == nil {
-			cacheHit = true
-			// Increment hit count
-			hits, _ := redisClient.Incr(ctx, redisHitsKey).Result()
-
-			if hits >= popularThreshold {
-				// Refresh TTL for popular items
-				redisClient.Expire(ctx, redisKey, redisProductTTL)
-				redisClient.Expire(ctx, redisHitsKey, redisProductTTL)
-			}
+	currency := r.URL.Query().Get("currency")
+	if currency != "" {
+		// Validate before loading, so a bad request doesn't count as a hit
+		if _, err := convertProductPrice(Product{}, currency); err != nil {
+			http.Error(w, "Unsupported currency", http.StatusBadRequest)
+			return
 		}
 	}
-	if !cacheHit {
-		// Not found or not deserialized; get from DB
-		fakeDBLock.RLock()
-		dbProduct, ok := fakeProductDB[id]
-		fakeDBLock.RUnlock()
-		if !ok {
-			http.Error(w, "Product not found", http.StatusNotFound)
+	bypass := cacheBypassRequested(r) && allowCacheBypass(r)
+	// The dedup cache holds full representations without links only
+	projected := publicProjection(w, r)
+	if config.ResponseDedupWindow > 0 && !bypass && knownVersion < 0 && currency == "" && !projected && !wantProductLinks(r) {
+		getProductDeduped(w, r, id)
+		return
+	}
+	product, info, err := loadProductWithInfo(ctx, id, bypass)
+	if errors.Is(err, errProductNotFound) {
+		writeProductNotFound(w, r, id)
+		return
+	}
+	if err != nil {
+		if serveStaleProduct(w, r, id) {
+			return
+		}
+		writeDBLockError(w)
+		return
+	}
+
+	writeCacheDiagnostics(w, r, info)
+	if knownVersion >= 0 && product.Version == knownVersion {
+		writeProductUnchanged(w, product)
+		return
+	}
+
+	if currency != "" {
+		writeProductInCurrency(w, r, product, currency)
+		return
+	}
+	writeProduct(w, r, product)
+}
+
+// Handler - HEAD /product/{id}
+// With CACHE_ON_HEAD a HEAD behaves like a GET towards the cache (populates
+// it and counts a hit); otherwise it only peeks, so HEAD traffic can't churn
+// the cache or inflate popularity.
+func headProductHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+	if !checkAcceptable(w, r, productContentTypes) {
+		return
+	}
+
+	if config.CacheOnHead {
+		_, err = loadProduct(ctx, id, false)
+	} else {
+		_, err = peekProduct(ctx, id)
+	}
+	if errors.Is(err, errProductNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeDBLockError(w)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", negotiateContentType(r.Header.Get("Accept"), productContentTypes))
+	w.WriteHeader(http.StatusOK)
+}
+
+// Utility - write a product in the representation the client negotiated via
+// Accept; JSON unless protobuf is preferred. Unauthenticated clients get
+// the PUBLIC_FIELDS projection when one is configured.
+func writeProduct(w http.ResponseWriter, r *http.Request, product Product) {
+	w.Header().Add("Vary", "Accept")
+	projected := publicProjection(w, r)
+	if negotiateContentType(r.Header.Get("Accept"), productContentTypes) == contentTypeProtobuf {
+		if projected {
+			product = hideProductFields(product)
+		}
+		writeProductProtobuf(w, product)
+		return
+	}
+	writeProductJSON(w, r, http.StatusOK, product, projected)
+}
+
+// Utility - write a product as JSON with status, projected for the public
+// and with _links if the request calls for them
+func writeProductJSON(w http.ResponseWriter, r *http.Request, status int, product Product, projected bool) {
+	contentType := contentTypeJSON
+	if negotiateContentType(r.Header.Get("Accept"), productContentTypes) == contentTypeHAL {
+		contentType = contentTypeHAL
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	links := wantProductLinks(r)
+	switch {
+	case projected && links:
+		fields := projectProduct(product)
+		fields["_links"] = productLinksFor(r, product.ID)
+		json.NewEncoder(w).Encode(fields)
+	case projected:
+		json.NewEncoder(w).Encode(projectProduct(product))
+	case links:
+		json.NewEncoder(w).Encode(linkedProduct{Product: product, Links: productLinksFor(r, product.ID)})
+	default:
+		json.NewEncoder(w).Encode(product)
+	}
+}
+
+// Serve a plain GET through the response dedup cache. Requests answered from
+// it skip loadProduct entirely, so they don't count as cache hits.
+func getProductDeduped(w http.ResponseWriter, r *http.Request, id int) {
+	contentType := negotiateContentType(r.Header.Get("Accept"), productContentTypes)
+	if contentType == "" {
+		contentType = contentTypeJSON
+	}
+	resp, err := productResponses.get(r.Context(), productResponseKey(id, contentType), func() (productResponse, error) {
+		product, err := loadProduct(r.Context(), id, false)
+		if err != nil {
+			return productResponse{}, err
+		}
+		return encodeProductResponse(product, contentType)
+	})
+	if errors.Is(err, errProductNotFound) {
+		writeProductNotFound(w, r, id)
+		return
+	}
+	if errors.Is(err, errDBLockTimeout) {
+		if serveStaleProduct(w, r, id) {
 			return
 		}
-		product = *dbProduct
+		writeDBLockError(w)
+		return
+	}
+	if writeTimeoutError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Product %d encode error: %v", id, err)
+		http.Error(w, "Could not encode product", http.StatusInternalServerError)
+		return
+	}
+	writeCacheDiagnostics(w, r, readInfo{Source: "response_cache"})
+	writeProductResponse(w, resp)
+}
 
-		raw, _ := json.Marshal(product)
-		redisClient.Set(ctx, redisKey, raw, redisProductTTL)
-		redisClient.Set(ctx, redisHitsKey, 1, redisProductTTL)
+// Tell a client polling with ?known_version that its copy is current, either
+// as a bare 304 or a minimal 200 body, per KNOWN_VERSION_RESPONSE
+func writeProductUnchanged(w http.ResponseWriter, product Product) {
+	if config.KnownVersionResponse == knownVersionMinimal {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":       product.ID,
+			"version":  product.Version,
+			"modified": false,
+		})
+		return
 	}
+	w.WriteHeader(http.StatusNotModified)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(product)
+// Whether a popular item's TTL should be refreshed, given its remaining TTL.
+// Refreshing at most once per TTL_REFRESH_INTERVAL avoids an Expire on every
+// hit to a hot item: the TTL is only pushed back once it has run down by at
+// least the interval since the last refresh.
+func ttlRefreshDue(remaining time.Duration) bool {
+	if config.TTLRefreshInterval <= 0 || remaining <= 0 {
+		return true
+	}
+	return remaining <= productCache.TTL()-config.TTLRefreshInterval
+}
+
+// Write a product read from the DB into the cache, unless it is marked
+// no_cache. Normally SetNX, so a
+// populate racing a mutation can't overwrite its tombstone; overwrite replaces
+// an entry known to be corrupt. With the populate lock enabled, only the
+// reader holding the lock writes and concurrent misses skip the write. gen is
+// the write generation the product was read at: if a write has happened
+// since, the populate is skipped, or undone if the write landed mid-way. So
+// is a populate that can't take the DB lock within DB_LOCK_TIMEOUT to check.
+func populateProductCache(ctx context.Context, product Product, gen uint64, overwrite bool) {
+	if product.NoCache || cachePopulatePaused() || !generationCurrent(ctx, product.ID, gen) {
+		return
+	}
+	if config.PopulateLock {
+		token, ok, _ := acquirePopulateLock(ctx, product.ID)
+		if !ok {
+			return
+		}
+		defer releasePopulateLock(ctx, product.ID, token)
+	}
+	writeProductCacheEntry(ctx, product, gen, overwrite)
+}
+
+// The write half of populateProductCache, for callers already holding the
+// populate lock or not using it
+func writeProductCacheEntry(ctx context.Context, product Product, gen uint64, overwrite bool) {
+	if cachePopulatePaused() {
+		return
+	}
+	redisKey := redisProductKey(product.ID)
+	raw := encodeCachedProduct(product, config.CacheSchemaVersion)
+	ttl := productCacheWriteTTL()
+	if overwrite {
+		redisClient.Set(ctx, redisKey, raw, ttl)
+	} else if ok, _ := redisClient.SetNX(ctx, redisKey, raw, ttl).Result(); !ok {
+		return
+	}
+	if !generationCurrent(ctx, product.ID, gen) {
+		redisClient.Del(ctx, redisKey)
+		return
+	}
+	redisClient.Set(ctx, redisProductHitsKey(product.ID), 1, ttl)
 }
 
 // Handler - PUT /product/{id}
@@ -150,65 +590,268 @@ func updateProductHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input Product
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
-	if input.ID != id {
+	switch {
+	case input.ID == id:
+	case config.PutIDPolicy == putIDPathWins:
+		input.ID = id
+	case config.PutIDPolicy == putIDBodyOptional && input.ID == 0:
+		input.ID = id
+	default:
 		http.Error(w, "ID in path and body mismatch", http.StatusBadRequest)
 		return
 	}
 
-	// Update fake DB
-	fakeDBLock.Lock()
-	fakeProductDB[id] = &Product{ID: id, Name: input.Name, Price: input.Price}
-	fakeDBLock.Unlock()
+	if config.AsyncWrites {
+		acceptProductWrite(w, r, input)
+		return
+	}
+	_, err = saveProductCoalesced(ctx, input)
+	if writeValidationError(w, r, err) || writeCatalogValueError(w, err) || writeTimeoutError(w, err) {
+		return
+	}
+	if errors.Is(err, errProductLimitReached) {
+		http.Error(w, "Product limit reached", http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil {
+		writeDBLockError(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler - POST /product
+func createProductHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var input Product
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	created, err := createProduct(ctx, input)
+	switch {
+	case errors.Is(err, errProductLimitReached):
+		http.Error(w, "Product limit reached", http.StatusInsufficientStorage)
+		return
+	case writeValidationError(w, r, err), writeCatalogValueError(w, err):
+		return
+	case errors.Is(err, errDBLockTimeout):
+		writeDBLockError(w)
+		return
+	case err != nil:
+		log.Printf("Product id allocation error: %v", err)
+		http.Error(w, "Could not allocate product id", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Location", productLocation(r, created.ID))
+	writeProductJSON(w, r, http.StatusCreated, created, publicProjection(w, r))
+}
+
+// Handler - DELETE /product/{id}
+func deleteProductHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	cond, err := parseIfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cond == nil && config.RequireIfMatchDelete {
+		http.Error(w, "If-Match with the product version is required", http.StatusPreconditionRequired)
+		return
+	}
 
-	// Invalidate related cache keys immediately after update
-	redisKey := redisProductKey(id)
-	redisHitsKey := redisProductHitsKey(id)
-	redisClient.Del(ctx, redisKey)
-	redisClient.Del(ctx, redisHitsKey)
+	err = deleteProduct(ctx, id, cond)
+	if errors.Is(err, errProductNotFound) {
+		writeProductNotFound(w, r, id)
+		return
+	}
+	if errors.Is(err, errPreconditionFailed) {
+		http.Error(w, "Product has changed since the version in If-Match", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		writeDBLockError(w)
+		return
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Utility - drop the cached product and its hit counter. With a tombstone
+// TTL configured, the product key is replaced by a short-lived marker instead
+// of deleted, so reads racing the mutation go to the DB and can't re-populate
+// the old value until the marker expires.
+func invalidateProductCache(ctx context.Context, id int) {
+	invalidations.add(id)
+	productResponses.drop([]int{id})
+	staleProducts.drop([]int{id})
+	keys := redisProductKeysAllSchemas(id)
+	if config.TombstoneTTL <= 0 {
+		cacheDel(ctx, append(keys, redisProductHitsKey(id), redisProductRecentHitsKey(id), redisProductPopularMarkerKey(id))...)
+		return
+	}
+	redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Set(ctx, key, redisTombstoneValue, config.TombstoneTTL)
+		}
+		pipe.Del(ctx, redisProductHitsKey(id), redisProductRecentHitsKey(id), redisProductPopularMarkerKey(id))
+		return nil
+	})
+}
+
+// Utility - replace the cached product with its updated value. What happens
+// to the hit counter is set by HITS_ON_UPDATE: "preserve" keeps a popular item
+// popular across edits, "reset" and "one" treat the update as a fresh entry.
+func writeThroughProductCache(ctx context.Context, product Product) {
+	invalidations.add(product.ID)
+	productResponses.drop([]int{product.ID})
+	staleProducts.drop([]int{product.ID})
+	raw := encodeCachedProduct(product, config.CacheSchemaVersion)
+	hitsKey := redisProductHitsKey(product.ID)
+	ttl := productCacheWriteTTL()
+	redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisProductKey(product.ID), raw, ttl)
+		for _, key := range redisProductKeysAllSchemas(product.ID)[1:] {
+			// Don't let a migration fallback read the pre-update value
+			pipe.Del(ctx, key)
+		}
+		switch config.HitsOnUpdate {
+		case hitsOnUpdatePreserve:
+			pipe.Expire(ctx, hitsKey, ttl)
+		case hitsOnUpdateOne:
+			pipe.Set(ctx, hitsKey, 1, ttl)
+		default:
+			pipe.Set(ctx, hitsKey, 0, ttl)
+		}
+		return nil
+	})
+}
+
+// Utility - report a request body decode failure
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var priceErr *PriceError
+	if errors.As(err, &priceErr) {
+		http.Error(w, priceErr.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Invalid JSON", http.StatusBadRequest)
+}
+
 // Background goroutine - clean expired keys
+// The first pass waits a random delay up to CLEANER_START_JITTER so a fleet
+// deployed at once doesn't scan Redis in lockstep.
 func runCacheCleaner(ctx context.Context) {
-	ticker := time.NewTicker(10 * time.Second)
+	if config.CleanerStartJitter > 0 {
+		delay := time.Duration(rand.Int63n(int64(config.CleanerStartJitter)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		runCleanerPass(ctx)
+	}
+
+	ticker := time.NewTicker(config.CleanerInterval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			cleanStaleProductKeys(ctx)
+			runCleanerPass(ctx)
+		}
+	}
+}
+
+// Set via the admin API to skip cleaner passes without stopping the goroutine
+var cleanerPaused int32
+
+func runCleanerPass(ctx context.Context) {
+	if atomic.LoadInt32(&cleanerPaused) == 1 {
+		return
+	}
+	now := time.Now()
+	if cleanerFailover.backingOff(now) {
+		return
+	}
+	err := cleanStaleProductKeys(ctx)
+	if err == nil {
+		err = cleanOrphanKeys(ctx)
+	}
+	if err == nil {
+		err = updatePopularProductsGauge(ctx)
+	}
+	if ctx.Err() != nil {
+		return // shutting down mid-pass, e.g. while throttled
+	}
+	cleanerFailover.record(now, err)
+}
+
+// Utility - report a SCAN that failed partway through a pass. A cursor is
+// only valid against the keyspace it came from, and after a reconnect the
+// server may have restarted or failed over, so passes never resume one: the
+// next tick scans again from 0.
+func logScanAbort(who string, cursor uint64, err error) {
+	if !cleanerFailover.allowLog(time.Now()) {
+		if cursor != 0 {
+			metrics.IncrCounter("cleaner_scan_restarts_total", nil)
 		}
+		return
+	}
+	if cursor == 0 {
+		log.Printf("%s scan error: %v", who, err)
+		return
 	}
+	log.Printf("%s scan interrupted mid-pass at cursor %d (connection lost?); next pass restarts from the beginning: %v", who, cursor, err)
+	metrics.IncrCounter("cleaner_scan_restarts_total", nil)
 }
 
 // Remove keys in background that are already expired or stale (belt and suspenders)
-func cleanStaleProductKeys(ctx context.Context) {
+func cleanStaleProductKeys(ctx context.Context) error {
 	// Efficiently scan keys with pattern product:*
 	var (
 		cursor uint64 = 0
 		scanCount      = int64(100)
 	)
 	for {
+		if err := cleanerOps(ctx, 1); err != nil {
+			return err
+		}
 		// Scan for keys
 		keys, nextCursor, err := redisClient.Scan(ctx, cursor, redisProductKeyPrefix+"*", scanCount).Result()
 		if err != nil {
-			log.Printf("Cache cleaner scan error: %v", err)
-			return
+			logScanAbort("Cache cleaner", cursor, err)
+			return err
 		}
 		for _, key := range keys {
+			if err := cleanerOps(ctx, 1); err != nil {
+				return err
+			}
 			// For each key, check TTL. If expired, remove.
 			ttl, err := redisClient.TTL(ctx, key).Result()
 			if err == nil && (ttl <= 0 || ttl == -1) {
+				if err := cleanerOps(ctx, 1); err != nil {
+					return err
+				}
 				redisClient.Del(ctx, key)
 			}
 		}
 		if nextCursor == 0 {
-			break
+			return nil
 		}
 		cursor = nextCursor
 	}
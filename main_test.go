@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// Reset the process-wide state a test can touch and point the service at a
+// fresh in-memory Redis. Tests adjust config after this; the handler reads
+// it on every request.
+func setupTest(t *testing.T) (*miniredis.Miniredis, http.Handler) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	config = defaultConfig()
+	// Batched publishes fire on a timer that could outlive the test
+	config.InvalidationPubSub = false
+	metrics = noopRecorder{}
+	redisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	redisClient.AddHook(breakerHook{})
+	redisClient.AddHook(readOnlyHook{})
+	redisClient.AddHook(clusterHook{})
+	t.Cleanup(func() { redisClient.Close() })
+
+	fakeDBLock = &sync.RWMutex{}
+	fakeProductDB = map[int]*Product{
+		1: {ID: 1, Name: "Apple", Price: 100, Version: 1},
+		2: {ID: 2, Name: "Banana", Price: 50, Version: 1},
+		3: {ID: 3, Name: "Cherry", Price: 200, Version: 1},
+	}
+	productGenerations = map[int]uint64{}
+	for id := range fakeProductDB {
+		bumpGenerationLocked(id)
+	}
+	redisBreaker = &circuitBreaker{state: breakerClosed}
+	cacheReadOnly = &cacheReadOnlyState{}
+	redisMemory = &redisMemoryState{}
+	cleanerPaused = 0
+	trustedProxyNets = nil
+	deprecations = nil
+	clock, sharedClock = systemClock{}, &redisClock{}
+	exchangeRates = nil
+	cleanerFailover = &cleanerBackoff{}
+	invalidations = &invalidationBatcher{pending: map[int]struct{}{}}
+	productHistory = &productHistoryLog{entries: map[int][]ProductChange{}}
+	staleProducts = &staleStore{entries: map[int]staleEntry{}}
+	popular = &popularCache{entries: map[int]popularCacheEntry{}}
+	productResponses = &responseDedupCache{entries: map[string]productResponse{}, inflight: map[string]chan struct{}{}}
+	putCoalescing = &putCoalescer{recent: map[int]coalescedPut{}}
+	cacheEpoch = 0
+	serviceReady = 1
+	coldStartUntil = 0
+	shutdownHooks, shuttingDown = nil, 0
+	statCacheHits, statCacheMisses = 0, 0
+	requestRateLimiter, cacheBypassLimiter, cacheBypassIPLimiter, cleanerLimiter = nil, nil, nil, nil
+
+	if err := initProductIDSeq(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return mr, newHandler(metrics, nil)
+}
+
+// Send a request through h, with headers given as name, value pairs
+func do(h http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// Decode a JSON response body into v
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+// Utility - a product straight from the fake DB, for assertions
+func dbProduct(id int) (Product, bool) {
+	fakeDBLock.RLock()
+	defer fakeDBLock.RUnlock()
+	p, ok := fakeProductDB[id]
+	if !ok {
+		return Product{}, false
+	}
+	return *p, true
+}
+
+// commandCounter is a Redis hook counting the commands sent, by name, and
+// keeping the arguments of the last one of each
+type commandCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+	keyed  map[string]int // by name and first argument
+	last   map[string][]interface{}
+}
+
+// Count the commands redisClient sends from here on
+func countCommands(t *testing.T) *commandCounter {
+	t.Helper()
+	c := &commandCounter{counts: map[string]int{}, keyed: map[string]int{}, last: map[string][]interface{}{}}
+	redisClient.AddHook(c)
+	return c
+}
+
+func (c *commandCounter) count(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[name]
+}
+
+// How many name commands were sent for key
+func (c *commandCounter) countKey(name, key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.keyed[name+" "+key]
+}
+
+// The arguments of the last name command, including the name
+func (c *commandCounter) lastArgs(name string) []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last[name]
+}
+
+func (c *commandCounter) add(cmds ...redis.Cmder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cmd := range cmds {
+		c.counts[cmd.Name()]++
+		if args := cmd.Args(); len(args) > 1 {
+			c.keyed[cmd.Name()+" "+fmt.Sprint(args[1])]++
+		}
+		c.last[cmd.Name()] = cmd.Args()
+	}
+}
+
+func (c *commandCounter) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	c.add(cmd)
+	return ctx, nil
+}
+
+func (c *commandCounter) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+func (c *commandCounter) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	c.add(cmds...)
+	return ctx, nil
+}
+
+func (c *commandCounter) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestKnownVersion(t *testing.T) {
+	_, h := setupTest(t)
+
+	if w := do(h, "GET", "/product/1?known_version=1", ""); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("matching known_version: got %d %q, want a bare 304", w.Code, w.Body.String())
+	}
+	w := do(h, "GET", "/product/1?known_version=7", "")
+	var p Product
+	decodeBody(t, w, &p)
+	if w.Code != http.StatusOK || p.Name != "Apple" {
+		t.Fatalf("non-matching known_version: got %d %+v", w.Code, p)
+	}
+	if w := do(h, "GET", "/product/1?known_version=x", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid known_version: got %d, want 400", w.Code)
+	}
+
+	config.KnownVersionResponse = knownVersionMinimal
+	var body map[string]interface{}
+	w = do(h, "GET", "/product/1?known_version=1", "")
+	decodeBody(t, w, &body)
+	if w.Code != http.StatusOK || body["modified"] != false || body["version"] != 1.0 || body["name"] != nil {
+		t.Fatalf("matching known_version, minimal: got %d %v", w.Code, body)
+	}
+}
+
+func TestHitsOnUpdate(t *testing.T) {
+	for mode, want := range map[string]string{
+		hitsOnUpdateReset:    "0",
+		hitsOnUpdateOne:      "1",
+		hitsOnUpdatePreserve: "7",
+	} {
+		t.Run(mode, func(t *testing.T) {
+			mr, h := setupTest(t)
+			config.CacheUpdateMode = cacheUpdateWriteThrough
+			config.HitsOnUpdate = mode
+			mr.Set(redisProductHitsKey(1), "7")
+
+			if w := do(h, "PUT", "/product/1", `{"id":1,"name":"Green Apple","price":120}`); w.Code != http.StatusNoContent {
+				t.Fatalf("PUT: got %d", w.Code)
+			}
+			if got, _ := mr.Get(redisProductHitsKey(1)); got != want {
+				t.Fatalf("hits after update: got %q, want %q", got, want)
+			}
+			if mr.TTL(redisProductHitsKey(1)) != mr.TTL(redisProductKey(1)) {
+				t.Fatalf("hits TTL %v differs from the product's %v", mr.TTL(redisProductHitsKey(1)), mr.TTL(redisProductKey(1)))
+			}
+		})
+	}
+}
+
+func TestPopularTTLRefreshThrottled(t *testing.T) {
+	mr, h := setupTest(t)
+	config.TTLRefreshInterval = 5 * time.Second
+
+	do(h, "GET", "/product/1", "")
+	counter := countCommands(t)
+	for i := 0; i < 10; i++ {
+		do(h, "GET", "/product/1", "")
+	}
+	if n := counter.count("expire"); n != 0 {
+		t.Fatalf("%d Expires within the first interval, want none", n)
+	}
+
+	mr.FastForward(config.TTLRefreshInterval)
+	for i := 0; i < 10; i++ {
+		do(h, "GET", "/product/1", "")
+	}
+	// One refresh covers the product key and its hits key
+	if n := counter.count("expire"); n != 2 {
+		t.Fatalf("%d Expires once the interval passed, want one refresh (2)", n)
+	}
+	if ttl := mr.TTL(redisProductKey(1)); ttl != productCache.TTL() {
+		t.Fatalf("TTL after the refresh: got %v, want %v", ttl, productCache.TTL())
+	}
+}
+
+func TestHugeHitCountKeepsFixedTTL(t *testing.T) {
+	mr, h := setupTest(t)
+	do(h, "GET", "/product/1", "")
+	mr.Set(redisProductHitsKey(1), "9223372036854775806") // one short of MaxInt64
+	mr.SetTTL(redisProductKey(1), time.Second)
+
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET: got %d", w.Code)
+	}
+	if hits, _ := mr.Get(redisProductHitsKey(1)); hits != "9223372036854775807" {
+		t.Fatalf("hits: got %s, want %d", hits, int64(math.MaxInt64))
+	}
+	if ttl := mr.TTL(redisProductKey(1)); ttl != productCache.TTL() {
+		t.Fatalf("TTL after a hit at MaxInt64: got %v, want %v", ttl, productCache.TTL())
+	}
+}
+
+func TestHeadCacheSideEffects(t *testing.T) {
+	for _, cacheOnHead := range []bool{false, true} {
+		mr, h := setupTest(t)
+		config.CacheOnHead = cacheOnHead
+
+		for i := 0; i < 3; i++ {
+			if w := do(h, "HEAD", "/product/1", ""); w.Code != http.StatusOK || w.Body.Len() != 0 {
+				t.Fatalf("CACHE_ON_HEAD=%v: HEAD got %d with %d body bytes", cacheOnHead, w.Code, w.Body.Len())
+			}
+		}
+		if cached := mr.Exists(redisProductKey(1)); cached != cacheOnHead {
+			t.Fatalf("CACHE_ON_HEAD=%v: product cached %v", cacheOnHead, cached)
+		}
+		hits, _ := mr.Get(redisProductHitsKey(1))
+		popularity, _ := mr.ZScore(redisPopularityKey, "1")
+		if cacheOnHead && (hits != "3" || popularity != 3) {
+			t.Fatalf("CACHE_ON_HEAD=true: hits %q popularity %v, want 3 and 3 like GET", hits, popularity)
+		}
+		if !cacheOnHead && (hits != "" || popularity != 0) {
+			t.Fatalf("CACHE_ON_HEAD=false: hits %q popularity %v, want none", hits, popularity)
+		}
+		if w := do(h, "HEAD", "/product/99", ""); w.Code != http.StatusNotFound {
+			t.Fatalf("CACHE_ON_HEAD=%v: HEAD of a missing product got %d", cacheOnHead, w.Code)
+		}
+	}
+}
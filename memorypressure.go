@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What MEMORY_PRESSURE_ACTION does while Redis is above the threshold
+const (
+	memoryPressureNone       = "none"        // only report it
+	memoryPressureShortenTTL = "shorten_ttl" // new cache entries get a fraction of their TTL
+	memoryPressurePause      = "pause"       // misses are served without populating the cache
+)
+
+// RedisMemoryStats is Redis memory use as of the last INFO memory poll
+type RedisMemoryStats struct {
+	UsedBytes int64     `json:"used_bytes"`
+	MaxBytes  int64     `json:"max_bytes"`            // 0 when maxmemory is unset
+	UsedRatio float64   `json:"used_ratio,omitempty"` // of maxmemory
+	Pressure  bool      `json:"pressure"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// redisMemoryState holds the last poll's result, read on every cache write
+type redisMemoryState struct {
+	mu   sync.Mutex
+	last *RedisMemoryStats // nil until the first successful poll
+}
+
+var redisMemory = &redisMemoryState{}
+
+func (s *redisMemoryState) snapshot() *RedisMemoryStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil {
+		return nil
+	}
+	stats := *s.last
+	return &stats
+}
+
+func (s *redisMemoryState) underPressure() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last != nil && s.last.Pressure
+}
+
+// Record a poll, logging when pressure starts or ends
+func (s *redisMemoryState) record(stats RedisMemoryStats) {
+	s.mu.Lock()
+	was := s.last != nil && s.last.Pressure
+	s.last = &stats
+	s.mu.Unlock()
+	if stats.Pressure && !was {
+		log.Printf("WARNING: Redis memory at %.0f%% of maxmemory; MEMORY_PRESSURE_ACTION=%s", stats.UsedRatio*100, config.MemoryPressureAction)
+	} else if was && !stats.Pressure {
+		log.Printf("Redis memory back to %.0f%% of maxmemory", stats.UsedRatio*100)
+	}
+}
+
+// Parse the used_memory and maxmemory fields of an INFO memory reply
+func parseRedisMemoryInfo(info string) (RedisMemoryStats, error) {
+	var stats RedisMemoryStats
+	var sawUsed bool
+	for _, line := range strings.Split(info, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		var err error
+		switch name {
+		case "used_memory":
+			stats.UsedBytes, err = strconv.ParseInt(value, 10, 64)
+			sawUsed = true
+		case "maxmemory":
+			stats.MaxBytes, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return stats, fmt.Errorf("INFO memory %s: %w", name, err)
+		}
+	}
+	if !sawUsed {
+		return stats, fmt.Errorf("INFO memory reply has no used_memory")
+	}
+	if stats.MaxBytes > 0 {
+		stats.UsedRatio = float64(stats.UsedBytes) / float64(stats.MaxBytes)
+		stats.Pressure = stats.UsedRatio >= config.MemoryPressureThreshold
+	}
+	return stats, nil
+}
+
+// Background goroutine - poll INFO memory every MEMORY_POLL_INTERVAL, so
+// cache writes can back off before Redis starts evicting
+func runRedisMemoryMonitor(ctx context.Context) {
+	ticker := time.NewTicker(config.MemoryPollInterval)
+	defer ticker.Stop()
+	for {
+		pollRedisMemory(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func pollRedisMemory(ctx context.Context) {
+	info, err := redisClient.Info(ctx, "memory").Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Redis memory poll failed: %v", err)
+		}
+		return
+	}
+	stats, err := parseRedisMemoryInfo(info)
+	if err != nil {
+		log.Printf("Redis memory poll failed: %v", err)
+		return
+	}
+	stats.CheckedAt = clock.Now()
+	redisMemory.record(stats)
+	metrics.SetGauge("redis_memory_used_bytes", float64(stats.UsedBytes), nil)
+	if stats.MaxBytes > 0 {
+		metrics.SetGauge("redis_memory_used_ratio", stats.UsedRatio, nil)
+	}
+}
+
+// Utility - the TTL to write a product cache entry with: the product cache
+// TTL, cut to MEMORY_PRESSURE_TTL_FACTOR of it under shorten_ttl pressure
+func productCacheWriteTTL() time.Duration {
+	ttl := productCache.TTL()
+	if config.MemoryPressureAction == memoryPressureShortenTTL && redisMemory.underPressure() {
+		if short := time.Duration(float64(ttl) * config.MemoryPressureTTLFactor); short >= time.Second {
+			return short
+		}
+		return time.Second
+	}
+	return ttl
+}
+
+// Utility - whether cache misses should skip populating the cache
+func cachePopulatePaused() bool {
+	return config.MemoryPressureAction == memoryPressurePause && redisMemory.underPressure()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// fakeInfoHook answers INFO with a fixed reply
+type fakeInfoHook struct{ reply string }
+
+func (h fakeInfoHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h fakeInfoHook) AfterProcess(_ context.Context, cmd redis.Cmder) error {
+	if info, ok := cmd.(*redis.StringCmd); ok && cmd.Name() == "info" {
+		info.SetVal(h.reply)
+		info.SetErr(nil)
+	}
+	return nil
+}
+
+func (fakeInfoHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (fakeInfoHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+// Poll a Redis reporting 95 of 100 bytes used
+func pollHighMemory(t *testing.T) {
+	t.Helper()
+	redisClient.AddHook(fakeInfoHook{reply: "# Memory\r\nused_memory:95\r\nused_memory_human:95B\r\nmaxmemory:100\r\n"})
+	pollRedisMemory(context.Background())
+	if !redisMemory.underPressure() {
+		t.Fatal("95% of maxmemory not reported as pressure")
+	}
+}
+
+func TestParseRedisMemoryInfo(t *testing.T) {
+	setupTest(t)
+	stats, err := parseRedisMemoryInfo("used_memory:50\r\nmaxmemory:100\r\n")
+	if err != nil || stats.UsedRatio != 0.5 || stats.Pressure {
+		t.Fatalf("half full: got %+v, %v", stats, err)
+	}
+	if stats, _ := parseRedisMemoryInfo("used_memory:50\r\nmaxmemory:0\r\n"); stats.Pressure {
+		t.Fatal("pressure reported without maxmemory")
+	}
+	if _, err := parseRedisMemoryInfo("maxmemory:100\r\n"); err == nil {
+		t.Fatal("reply without used_memory accepted")
+	}
+}
+
+func TestMemoryPressureShortensTTL(t *testing.T) {
+	mr, h := setupTest(t)
+	config.MemoryPressureAction = memoryPressureShortenTTL
+	pollHighMemory(t)
+
+	do(h, "GET", "/product/1", "")
+	want := productCache.TTL() / 4
+	if ttl := mr.TTL(redisProductKey(1)); ttl != want {
+		t.Fatalf("cache TTL under pressure: got %v, want %v", ttl, want)
+	}
+
+	var stats ServiceStats
+	decodeBody(t, do(h, "GET", "/stats", ""), &stats)
+	if stats.RedisMemory == nil || !stats.RedisMemory.Pressure || stats.RedisMemory.UsedBytes != 95 {
+		t.Fatalf("/stats redis_memory: got %+v", stats.RedisMemory)
+	}
+}
+
+func TestMemoryPressurePausesPopulate(t *testing.T) {
+	mr, h := setupTest(t)
+	config.MemoryPressureAction = memoryPressurePause
+	pollHighMemory(t)
+
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET under pressure: got %d", w.Code)
+	}
+	if mr.Exists(redisProductKey(1)) {
+		t.Fatal("cache populated while paused")
+	}
+}
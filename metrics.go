@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cactus/go-statsd-client/v5/statsd"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics backends selectable via METRICS_BACKEND
+const (
+	metricsBackendNone       = "none"
+	metricsBackendPrometheus = "prometheus"
+	metricsBackendStatsD     = "statsd"
+)
+
+// Labels are the dimensions attached to a metric sample
+type Labels map[string]string
+
+// Recorder is the metrics sink the handlers record through. Implementations
+// must be safe for concurrent use.
+type Recorder interface {
+	IncrCounter(name string, labels Labels)
+	ObserveHistogram(name string, value float64, labels Labels)
+	SetGauge(name string, value float64, labels Labels)
+}
+
+var metrics Recorder = noopRecorder{}
+
+// exemplarRecorder is implemented by recorders that can attach an exemplar,
+// e.g. a trace ID, to a histogram observation
+type exemplarRecorder interface {
+	ObserveHistogramWithExemplar(name string, value float64, labels, exemplar Labels)
+}
+
+// Build the recorder selected by the config. The returned handler serves
+// the scrape endpoint and is nil for push-based backends.
+func newRecorder(c Config) (Recorder, http.Handler, error) {
+	switch c.MetricsBackend {
+	case "", metricsBackendNone:
+		return noopRecorder{}, nil, nil
+	case metricsBackendPrometheus:
+		rec := newPrometheusRecorder()
+		// Exemplars are only exposed in the OpenMetrics format, served to
+		// scrapers that ask for it
+		opts := promhttp.HandlerOpts{EnableOpenMetrics: c.MetricsExemplars}
+		return rec, promhttp.HandlerFor(rec.registry, opts), nil
+	case metricsBackendStatsD:
+		client, err := statsd.NewClientWithConfig(&statsd.ClientConfig{
+			Address:     c.StatsDAddr,
+			Prefix:      c.StatsDPrefix,
+			UseBuffered: true,
+			TagFormat:   statsd.SuffixOctothorpe,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &statsdRecorder{client: client}, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown metrics backend %q", c.MetricsBackend)
+	}
+}
+
+// noopRecorder discards everything; the default when no backend is configured
+type noopRecorder struct{}
+
+func (noopRecorder) IncrCounter(string, Labels)               {}
+func (noopRecorder) ObserveHistogram(string, float64, Labels) {}
+func (noopRecorder) SetGauge(string, float64, Labels)         {}
+
+// prometheusRecorder registers a vector per metric name on first use, taking
+// its label names from that first sample. Later samples for the same name
+// must use the same label names.
+type prometheusRecorder struct {
+	registry   *prometheus.Registry
+	started    time.Time
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+	gauges     map[string]*prometheus.GaugeVec
+}
+
+func newPrometheusRecorder() *prometheusRecorder {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return &prometheusRecorder{
+		registry:   registry,
+		started:    time.Now(),
+		counters:   map[string]*prometheus.CounterVec{},
+		histograms: map[string]*prometheus.HistogramVec{},
+		gauges:     map[string]*prometheus.GaugeVec{},
+	}
+}
+
+func (p *prometheusRecorder) IncrCounter(name string, labels Labels) {
+	p.mu.Lock()
+	vec, ok := p.counters[name]
+	if !ok {
+		vec = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: name}, labelNames(labels))
+		p.register(name, vec)
+		p.counters[name] = vec
+	}
+	p.mu.Unlock()
+	if c, err := vec.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		c.Inc()
+	}
+}
+
+func (p *prometheusRecorder) ObserveHistogram(name string, value float64, labels Labels) {
+	if h, err := p.histogram(name, labels); err == nil {
+		h.Observe(value)
+	}
+}
+
+func (p *prometheusRecorder) ObserveHistogramWithExemplar(name string, value float64, labels, exemplar Labels) {
+	h, err := p.histogram(name, labels)
+	if err != nil {
+		return
+	}
+	if eo, ok := h.(prometheus.ExemplarObserver); ok {
+		eo.ObserveWithExemplar(value, prometheus.Labels(exemplar))
+	} else {
+		h.Observe(value)
+	}
+}
+
+func (p *prometheusRecorder) histogram(name string, labels Labels) (prometheus.Observer, error) {
+	p.mu.Lock()
+	vec, ok := p.histograms[name]
+	if !ok {
+		vec = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: name, Buckets: prometheus.DefBuckets}, labelNames(labels))
+		p.register(name, vec)
+		p.histograms[name] = vec
+	}
+	p.mu.Unlock()
+	return vec.GetMetricWith(prometheus.Labels(labels))
+}
+
+func (p *prometheusRecorder) SetGauge(name string, value float64, labels Labels) {
+	p.mu.Lock()
+	vec, ok := p.gauges[name]
+	if !ok {
+		vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: name}, labelNames(labels))
+		p.register(name, vec)
+		p.gauges[name] = vec
+	}
+	p.mu.Unlock()
+	if g, err := vec.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		g.Set(value)
+	}
+}
+
+func (p *prometheusRecorder) register(name string, c prometheus.Collector) {
+	if err := p.registry.Register(c); err != nil {
+		log.Printf("Metrics: could not register %s: %v", name, err)
+	}
+}
+
+func labelNames(labels Labels) []string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// statsdRecorder pushes samples over UDP with DataDog-style tags. StatsD
+// has no generic histogram type, so observations are sent as timers, with
+// "_seconds" metrics converted to milliseconds. Gauges are sent as integers
+// for compatibility with plain StatsD servers.
+type statsdRecorder struct {
+	client statsd.Statter
+}
+
+func (s *statsdRecorder) IncrCounter(name string, labels Labels) {
+	s.client.Inc(name, 1, 1.0, statsdTags(labels)...)
+}
+
+func (s *statsdRecorder) ObserveHistogram(name string, value float64, labels Labels) {
+	if strings.HasSuffix(name, "_seconds") {
+		value *= 1000
+	}
+	s.client.Timing(name, int64(value), 1.0, statsdTags(labels)...)
+}
+
+func (s *statsdRecorder) SetGauge(name string, value float64, labels Labels) {
+	s.client.Gauge(name, int64(value), 1.0, statsdTags(labels)...)
+}
+
+func statsdTags(labels Labels) []statsd.Tag {
+	tags := make([]statsd.Tag, 0, len(labels))
+	for _, k := range labelNames(labels) {
+		tags = append(tags, statsd.Tag{k, labels[k]})
+	}
+	return tags
+}
+
+// statusWriter captures the response status for instrumentation
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Middleware - count requests and time them, labelled by route template
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := "unmatched"
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tmpl, err := cur.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		metrics.IncrCounter("http_requests_total", Labels{"method": r.Method, "route": route, "status": strconv.Itoa(sw.status)})
+		observeRequestDuration(r, time.Since(start).Seconds(), Labels{"method": r.Method, "route": route})
+	})
+}
+
+// Utility - record a request's duration, with METRICS_EXEMPLARS carrying the
+// ID of its sampled trace so a latency spike leads to a concrete trace
+func observeRequestDuration(r *http.Request, seconds float64, labels Labels) {
+	if config.MetricsExemplars {
+		if traceID := traceIDFromContext(r.Context()); traceID != "" {
+			if er, ok := metrics.(exemplarRecorder); ok {
+				er.ObserveHistogramWithExemplar("http_request_duration_seconds", seconds, labels, Labels{"trace_id": traceID})
+				return
+			}
+		}
+	}
+	metrics.ObserveHistogram("http_request_duration_seconds", seconds, labels)
+}
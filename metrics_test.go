@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNoopRecorder(t *testing.T) {
+	rec, handler, err := newRecorder(Config{MetricsBackend: metricsBackendNone})
+	if err != nil || handler != nil {
+		t.Fatalf("none backend: got handler %v, err %v", handler, err)
+	}
+	rec.IncrCounter("requests_total", Labels{"route": "/product"})
+	rec.ObserveHistogram("duration_seconds", 0.5, nil)
+	rec.SetGauge("products_in_db", 3, nil)
+}
+
+func TestStatsDRecorderSendsPackets(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c := defaultConfig()
+	c.MetricsBackend = metricsBackendStatsD
+	c.StatsDAddr = conn.LocalAddr().String()
+	c.StatsDPrefix = "shop"
+	rec, handler, err := newRecorder(c)
+	if err != nil || handler != nil {
+		t.Fatalf("statsd backend: got handler %v, err %v", handler, err)
+	}
+	rec.IncrCounter("requests_total", Labels{"status": "200", "method": "GET"})
+	rec.ObserveHistogram("duration_seconds", 0.25, nil)
+	rec.SetGauge("products_in_db", 3, nil)
+	// Closing flushes the buffer
+	rec.(*statsdRecorder).client.Close()
+
+	var received []string
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(received) < 3 {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("after %q: %v", received, err)
+		}
+		received = append(received, strings.Split(strings.TrimSpace(string(buf[:n])), "\n")...)
+	}
+	want := []string{
+		"shop.requests_total:1|c|#method:GET,status:200",
+		"shop.duration_seconds:250|ms",
+		"shop.products_in_db:3|g",
+	}
+	if strings.Join(received, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("statsd packets: got %q, want %q", received, want)
+	}
+}
+
+func TestPrometheusRecorderServesHandlerMetrics(t *testing.T) {
+	setupTest(t)
+	rec, metricsHandler, err := newRecorder(Config{MetricsBackend: metricsBackendPrometheus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics = rec
+	h := newHandler(rec, metricsHandler)
+
+	do(h, "GET", "/product/1", "")
+	body := do(h, "GET", "/metrics", "").Body.String()
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/product/{id:[0-9]+}",status="200"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/product/{id:[0-9]+}"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("/metrics lacks %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestUnknownMetricsBackend(t *testing.T) {
+	if _, _, err := newRecorder(Config{MetricsBackend: "graphite"}); err == nil {
+		t.Fatal("unknown backend accepted")
+	}
+}
+
+func TestDBFallbackReasons(t *testing.T) {
+	mr, _ := setupTest(t)
+	rec, metricsHandler, err := newRecorder(Config{MetricsBackend: metricsBackendPrometheus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics = rec
+	h := newHandler(rec, metricsHandler)
+
+	do(h, "GET", "/product/1", "")
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	do(h, "GET", "/product/2", "")
+	mr.SetError("")
+
+	body := do(h, "GET", "/metrics", "").Body.String()
+	for _, want := range []string{
+		`product_db_fallback_total{reason="miss"} 1`,
+		`product_db_fallback_total{reason="cache_error"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("/metrics lacks %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsSummary(t *testing.T) {
+	setupTest(t)
+	config.DBLockTimeout = 20 * time.Millisecond
+	rec, metricsHandler, err := newRecorder(Config{MetricsBackend: metricsBackendPrometheus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics = rec
+	h := newHandler(rec, metricsHandler)
+
+	var empty MetricsSummary
+	decodeBody(t, do(h, "GET", "/metrics-summary", ""), &empty)
+	if empty.Requests != 0 || empty.LatencyP50Seconds != nil {
+		t.Fatalf("summary before any request: %+v", empty)
+	}
+
+	// One miss, three hits, and one 503 while the DB is held
+	for i := 0; i < 4; i++ {
+		do(h, "GET", "/product/1", "")
+	}
+	fakeDBLock.Lock()
+	do(h, "GET", "/product/2", "")
+	fakeDBLock.Unlock()
+
+	var s MetricsSummary
+	decodeBody(t, do(h, "GET", "/metrics-summary", ""), &s)
+	// The first summary request counts too
+	if s.Requests != 6 || math.Abs(s.ErrorRatio-1.0/6) > 1e-9 || s.CacheHitRatio != 0.6 {
+		t.Fatalf("summary counts: %+v", s)
+	}
+	if s.UptimeSeconds <= 0 || s.RequestsPerSecond <= 0 {
+		t.Fatalf("summary rates: %+v", s)
+	}
+	if s.LatencyP50Seconds == nil || s.LatencyP95Seconds == nil || *s.LatencyP50Seconds <= 0 || *s.LatencyP95Seconds < *s.LatencyP50Seconds {
+		t.Fatalf("summary latencies: p50 %v, p95 %v", s.LatencyP50Seconds, s.LatencyP95Seconds)
+	}
+}
+
+func TestBucketQuantile(t *testing.T) {
+	buckets := map[float64]uint64{0.1: 50, 0.5: 90, 1: 100}
+	for q, want := range map[float64]float64{0.25: 0.05, 0.5: 0.1, 0.7: 0.3, 0.95: 0.75} {
+		if got := bucketQuantile(q, buckets, 100); got == nil || math.Abs(*got-want) > 1e-9 {
+			t.Errorf("q=%v: got %v, want %v", q, got, want)
+		}
+	}
+	// Past the last finite bound reports that bound
+	if got := bucketQuantile(0.99, map[float64]uint64{0.1: 50}, 100); got == nil || *got != 0.1 {
+		t.Errorf("beyond the last bound: got %v, want 0.1", got)
+	}
+	if got := bucketQuantile(0.5, nil, 0); got != nil {
+		t.Errorf("without observations: got %v", *got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// MetricsSummary is a compact, human-readable view of the key metrics
+type MetricsSummary struct {
+	UptimeSeconds     float64  `json:"uptime_seconds"`
+	Requests          uint64   `json:"requests"`
+	RequestsPerSecond float64  `json:"requests_per_second"` // averaged over uptime
+	ErrorRatio        float64  `json:"error_ratio"`         // share of responses with a 5xx status
+	CacheHitRatio     float64  `json:"cache_hit_ratio"`
+	LatencyP50Seconds *float64 `json:"latency_p50_seconds"` // null until a request is observed
+	LatencyP95Seconds *float64 `json:"latency_p95_seconds"`
+}
+
+// Handler - GET /metrics-summary
+// Served alongside /metrics with the Prometheus backend. Percentiles are
+// estimated from the request duration histogram's buckets, as
+// histogram_quantile would.
+func (p *prometheusRecorder) summaryHandler(w http.ResponseWriter, r *http.Request) {
+	families, err := p.registry.Gather()
+	if err != nil {
+		log.Printf("Metrics summary gather error: %v", err)
+	}
+	summary := MetricsSummary{UptimeSeconds: time.Since(p.started).Seconds()}
+
+	var failures, observations uint64
+	buckets := map[float64]uint64{}
+	for _, mf := range families {
+		switch mf.GetName() {
+		case "http_requests_total":
+			for _, m := range mf.GetMetric() {
+				n := uint64(m.GetCounter().GetValue())
+				summary.Requests += n
+				if strings.HasPrefix(labelValue(m, "status"), "5") {
+					failures += n
+				}
+			}
+		case "http_request_duration_seconds":
+			for _, m := range mf.GetMetric() {
+				observations += m.GetHistogram().GetSampleCount()
+				for _, b := range m.GetHistogram().GetBucket() {
+					buckets[b.GetUpperBound()] += b.GetCumulativeCount()
+				}
+			}
+		}
+	}
+	if summary.UptimeSeconds > 0 {
+		summary.RequestsPerSecond = float64(summary.Requests) / summary.UptimeSeconds
+	}
+	if summary.Requests > 0 {
+		summary.ErrorRatio = float64(failures) / float64(summary.Requests)
+	}
+	hits, misses := atomic.LoadInt64(&statCacheHits), atomic.LoadInt64(&statCacheMisses)
+	if hits+misses > 0 {
+		summary.CacheHitRatio = float64(hits) / float64(hits+misses)
+	}
+	summary.LatencyP50Seconds = bucketQuantile(0.5, buckets, observations)
+	summary.LatencyP95Seconds = bucketQuantile(0.95, buckets, observations)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// Utility - estimate a quantile from cumulative histogram buckets keyed by
+// upper bound and the total observation count, interpolating linearly
+// within the bucket it falls in. Returns nil without observations; a
+// quantile beyond the last finite bound reports that bound.
+func bucketQuantile(q float64, buckets map[float64]uint64, total uint64) *float64 {
+	if total == 0 {
+		return nil
+	}
+	bounds := make([]float64, 0, len(buckets)+1)
+	for b := range buckets {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+	// Exported histograms leave the +Inf bucket implicit in the total
+	bounds = append(bounds, math.Inf(1))
+	rank := q * float64(total)
+	lower, below := 0.0, uint64(0)
+	for _, upper := range bounds {
+		count, ok := buckets[upper]
+		if !ok {
+			count = total
+		}
+		if float64(count) >= rank {
+			if math.IsInf(upper, 1) {
+				return &lower
+			}
+			v := lower + (upper-lower)*(rank-float64(below))/float64(count-below)
+			return &v
+		}
+		lower, below = upper, count
+	}
+	return &lower
+}
+
+// Utility - a metric's value for a label, or ""
+func labelValue(m *dto.Metric, name string) string {
+	for _, lp := range m.GetLabel() {
+		if lp.GetName() == name {
+			return lp.GetValue()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	corsAllowedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowedHeaders = "Authorization, Content-Type, Content-Encoding, Idempotency-Key, " +
+		"If-Match, If-None-Match, X-Debug, traceparent"
+	corsExposedHeaders = "ETag, Location, X-Total-Count, Deprecation, Sunset, " +
+		"X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After, X-Trace-Id"
+)
+
+// Middleware - reject overly long request URIs and query strings with 414
+func requestLimitsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (config.MaxURLLength > 0 && len(r.RequestURI) > config.MaxURLLength) ||
+			(config.MaxQueryLength > 0 && len(r.URL.RawQuery) > config.MaxQueryLength) {
+			http.Error(w, "URI too long", http.StatusRequestURITooLong)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Per-client-IP request budget; nil when RATE_LIMIT is 0
+var requestRateLimiter *keyedTokenBuckets
+
+// Middleware - apply the per-client request budget, reporting it on every
+// response via X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset (seconds until the budget is full again). Over budget
+// the request gets 429 with Retry-After. Health probes are exempt.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestRateLimiter == nil || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, q := requestRateLimiter.take(clientIP(r))
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(q.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(q.Remaining))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(q.Reset)))
+		if !ok {
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(q.RetryAfter)))
+			metrics.IncrCounter("http_requests_rejected_total", Labels{"reason": "rate_limited"})
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Utility - a duration in whole seconds, rounded up
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// Middleware - with REQUIRE_USER_AGENT, reject requests that send no
+// User-Agent with 400; many abusive clients omit it
+func userAgentMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.RequireUserAgent && strings.TrimSpace(r.UserAgent()) == "" {
+			log.Printf("Rejected %s %s from %s: no User-Agent", r.Method, r.URL.Path, clientIP(r))
+			metrics.IncrCounter("http_requests_rejected_total", Labels{"reason": "no_user_agent"})
+			http.Error(w, "User-Agent header required", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware - in READ_ONLY mode, permanently refuse mutating requests with
+// 405, for running a read-only replica of the API. /admin is exempt: its
+// actions manage this instance's cache rather than product data.
+func readOnlyModeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// POST /products/batch only reads, its body just carries the IDs
+		readOnlyPost := r.Method == http.MethodPost && r.URL.Path == "/products/batch"
+		if config.ReadOnly && !safeMethod(r.Method) && !readOnlyPost && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+			http.Error(w, "Read-only instance", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Utility - whether an HTTP method only reads
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Middleware - CORS for the configured origins. Preflight requests are
+// answered here (mux would otherwise 405 them) and carry Access-Control-Max-Age
+// so browsers cache them; regular responses only get the allow-origin header.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !corsOriginAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			if config.CORSMaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORSMaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func corsOriginAllowed(origin string) bool {
+	for _, allowed := range config.CORSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
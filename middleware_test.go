@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverlongQueryStringGets414(t *testing.T) {
+	_, h := setupTest(t)
+	config.MaxQueryLength = 64
+
+	ids := strings.Repeat("1,", 40) + "1"
+	if w := do(h, "GET", "/products/batch?ids="+ids, ""); w.Code != http.StatusRequestURITooLong {
+		t.Fatalf("over-long query: got %d, want 414", w.Code)
+	}
+	if w := do(h, "GET", "/products/batch?ids=1,2", ""); w.Code != http.StatusOK {
+		t.Fatalf("short query: got %d, want 200", w.Code)
+	}
+}
+
+func TestOverlongURLGets414(t *testing.T) {
+	_, h := setupTest(t)
+	config.MaxURLLength = 32
+
+	if w := do(h, "GET", "/product/1?pad="+strings.Repeat("x", 32), ""); w.Code != http.StatusRequestURITooLong {
+		t.Fatalf("over-long URL: got %d, want 414", w.Code)
+	}
+}
+
+func TestCORSMaxAgeOnPreflightOnly(t *testing.T) {
+	_, h := setupTest(t)
+	config.CORSAllowedOrigins = []string{"https://shop.example"}
+	config.CORSMaxAge = 10 * time.Minute
+
+	w := do(h, "OPTIONS", "/product/1", "", "Origin", "https://shop.example", "Access-Control-Request-Method", "PUT")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight: got %d, want 204", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Fatalf("preflight Access-Control-Max-Age: got %q, want 600", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://shop.example" {
+		t.Fatalf("preflight Access-Control-Allow-Origin: got %q", got)
+	}
+	allowed := strings.Split(w.Header().Get("Access-Control-Allow-Headers"), ", ")
+	for _, header := range []string{"Idempotency-Key", "If-Match", "X-Debug", "Content-Encoding"} {
+		if !containsString(allowed, header) {
+			t.Fatalf("preflight Access-Control-Allow-Headers %q lacks %s", allowed, header)
+		}
+	}
+
+	w = do(h, "GET", "/product/1", "", "Origin", "https://shop.example")
+	if got := w.Header().Get("Access-Control-Max-Age"); got != "" {
+		t.Fatalf("regular response carries Access-Control-Max-Age %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://shop.example" {
+		t.Fatalf("regular response Access-Control-Allow-Origin: got %q", got)
+	}
+
+	w = do(h, "OPTIONS", "/product/1", "", "Origin", "https://evil.example", "Access-Control-Request-Method", "PUT")
+	if w.Header().Get("Access-Control-Max-Age") != "" || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("preflight from a disallowed origin got CORS headers: %v", w.Header())
+	}
+}
+
+func TestRequireUserAgent(t *testing.T) {
+	_, h := setupTest(t)
+
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("no User-Agent with the check off: got %d", w.Code)
+	}
+	config.RequireUserAgent = true
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("no User-Agent: got %d, want 400", w.Code)
+	}
+	if w := do(h, "GET", "/product/1", "", "User-Agent", "   "); w.Code != http.StatusBadRequest {
+		t.Fatalf("blank User-Agent: got %d, want 400", w.Code)
+	}
+	if w := do(h, "GET", "/product/1", "", "User-Agent", "shop-client/1.0"); w.Code != http.StatusOK {
+		t.Fatalf("with a User-Agent: got %d, want 200", w.Code)
+	}
+}
+
+func TestReadOnlyModeRejectsMutations(t *testing.T) {
+	_, h := setupTest(t)
+	config.ReadOnly = true
+	config.AdminToken = "secret"
+
+	for _, req := range []struct{ method, path, body string }{
+		{"PUT", "/product/1", `{"id":1,"name":"Apricot","price":90}`},
+		{"POST", "/product", `{"name":"Date","price":5}`},
+		{"DELETE", "/product/1", ""},
+	} {
+		w := do(h, req.method, req.path, req.body)
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+			t.Errorf("%s %s: got %d, Allow %q", req.method, req.path, w.Code, w.Header().Get("Allow"))
+		}
+	}
+	if p, _ := dbProduct(1); p.Name != "Apple" {
+		t.Fatalf("product 1 changed on a read-only instance: %+v", p)
+	}
+	if _, ok := dbProduct(4); ok {
+		t.Fatal("product created on a read-only instance")
+	}
+
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET: got %d", w.Code)
+	}
+	if w := do(h, "POST", "/products/batch", `{"ids":[1,2]}`); w.Code != http.StatusOK {
+		t.Fatalf("POST /products/batch: got %d", w.Code)
+	}
+	if w := do(h, "POST", "/admin/cache/extend", `{"ids":[1]}`, "Authorization", "Bearer secret"); w.Code == http.StatusMethodNotAllowed {
+		t.Fatal("admin endpoint refused on a read-only instance")
+	}
+}
+
+func TestRateLimitQuotaHeaders(t *testing.T) {
+	_, h := setupTest(t)
+	requestRateLimiter = newKeyedTokenBuckets(1, 3)
+
+	for want := 2; want >= 0; want-- {
+		w := do(h, "GET", "/product/1", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request within budget: got %d", w.Code)
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "3" || w.Header().Get("X-RateLimit-Remaining") != strconv.Itoa(want) {
+			t.Fatalf("limit %q, remaining %q, want 3, %d", w.Header().Get("X-RateLimit-Limit"), w.Header().Get("X-RateLimit-Remaining"), want)
+		}
+	}
+
+	w := do(h, "GET", "/product/1", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over budget: got %d", w.Code)
+	}
+	if w.Header().Get("X-RateLimit-Remaining") != "0" || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("429: remaining %q, Retry-After %q", w.Header().Get("X-RateLimit-Remaining"), w.Header().Get("Retry-After"))
+	}
+	// Refilling 3 tokens at 1/s takes 3s
+	if reset := w.Header().Get("X-RateLimit-Reset"); reset != "3" {
+		t.Fatalf("429: X-RateLimit-Reset %q, want 3", reset)
+	}
+
+	if w := do(h, "GET", "/healthz", ""); w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Fatal("health probe got rate limit headers")
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "strings"
+
+// Handling of leading/trailing whitespace in product names
+const (
+	nameWhitespaceTrim   = "trim"   // store "  Apple  " as "Apple"
+	nameWhitespaceReject = "reject" // refuse padded names
+	nameWhitespaceKeep   = "keep"   // store names as sent
+)
+
+// Utility - apply NAME_WHITESPACE to a product name. Anything comparing
+// names should use the result, so "Apple" and " Apple" can't diverge.
+func normalizeProductName(name string) (string, error) {
+	trimmed := strings.TrimSpace(name)
+	switch config.NameWhitespace {
+	case nameWhitespaceKeep:
+		return name, nil
+	case nameWhitespaceReject:
+		if trimmed != name {
+			return "", &ValidationError{Field: "name", Reason: "leading or trailing whitespace"}
+		}
+		return name, nil
+	default:
+		return trimmed, nil
+	}
+}
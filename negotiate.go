@@ -0,0 +1,111 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+	// JSON with _links, as in HAL
+	contentTypeHAL = "application/hal+json"
+	// Admin-only debug representation: the cache entry's bytes as stored
+	contentTypeCacheRaw = "application/vnd.cache-raw+json"
+)
+
+// Representations offered for a single product, in server preference order
+var productContentTypes = []string{contentTypeJSON, contentTypeProtobuf, contentTypeHAL}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// Utility - parse an Accept header into media ranges ordered by preference
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				q = f
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	// Higher q first; among equal q, more specific ranges first
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return strings.Count(ranges[i].mediaType, "*") < strings.Count(ranges[j].mediaType, "*")
+	})
+	return ranges
+}
+
+// Utility - pick the offered content type the client prefers. An absent
+// Accept header gets the first offer; returns "" when nothing is acceptable.
+func negotiateContentType(header string, offers []string) string {
+	if strings.TrimSpace(header) == "" {
+		return offers[0]
+	}
+	for _, ar := range parseAccept(header) {
+		if ar.q <= 0 {
+			continue
+		}
+		for _, offer := range offers {
+			if mediaRangeMatches(ar.mediaType, offer) {
+				return offer
+			}
+		}
+	}
+	return ""
+}
+
+func mediaRangeMatches(mediaRange, offer string) bool {
+	if mediaRange == "*/*" || mediaRange == offer {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*"))
+	}
+	return false
+}
+
+// Utility - whether the client explicitly asked for mediaType. Wildcards
+// don't count, so */* never selects a debug-only representation.
+func acceptsExactly(header, mediaType string) bool {
+	for _, ar := range parseAccept(header) {
+		if ar.q > 0 && ar.mediaType == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// Utility - with STRICT_ACCEPT, answer 406 when the client's Accept header
+// rules out every offered type, listing the offers in an Accept response
+// header and the body so the client can renegotiate. Without it such clients
+// get the default representation. Returns false when the 406 was written.
+func checkAcceptable(w http.ResponseWriter, r *http.Request, offers []string) bool {
+	if !config.StrictAccept || negotiateContentType(r.Header.Get("Accept"), offers) != "" {
+		return true
+	}
+	supported := strings.Join(offers, ", ")
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Accept", supported)
+	http.Error(w, "Not acceptable; supported content types: "+supported, http.StatusNotAcceptable)
+	return false
+}
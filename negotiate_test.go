@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNotAcceptableAdvertisesSupportedTypes(t *testing.T) {
+	_, h := setupTest(t)
+	supported := "application/json, application/x-protobuf, application/hal+json"
+
+	// Without STRICT_ACCEPT the default representation is served
+	w := do(h, "GET", "/product/1", "", "Accept", "text/csv")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != contentTypeJSON {
+		t.Fatalf("lenient: got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	config.StrictAccept = true
+	w = do(h, "GET", "/product/1", "", "Accept", "text/csv, application/xml;q=0.5")
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("strict: got %d, want 406", w.Code)
+	}
+	if w.Header().Get("Accept") != supported || !strings.Contains(w.Body.String(), supported) {
+		t.Fatalf("406: Accept %q, body %q", w.Header().Get("Accept"), w.Body)
+	}
+
+	for _, accept := range []string{"", "*/*", "application/*", "text/csv, application/x-protobuf;q=0.1"} {
+		if w := do(h, "GET", "/product/1", "", "Accept", accept); w.Code != http.StatusOK {
+			t.Errorf("strict, Accept %q: got %d", accept, w.Code)
+		}
+	}
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                       contentTypeJSON,
+		"application/x-protobuf": contentTypeProtobuf,
+		"application/json;q=0.5, application/hal+json": contentTypeHAL,
+		"application/*;q=0.9, application/x-protobuf":  contentTypeProtobuf,
+		"application/json;q=0":                         "",
+		"text/html":                                    "",
+	} {
+		if got := negotiateContentType(header, productContentTypes); got != want {
+			t.Errorf("%q: got %q, want %q", header, got, want)
+		}
+	}
+}
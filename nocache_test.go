@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNoCacheProductAlwaysReadsDB(t *testing.T) {
+	for _, mode := range []string{cacheUpdateInvalidate, cacheUpdateWriteThrough} {
+		mr, h := setupTest(t)
+		config.CacheUpdateMode = mode
+		config.DebugToken = "debug"
+		do(h, "GET", "/product/1", "") // cached before the flag is set
+
+		if w := do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":130,"no_cache":true}`); w.Code != http.StatusNoContent {
+			t.Fatalf("%s: PUT: got %d", mode, w.Code)
+		}
+		// Past the write's tombstone too
+		mr.FastForward(config.TombstoneTTL)
+		for i := 0; i < 3; i++ {
+			w := do(h, "GET", "/product/1", "", "X-Debug", "debug")
+			if w.Code != http.StatusOK || w.Header().Get("X-Data-Source") != "db" {
+				t.Fatalf("%s: read %d: got %d from %q", mode, i, w.Code, w.Header().Get("X-Data-Source"))
+			}
+		}
+		if mr.Exists(redisProductKey(1)) {
+			t.Fatalf("%s: no_cache product left a cache entry", mode)
+		}
+
+		// Other products cache normally
+		do(h, "GET", "/product/2", "")
+		if !mr.Exists(redisProductKey(2)) {
+			t.Fatalf("%s: product 2 wasn't cached", mode)
+		}
+	}
+}
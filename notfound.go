@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const notFoundSuggestions = 3 // nearby IDs offered in a custom not-found body
+
+// productNotFoundHook, when set, renders 404s for unknown products instead
+// of the default or NOT_FOUND_BODY response. It should write a complete
+// response.
+var productNotFoundHook func(w http.ResponseWriter, r *http.Request, id int)
+
+// Utility - write the 404 for an unknown product. NOT_FOUND_BODY is sent as
+// JSON with "{id}" replaced by the requested ID and "{suggestions}" by an
+// array of the nearest existing IDs.
+func writeProductNotFound(w http.ResponseWriter, r *http.Request, id int) {
+	if productNotFoundHook != nil {
+		productNotFoundHook(w, r, id)
+		return
+	}
+	if config.NotFoundBody == "" {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	body := strings.ReplaceAll(config.NotFoundBody, "{id}", strconv.Itoa(id))
+	if strings.Contains(body, "{suggestions}") {
+		suggestions, _ := json.Marshal(nearbyProductIDs(r, id, notFoundSuggestions))
+		body = strings.ReplaceAll(body, "{suggestions}", string(suggestions))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(body))
+}
+
+// Utility - the n existing IDs closest to id
+func nearbyProductIDs(r *http.Request, id, n int) []int {
+	ids := []int{}
+	if err := rlockDB(r.Context()); err != nil {
+		return ids
+	}
+	for existing := range fakeProductDB {
+		ids = append(ids, existing)
+	}
+	fakeDBLock.RUnlock()
+
+	sort.Slice(ids, func(i, j int) bool {
+		di, dj := absInt(ids[i]-id), absInt(ids[j]-id)
+		if di != dj {
+			return di < dj
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	return ids
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestConfiguredNotFoundBody(t *testing.T) {
+	_, h := setupTest(t)
+	config.NotFoundBody = `{"error":"no product {id}","support":"https://help.example/products","try":{suggestions}}`
+
+	w := do(h, "GET", "/product/5", "")
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("404: got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Error   string `json:"error"`
+		Support string `json:"support"`
+		Try     []int  `json:"try"`
+	}
+	decodeBody(t, w, &body)
+	if body.Error != "no product 5" || body.Support != "https://help.example/products" || !reflect.DeepEqual(body.Try, []int{3, 2, 1}) {
+		t.Fatalf("404 body: got %+v", body)
+	}
+}
+
+func TestNotFoundHookOverridesBody(t *testing.T) {
+	_, h := setupTest(t)
+	config.NotFoundBody = `{"error":"no product {id}"}`
+	productNotFoundHook = func(w http.ResponseWriter, r *http.Request, id int) {
+		http.Error(w, "gone fishing", http.StatusNotFound)
+	}
+	t.Cleanup(func() { productNotFoundHook = nil })
+
+	if w := do(h, "GET", "/product/5", ""); w.Code != http.StatusNotFound || w.Body.String() != "gone fishing\n" {
+		t.Fatalf("404 with a hook: got %d %q", w.Code, w.Body.String())
+	}
+}
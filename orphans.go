@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Auxiliary key types the cleaner can check for orphans, i.e. data about
+// products that no longer exist
+const (
+	orphanHits       = "hits"        // product:{id}:hits counters
+	orphanPopularity = "popularity"  // members of products:popularity
+	orphanLastAccess = "last_access" // members of products:last_access
+)
+
+// Utility - whether the cleaner should reap orphans of the given type
+func orphanCleanupEnabled(kind string) bool {
+	for _, k := range config.CleanerOrphanKeys {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Utility - whether a product currently exists in the DB
+func productExists(ctx context.Context, id int) (bool, error) {
+	if err := rlockDB(ctx); err != nil {
+		return false, err
+	}
+	defer fakeDBLock.RUnlock()
+	_, ok := fakeProductDB[id]
+	return ok, nil
+}
+
+// Companion to cleanStaleProductKeys: remove auxiliary keys and sorted-set
+// members whose product has been deleted, for the types in
+// CLEANER_ORPHAN_KEYS. Stops at the first failed scan.
+func cleanOrphanKeys(ctx context.Context) error {
+	if orphanCleanupEnabled(orphanHits) {
+		if err := cleanOrphanHitsKeys(ctx); err != nil {
+			return err
+		}
+	}
+	if orphanCleanupEnabled(orphanPopularity) {
+		if err := cleanOrphanMembers(ctx, redisPopularityKey); err != nil {
+			return err
+		}
+	}
+	if orphanCleanupEnabled(orphanLastAccess) {
+		return cleanOrphanMembers(ctx, redisLastAccessKey)
+	}
+	return nil
+}
+
+func cleanOrphanHitsKeys(ctx context.Context) error {
+	var cursor uint64
+	for {
+		if err := cleanerOps(ctx, 1); err != nil {
+			return err
+		}
+		keys, next, err := redisClient.Scan(ctx, cursor, redisProductKeyPrefix+"*:hits", 100).Result()
+		if err != nil {
+			logScanAbort("Orphan cleaner", cursor, err)
+			return err
+		}
+		for _, key := range keys {
+			idStr := strings.TrimSuffix(strings.TrimPrefix(key, redisProductKeyPrefix), ":hits")
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				continue
+			}
+			if exists, err := productExists(ctx, id); err == nil && !exists {
+				if err := cleanerOps(ctx, 1); err != nil {
+					return err
+				}
+				redisClient.Del(ctx, key)
+				metrics.IncrCounter("cleaner_orphans_removed_total", Labels{"type": orphanHits})
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Drop members of a product-ID sorted set whose product no longer exists
+func cleanOrphanMembers(ctx context.Context, key string) error {
+	var cursor uint64
+	for {
+		if err := cleanerOps(ctx, 1); err != nil {
+			return err
+		}
+		members, next, err := redisClient.ZScan(ctx, key, cursor, "", 100).Result()
+		if err != nil {
+			if cleanerFailover.allowLog(time.Now()) {
+				log.Printf("Orphan cleaner zscan %s error: %v", key, err)
+			}
+			return err
+		}
+		var orphans []interface{}
+		// ZSCAN returns member, score pairs
+		for i := 0; i < len(members); i += 2 {
+			id, err := strconv.Atoi(members[i])
+			if err != nil {
+				orphans = append(orphans, members[i])
+				continue
+			}
+			if exists, err := productExists(ctx, id); err == nil && !exists {
+				orphans = append(orphans, members[i])
+			}
+		}
+		if len(orphans) > 0 {
+			if err := cleanerOps(ctx, 1); err != nil {
+				return err
+			}
+			redisClient.ZRem(ctx, key, orphans...)
+			metrics.IncrCounter("cleaner_orphans_removed_total", Labels{"type": key})
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
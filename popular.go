@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// Sorted set of product IDs scored by hit count
+	redisPopularityKey = "products:popularity"
+
+	popularDefaultLimit = 10
+	popularMaxLimit     = 100
+	popularCacheTTL     = 5 * time.Second // how long a computed ranking is reused
+)
+
+// PopularProduct is a product together with its recorded hit count
+type PopularProduct struct {
+	Product
+	Hits int64 `json:"hits"`
+}
+
+type popularCacheEntry struct {
+	products  []PopularProduct
+	expiresAt time.Time
+}
+
+// popularCache keeps recently computed rankings in-process, keyed by limit.
+// Concurrent misses for the same limit are coalesced so dashboard pollers
+// share a single ZRevRange + lookup pass.
+type popularCache struct {
+	mu      sync.Mutex
+	entries map[int]popularCacheEntry
+	group   singleflight.Group
+}
+
+var popular = &popularCache{entries: map[int]popularCacheEntry{}}
+
+func (c *popularCache) get(ctx context.Context, limit int) ([]PopularProduct, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[limit]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.products, nil
+	}
+
+	v, err, _ := awaitShared(ctx, c.group.DoChan(strconv.Itoa(limit), func() (interface{}, error) {
+		// Detach from the caller's context: the result is shared with other
+		// waiters, so one client disconnecting shouldn't fail them all.
+		products, err := computePopularProducts(context.Background(), limit)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.entries[limit] = popularCacheEntry{products: products, expiresAt: time.Now().Add(popularCacheTTL)}
+		c.mu.Unlock()
+		return products, nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return v.([]PopularProduct), nil
+}
+
+// Discard cached rankings that include any of the given products
+func (c *popularCache) drop(ids []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for limit, entry := range c.entries {
+		for _, p := range entry.products {
+			if containsInt(ids, p.ID) {
+				delete(c.entries, limit)
+				break
+			}
+		}
+	}
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the top-N ranking from Redis, skipping IDs no longer in the DB
+func computePopularProducts(ctx context.Context, limit int) ([]PopularProduct, error) {
+	ranked, err := redisClient.ZRevRangeWithScores(ctx, redisPopularityKey, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	products := make([]PopularProduct, 0, len(ranked))
+	if err := rlockDB(ctx); err != nil {
+		return nil, err
+	}
+	defer fakeDBLock.RUnlock()
+	for _, z := range ranked {
+		member, _ := z.Member.(string)
+		id, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+		dbProduct, ok := fakeProductDB[id]
+		if !ok {
+			continue
+		}
+		products = append(products, PopularProduct{Product: *dbProduct, Hits: int64(z.Score)})
+	}
+	return products, nil
+}
+
+// Utility - reorder products by their score in the popularity sorted set,
+// most popular first. Products never hit score 0; ties keep ID order.
+func sortByPopularity(ctx context.Context, products []Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	cmds := make([]*redis.FloatCmd, len(products))
+	_, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, p := range products {
+			cmds[i] = pipe.ZScore(ctx, redisPopularityKey, strconv.Itoa(p.ID))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	scores := make(map[int]float64, len(products))
+	for i, p := range products {
+		scores[p.ID] = cmds[i].Val() // 0 for redis.Nil
+	}
+	sort.SliceStable(products, func(i, j int) bool {
+		if scores[products[i].ID] != scores[products[j].ID] {
+			return scores[products[i].ID] > scores[products[j].ID]
+		}
+		return products[i].ID < products[j].ID
+	})
+	return nil
+}
+
+// Handler - GET /products/popular
+func popularProductsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := popularDefaultLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if n > popularMaxLimit {
+			n = popularMaxLimit
+		}
+		limit = n
+	}
+
+	products, err := popular.get(r.Context(), limit)
+	if errors.Is(err, errDBLockTimeout) {
+		writeDBLockError(w)
+		return
+	}
+	if writeTimeoutError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Popular products error: %v", err)
+		http.Error(w, "Could not compute popular products", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if publicProjection(w, r) {
+		projected := make([]map[string]interface{}, len(products))
+		for i, p := range products {
+			projected[i] = projectProduct(p.Product)
+			projected[i]["hits"] = p.Hits
+		}
+		json.NewEncoder(w).Encode(projected)
+		return
+	}
+	json.NewEncoder(w).Encode(products)
+}
+
+// Utility - with POPULARITY_EVENTS, announce a product the first time its
+// hits reach popularThreshold. A marker key, extended on every popular hit,
+// spans the episode: the event fires again only once the product has gone a
+// full TTL (HITS_WINDOW in sliding mode) without a popular hit, or its
+// counters were reset by a write.
+func notePopularHit(ctx context.Context, product Product) {
+	if !config.PopularityEvents {
+		return
+	}
+	ttl := productCache.TTL()
+	if config.HitsMode == hitsModeSliding {
+		ttl = config.HitsWindow
+	}
+	markerKey := redisProductPopularMarkerKey(product.ID)
+	var started *redis.BoolCmd
+	redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		started = pipe.SetNX(ctx, markerKey, 1, ttl)
+		pipe.Expire(ctx, markerKey, ttl)
+		return nil
+	})
+	if started.Val() {
+		metrics.IncrCounter("product_popular_events_total", nil)
+		productEvents.publish(productEvent{Type: "popular", ID: product.ID, Product: &product})
+	}
+}
+
+// Utility - set the popular_products gauge to the number of cached products
+// whose hits currently reach popularThreshold (within HITS_WINDOW in sliding
+// mode). Run with each cleaner pass, since it scans the hit counters.
+func updatePopularProductsGauge(ctx context.Context) error {
+	suffix := ":hits"
+	if config.HitsMode == hitsModeSliding {
+		suffix = ":recent"
+	}
+	cutoff := strconv.FormatInt(sharedNow(ctx).Add(-config.HitsWindow).UnixNano(), 10)
+	var cursor uint64
+	count := 0
+	for {
+		if err := cleanerOps(ctx, 1); err != nil {
+			return err
+		}
+		keys, next, err := redisClient.Scan(ctx, cursor, redisProductKeyPrefix+"*"+suffix, 100).Result()
+		if err != nil {
+			logScanAbort("Popular products count", cursor, err)
+			return err
+		}
+		if err := cleanerOps(ctx, len(keys)); err != nil {
+			return err
+		}
+		results := make([]func() (int64, error), len(keys))
+		_, err = redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				if config.HitsMode == hitsModeSliding {
+					results[i] = pipe.ZCount(ctx, key, cutoff, "+inf").Result
+				} else {
+					results[i] = pipe.Get(ctx, key).Int64
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		for _, result := range results {
+			if hits, err := result(); err == nil && hits >= popularThreshold {
+				count++
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	metrics.SetGauge("popular_products", float64(count), nil)
+	return nil
+}
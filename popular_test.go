@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestPopularProductsComputedOnceWithinTTL(t *testing.T) {
+	_, h := setupTest(t)
+	ctx := context.Background()
+	redisClient.ZAdd(ctx, redisPopularityKey, &redis.Z{Score: 10, Member: "2"}, &redis.Z{Score: 5, Member: "1"})
+	counter := countCommands(t)
+
+	// Hold the DB so the first computation is still running while the
+	// other pollers arrive
+	fakeDBLock.Lock()
+	var wg sync.WaitGroup
+	codes := make([]int, 20)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = do(h, "GET", "/products/popular", "").Code
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	fakeDBLock.Unlock()
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, code)
+		}
+	}
+	if n := counter.count("zrevrange"); n != 1 {
+		t.Fatalf("ranking computed %d times for concurrent pollers, want 1", n)
+	}
+
+	// Within the TTL a changed ranking isn't seen yet
+	redisClient.ZAdd(ctx, redisPopularityKey, &redis.Z{Score: 20, Member: "3"})
+	var products []PopularProduct
+	decodeBody(t, do(h, "GET", "/products/popular", ""), &products)
+	if n := counter.count("zrevrange"); n != 1 {
+		t.Fatalf("ranking recomputed within the TTL (%d computations)", n)
+	}
+	if len(products) != 2 || products[0].ID != 2 || products[0].Hits != 10 || products[1].ID != 1 {
+		t.Fatalf("cached ranking: got %+v", products)
+	}
+}
+
+func TestPopularProductsInvalidatedWithTheirProducts(t *testing.T) {
+	_, h := setupTest(t)
+	redisClient.ZAdd(context.Background(), redisPopularityKey, &redis.Z{Score: 10, Member: "2"}, &redis.Z{Score: 5, Member: "1"})
+
+	do(h, "GET", "/products/popular", "")
+	if w := do(h, "DELETE", "/product/2", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d", w.Code)
+	}
+	// As the invalidation subscriber would on receiving the delete
+	dropLocalProductState([]int{2})
+	var products []PopularProduct
+	decodeBody(t, do(h, "GET", "/products/popular", ""), &products)
+	if len(products) != 1 || products[0].ID != 1 {
+		t.Fatalf("ranking after deleting product 2: got %+v", products)
+	}
+}
+
+// countingRecorder keeps counter totals, by name and labels as in
+// cache_canary_checks_total{result="match"}, and the last value of each gauge
+type countingRecorder struct {
+	noopRecorder
+	mu       sync.Mutex
+	counters map[string]int
+	gauges   map[string]float64
+}
+
+func newCountingRecorder() *countingRecorder {
+	return &countingRecorder{counters: map[string]int{}, gauges: map[string]float64{}}
+}
+
+func (c *countingRecorder) IncrCounter(name string, labels Labels) {
+	var pairs []string
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, v))
+	}
+	if len(pairs) > 0 {
+		sort.Strings(pairs)
+		name += "{" + strings.Join(pairs, ",") + "}"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters[name]++
+}
+
+func (c *countingRecorder) SetGauge(name string, value float64, _ Labels) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gauges[name] = value
+}
+
+func (c *countingRecorder) counter(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counters[name]
+}
+
+func TestTTLRefreshMetrics(t *testing.T) {
+	_, h := setupTest(t)
+	config.TTLRefreshInterval = 0
+	rec := newCountingRecorder()
+	metrics = rec
+
+	do(h, "GET", "/product/1", "")
+	do(h, "GET", "/product/2", "")
+	if n := rec.counter("product_ttl_refresh_total"); n != 0 {
+		t.Fatalf("refreshes below the popular threshold: got %d", n)
+	}
+	for i := 0; i < popularThreshold; i++ {
+		do(h, "GET", "/product/1", "")
+	}
+	before := rec.counter("product_ttl_refresh_total")
+	if before == 0 {
+		t.Fatal("no refresh counted once product 1 reached the threshold")
+	}
+	do(h, "GET", "/product/1", "")
+	if n := rec.counter("product_ttl_refresh_total"); n != before+1 {
+		t.Fatalf("refreshes after another popular hit: got %d, want %d", n, before+1)
+	}
+
+	if err := updatePopularProductsGauge(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.gauges["popular_products"]; got != 1 {
+		t.Fatalf("popular_products gauge: got %v, want 1", got)
+	}
+}
+
+func TestPopularProductsGaugeWindowFollowsClock(t *testing.T) {
+	setupTest(t)
+	config.HitsMode = hitsModeSliding
+	rec := newCountingRecorder()
+	metrics = rec
+	ctx := context.Background()
+
+	now := time.Now()
+	for i := 0; i < popularThreshold; i++ {
+		redisClient.ZAdd(ctx, redisProductRecentHitsKey(1), &redis.Z{Score: float64(now.UnixNano() + int64(i)), Member: i})
+	}
+	for _, tc := range []struct {
+		at   time.Time
+		want float64
+	}{
+		{now, 1},
+		// Seen from past the window, the same hits no longer count
+		{now.Add(2 * config.HitsWindow), 0},
+	} {
+		clock = fixedClock{tc.at}
+		if err := updatePopularProductsGauge(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.gauges["popular_products"]; got != tc.want {
+			t.Fatalf("popular_products at %v: got %v, want %v", tc.at, got, tc.want)
+		}
+	}
+}
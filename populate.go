@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// cacheFill is one product read from the DB, to be written into the cache by
+// populateProductCaches
+type cacheFill struct {
+	product   Product
+	gen       uint64 // write generation it was read at
+	overwrite bool   // replace an entry known to be corrupt
+}
+
+// Write several products into the cache in one pipeline, by the rules of
+// populateProductCache except the populate lock. A pipeline can fail part
+// way, e.g. when the connection drops, leaving some entries written and
+// others not; only the failed ones are sent again, up to
+// BATCH_POPULATE_RETRIES times. Returns the IDs written and the IDs that
+// still failed. Fills skipped by the rules, or already cached, are in
+// neither.
+func populateProductCaches(ctx context.Context, fills []cacheFill) (written, failed []int) {
+	var pending []cacheFill
+	for _, f := range fills {
+		if !f.product.NoCache && !cachePopulatePaused() && generationCurrent(ctx, f.product.ID, f.gen) {
+			pending = append(pending, f)
+		}
+	}
+	ttl := productCacheWriteTTL()
+	for attempt := 0; len(pending) > 0; attempt++ {
+		sets := make([]*redis.StatusCmd, len(pending))
+		setNXs := make([]*redis.BoolCmd, len(pending))
+		redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, f := range pending {
+				raw := encodeCachedProduct(f.product, config.CacheSchemaVersion)
+				key := redisProductKey(f.product.ID)
+				if f.overwrite {
+					sets[i] = pipe.Set(ctx, key, raw, ttl)
+				} else {
+					setNXs[i] = pipe.SetNX(ctx, key, raw, ttl)
+				}
+				pipe.Set(ctx, redisProductHitsKey(f.product.ID), 1, ttl)
+			}
+			return nil
+		})
+
+		var retry []cacheFill
+		for i, f := range pending {
+			var err error
+			stored := true
+			if sets[i] != nil {
+				err = sets[i].Err()
+			} else {
+				stored, err = setNXs[i].Result()
+			}
+			if err != nil && !errors.Is(err, redis.Nil) {
+				retry = append(retry, f)
+				continue
+			}
+			if !stored {
+				continue
+			}
+			if !generationCurrent(ctx, f.product.ID, f.gen) {
+				// A write landed mid-way; don't leave its old value cached
+				redisClient.Del(ctx, redisProductKey(f.product.ID))
+				continue
+			}
+			written = append(written, f.product.ID)
+		}
+		if len(retry) > 0 {
+			metrics.IncrCounter("cache_populate_partial_failures_total", nil)
+		}
+		if attempt >= config.BatchPopulateRetries || ctx.Err() != nil {
+			for _, f := range retry {
+				failed = append(failed, f.product.ID)
+			}
+			break
+		}
+		pending = retry
+	}
+	return written, failed
+}
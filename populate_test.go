@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// pipelineFailureHook fails the SETNX of one key in the first pipeline, as
+// if the connection dropped before it was written, and records the SETNX
+// keys each pipeline sent
+type pipelineFailureHook struct {
+	mr        *miniredis.Miniredis
+	key       string
+	mu        sync.Mutex
+	pipelines [][]string
+}
+
+func (h *pipelineFailureHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *pipelineFailureHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (h *pipelineFailureHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *pipelineFailureHook) AfterProcessPipeline(_ context.Context, cmds []redis.Cmder) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var keys []string
+	for _, cmd := range cmds {
+		// SETNX with a TTL goes out as SET ... NX
+		args := cmd.Args()
+		if cmd.Name() != "set" || args[len(args)-1] != "nx" {
+			continue
+		}
+		key := args[1].(string)
+		keys = append(keys, key)
+		if key == h.key && len(h.pipelines) == 0 {
+			h.mr.Del(key)
+			cmd.SetErr(errors.New("connection reset by peer"))
+		}
+	}
+	h.pipelines = append(h.pipelines, keys)
+	return nil
+}
+
+// Fills for products 1 to 3 as just read from the DB
+func seedFills(t *testing.T) []cacheFill {
+	t.Helper()
+	var fills []cacheFill
+	for id := 1; id <= 3; id++ {
+		product, gen, err := readProductWithGeneration(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fills = append(fills, cacheFill{product: product, gen: gen})
+	}
+	return fills
+}
+
+func TestBatchPopulateRetriesOnlyFailedKeys(t *testing.T) {
+	mr, _ := setupTest(t)
+	hook := &pipelineFailureHook{mr: mr, key: redisProductKey(2)}
+	redisClient.AddHook(hook)
+
+	written, failed := populateProductCaches(context.Background(), seedFills(t))
+	if !reflect.DeepEqual(written, []int{1, 3, 2}) || len(failed) != 0 {
+		t.Fatalf("written %v, failed %v; want [1 3 2] and none", written, failed)
+	}
+	want := [][]string{
+		{redisProductKey(1), redisProductKey(2), redisProductKey(3)},
+		{redisProductKey(2)},
+	}
+	if !reflect.DeepEqual(hook.pipelines, want) {
+		t.Fatalf("pipelines sent %v, want %v", hook.pipelines, want)
+	}
+	for id := 1; id <= 3; id++ {
+		if !mr.Exists(redisProductKey(id)) {
+			t.Fatalf("product %d not cached", id)
+		}
+	}
+}
+
+func TestBatchPopulateReportsFailuresWithoutRetries(t *testing.T) {
+	mr, _ := setupTest(t)
+	config.BatchPopulateRetries = 0
+	redisClient.AddHook(&pipelineFailureHook{mr: mr, key: redisProductKey(2)})
+
+	written, failed := populateProductCaches(context.Background(), seedFills(t))
+	if !reflect.DeepEqual(written, []int{1, 3}) || !reflect.DeepEqual(failed, []int{2}) {
+		t.Fatalf("written %v, failed %v; want [1 3] and [2]", written, failed)
+	}
+	if mr.Exists(redisProductKey(2)) {
+		t.Fatal("failed product 2 is cached")
+	}
+}
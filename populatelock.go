@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Delete a populate lock only if it still holds the holder's token, so a
+// populate that outlived POPULATE_LOCK_TTL can't release a lock another
+// reader has taken since
+var releasePopulateLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Utility - take a product's populate lock, returning the token to release
+// it with
+func acquirePopulateLock(ctx context.Context, id int) (string, bool, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", false, err
+	}
+	token := hex.EncodeToString(b[:])
+	ok, err := redisClient.SetNX(ctx, redisProductPopulateLockKey(id), token, config.PopulateLockTTL).Result()
+	return token, ok, err
+}
+
+// Utility - release a populate lock taken with acquirePopulateLock
+func releasePopulateLock(ctx context.Context, id int, token string) {
+	releasePopulateLockScript.Run(ctx, redisClient, []string{redisProductPopulateLockKey(id)}, token)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPopulateLockReleaseKeepsAnotherHoldersLock(t *testing.T) {
+	mr, _ := setupTest(t)
+	ctx := context.Background()
+
+	token, ok, err := acquirePopulateLock(ctx, 1)
+	if err != nil || !ok {
+		t.Fatalf("acquiring a free lock: ok=%v err=%v", ok, err)
+	}
+	if _, ok, _ := acquirePopulateLock(ctx, 1); ok {
+		t.Fatal("a held lock was acquired twice")
+	}
+
+	// Our hold expires and another reader takes the lock over
+	mr.FastForward(config.PopulateLockTTL + 1)
+	other, ok, _ := acquirePopulateLock(ctx, 1)
+	if !ok {
+		t.Fatal("an expired lock could not be taken over")
+	}
+	releasePopulateLock(ctx, 1, token)
+	if v, _ := mr.Get(redisProductPopulateLockKey(1)); v != other {
+		t.Fatal("releasing an expired hold deleted the new holder's lock")
+	}
+
+	releasePopulateLock(ctx, 1, other)
+	if mr.Exists(redisProductPopulateLockKey(1)) {
+		t.Fatal("the holder's release left the lock in place")
+	}
+}
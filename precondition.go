@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errPreconditionFailed is returned when If-Match doesn't name the
+// product's current version
+var errPreconditionFailed = errors.New("product version does not match If-Match")
+
+// versionPrecondition is a parsed If-Match header: "*" or a list of
+// versions, each either bare (3) or as a strong entity tag ("3")
+type versionPrecondition struct {
+	any      bool
+	versions []int
+}
+
+func (p *versionPrecondition) matches(version int) bool {
+	if p.any {
+		return true
+	}
+	for _, v := range p.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// Utility - parse the request's If-Match header; nil when it has none.
+// Weak tags (W/"3") are rejected, as If-Match uses strong comparison.
+func parseIfMatch(r *http.Request) (*versionPrecondition, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil, nil
+	}
+	p := &versionPrecondition{}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			p.any = true
+			continue
+		}
+		if len(candidate) >= 2 && candidate[0] == '"' && candidate[len(candidate)-1] == '"' {
+			candidate = candidate[1 : len(candidate)-1]
+		}
+		v, err := strconv.Atoi(candidate)
+		if err != nil || v < 0 {
+			return nil, errors.New("If-Match must be * or product versions")
+		}
+		p.versions = append(p.versions, v)
+	}
+	return p, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestConditionalDelete(t *testing.T) {
+	_, h := setupTest(t)
+
+	if w := do(h, "DELETE", "/product/1", "", "If-Match", `"2"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match of another version: got %d, want 412", w.Code)
+	}
+	if _, ok := dbProduct(1); !ok {
+		t.Fatal("product deleted despite a failed precondition")
+	}
+	if w := do(h, "DELETE", "/product/1", "", "If-Match", `W/"1"`); w.Code != http.StatusBadRequest {
+		t.Fatalf("weak If-Match: got %d, want 400", w.Code)
+	}
+	if w := do(h, "DELETE", "/product/1", "", "If-Match", `"3", "1"`); w.Code != http.StatusNoContent {
+		t.Fatalf("If-Match listing the current version: got %d, want 204", w.Code)
+	}
+	if w := do(h, "DELETE", "/product/2", "", "If-Match", "*"); w.Code != http.StatusNoContent {
+		t.Fatalf("If-Match *: got %d, want 204", w.Code)
+	}
+	if w := do(h, "DELETE", "/product/3", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE without If-Match: got %d, want 204", w.Code)
+	}
+}
+
+func TestRequireIfMatchDelete(t *testing.T) {
+	_, h := setupTest(t)
+	config.RequireIfMatchDelete = true
+
+	if w := do(h, "DELETE", "/product/1", ""); w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("DELETE without If-Match: got %d, want 428", w.Code)
+	}
+	if w := do(h, "DELETE", "/product/1", "", "If-Match", "1"); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE with If-Match: got %d, want 204", w.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// Price is a product price in the smallest currency unit (e.g., cents).
+// On input it accepts integers, floats with a zero fraction and numeric
+// strings; how non-zero fractions are treated depends on PRICE_PARSE_MODE.
+type Price int
+
+// A price in plain decimal notation, as JSON writes numbers
+var decimalPricePattern = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// PriceError describes a price value that could not be accepted
+type PriceError struct {
+	Value  string
+	Reason string
+}
+
+func (e *PriceError) Error() string {
+	return fmt.Sprintf("invalid price %s: %s", e.Value, e.Reason)
+}
+
+// UnmarshalJSON accepts 100, 100.0 and "100"
+func (p *Price) UnmarshalJSON(data []byte) error {
+	raw := string(data)
+	if raw == "null" {
+		return nil
+	}
+	s := raw
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return &PriceError{Value: raw, Reason: "malformed string"}
+		}
+		if s == "" {
+			return &PriceError{Value: raw, Reason: "empty string"}
+		}
+	}
+
+	// strconv also takes hex floats, "Inf", "NaN" and underscores
+	if !decimalPricePattern.MatchString(s) {
+		return &PriceError{Value: raw, Reason: "must be a number or numeric string"}
+	}
+	if n, err := strconv.ParseInt(s, 10, 0); err == nil {
+		*p = Price(n)
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return &PriceError{Value: raw, Reason: "must be a number or numeric string"}
+	}
+	if math.Abs(f) > 1<<53 {
+		return &PriceError{Value: raw, Reason: "out of range"}
+	}
+	if _, frac := math.Modf(f); frac != 0 {
+		if config.PriceParseMode != priceParseRound {
+			return &PriceError{Value: raw, Reason: "fractional cents are not allowed"}
+		}
+		f = math.Round(f)
+	}
+	*p = Price(f)
+	return nil
+}
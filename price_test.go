@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPriceUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in    string
+		mode  string
+		want  Price
+		valid bool
+	}{
+		{in: `100`, want: 100, valid: true},
+		{in: `-5`, want: -5, valid: true},
+		{in: `100.0`, want: 100, valid: true},
+		{in: `"100"`, want: 100, valid: true},
+		{in: `"+7"`, want: 7, valid: true},
+		{in: `1e2`, want: 100, valid: true},
+		{in: `"2.5E1"`, want: 25, valid: true},
+		{in: `100.5`, valid: false},
+		{in: `100.5`, mode: priceParseRound, want: 101, valid: true},
+		{in: `"0x1p3"`, valid: false},
+		{in: `"0x10"`, valid: false},
+		{in: `"Inf"`, valid: false},
+		{in: `"-infinity"`, valid: false},
+		{in: `"NaN"`, valid: false},
+		{in: `"1_000"`, valid: false},
+		{in: `".5"`, valid: false},
+		{in: `"5."`, valid: false},
+		{in: `" 5"`, valid: false},
+		{in: `""`, valid: false},
+		{in: `"abc"`, valid: false},
+		{in: `1e300`, valid: false},
+	}
+	for _, tt := range tests {
+		config = defaultConfig()
+		if tt.mode != "" {
+			config.PriceParseMode = tt.mode
+		}
+		var p Price
+		err := json.Unmarshal([]byte(tt.in), &p)
+		if !tt.valid {
+			var perr *PriceError
+			if !errors.As(err, &perr) {
+				t.Errorf("%s: got %v, %v; want a *PriceError", tt.in, p, err)
+			}
+			continue
+		}
+		if err != nil || p != tt.want {
+			t.Errorf("%s: got %v, %v; want %v", tt.in, p, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	contentTypeProblem = "application/problem+json"
+
+	// Problem type of a product breaking a business rule, relative to the API
+	problemTypeValidation = "/problems/validation"
+)
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Extension members: the fields at fault, for validation problems, and
+	// the trace ID of a traced request
+	Errors  []ProblemFieldError `json:"errors,omitempty"`
+	TraceID string              `json:"trace_id,omitempty"`
+}
+
+// ProblemFieldError is one field of a request that failed validation
+type ProblemFieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Utility - whether the client asked for errors as application/problem+json
+func wantsProblem(r *http.Request) bool {
+	return acceptsExactly(r.Header.Get("Accept"), contentTypeProblem)
+}
+
+// Utility - write p as application/problem+json, with the request's trace
+// ID when tracingMiddleware has set one
+func writeProblem(w http.ResponseWriter, p Problem) {
+	if p.TraceID == "" {
+		p.TraceID = w.Header().Get("X-Trace-Id")
+	}
+	w.Header().Set("Content-Type", contentTypeProblem)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,4 @@
+// Package productpb holds the protobuf and gRPC bindings for the product API.
+package productpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative product.proto
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Utility - whether the request carries one of API_TOKENS as its bearer token
+func clientAuthenticated(r *http.Request) bool {
+	return bearerTokenValid(r.Header.Get("Authorization"))
+}
+
+// Utility - whether an Authorization value is "Bearer " and one of API_TOKENS
+func bearerTokenValid(authorization string) bool {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == "" || token == authorization {
+		return false
+	}
+	for _, t := range config.APITokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Utility - whether product responses to this request are cut down to
+// PUBLIC_FIELDS. Sets Vary: Authorization whenever the projection is
+// configured, since the response then depends on who's asking.
+func publicProjection(w http.ResponseWriter, r *http.Request) bool {
+	if len(config.PublicFields) == 0 {
+		return false
+	}
+	if !containsString(w.Header().Values("Vary"), "Authorization") {
+		w.Header().Add("Vary", "Authorization")
+	}
+	return !clientAuthenticated(r)
+}
+
+// Utility - the JSON object for v (a product, or a product with extras like
+// a display currency) keeping only the PUBLIC_FIELDS keys; "id" always stays
+func projectProduct(v interface{}) map[string]interface{} {
+	raw, _ := json.Marshal(v)
+	var fields map[string]interface{}
+	json.Unmarshal(raw, &fields)
+	return projectFields(fields)
+}
+
+func projectFields(fields map[string]interface{}) map[string]interface{} {
+	for key := range fields {
+		if key != "id" && !containsString(config.PublicFields, key) {
+			delete(fields, key)
+		}
+	}
+	return fields
+}
+
+// Utility - the JSON object for v, a response wrapping products under key
+// (batch results, history entries, events), with each of them projected. The
+// value under key is a product or a list of products, nulls kept.
+func projectWrapped(v interface{}, key string) map[string]interface{} {
+	raw, _ := json.Marshal(v)
+	var fields map[string]interface{}
+	json.Unmarshal(raw, &fields)
+	switch wrapped := fields[key].(type) {
+	case map[string]interface{}:
+		projectFields(wrapped)
+	case []interface{}:
+		for _, item := range wrapped {
+			if product, ok := item.(map[string]interface{}); ok {
+				projectFields(product)
+			}
+		}
+	}
+	return fields
+}
+
+// Utility - the public projection of a page of products
+func projectProducts(products []Product) []map[string]interface{} {
+	projected := make([]map[string]interface{}, len(products))
+	for i, p := range products {
+		projected[i] = projectProduct(p)
+	}
+	return projected
+}
+
+// Utility - a product with the fields outside PUBLIC_FIELDS zeroed, for
+// protobuf clients: proto3 leaves zero values off the wire
+func hideProductFields(p Product) Product {
+	hidden := Product{ID: p.ID}
+	if containsString(config.PublicFields, "name") {
+		hidden.Name = p.Name
+	}
+	if containsString(config.PublicFields, "price") {
+		hidden.Price = p.Price
+	}
+	if containsString(config.PublicFields, "version") {
+		hidden.Version = p.Version
+	}
+	return hidden
+}
+
+func containsString(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+
+	"example.com/gorediscache/productpb"
+)
+
+func TestPublicProjectionHidesPrice(t *testing.T) {
+	_, h := setupTest(t)
+	config.PublicFields = []string{"name"}
+	config.APITokens = []string{"partner-token"}
+
+	var public map[string]interface{}
+	w := do(h, "GET", "/product/1", "")
+	decodeBody(t, w, &public)
+	if !reflect.DeepEqual(public, map[string]interface{}{"id": 1.0, "name": "Apple"}) {
+		t.Fatalf("unauthenticated read: got %v", public)
+	}
+	if !containsString(w.Header().Values("Vary"), "Authorization") {
+		t.Fatalf("Vary: %q", w.Header().Values("Vary"))
+	}
+
+	for _, token := range []string{"", "wrong-token"} {
+		var p map[string]interface{}
+		decodeBody(t, do(h, "GET", "/product/1", "", "Authorization", "Bearer "+token), &p)
+		if _, ok := p["price"]; ok {
+			t.Fatalf("token %q: price shown", token)
+		}
+	}
+
+	var full Product
+	decodeBody(t, do(h, "GET", "/product/1", "", "Authorization", "Bearer partner-token"), &full)
+	if full.Price != 100 || full.Version != 1 {
+		t.Fatalf("authenticated read: got %+v", full)
+	}
+
+	var list struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	decodeBody(t, do(h, "GET", "/products", ""), &list)
+	for _, item := range list.Items {
+		if _, ok := item["price"]; ok || item["name"] == nil {
+			t.Fatalf("unauthenticated list item: %v", item)
+		}
+	}
+}
+
+func TestNoProjectionWithoutPublicFields(t *testing.T) {
+	_, h := setupTest(t)
+	var p Product
+	w := do(h, "GET", "/product/1", "")
+	decodeBody(t, w, &p)
+	if p.Price != 100 || containsString(w.Header().Values("Vary"), "Authorization") {
+		t.Fatalf("got %+v, Vary %q", p, w.Header().Values("Vary"))
+	}
+}
+
+func TestPublicProjectionAppliesToEveryProductResponse(t *testing.T) {
+	_, h := setupTest(t)
+	config.PublicFields = []string{"name"}
+	config.APITokens = []string{"partner-token"}
+	do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":120}`, "Authorization", "Bearer partner-token")
+	do(h, "GET", "/product/2", "")
+
+	requests := []struct{ path, accept string }{
+		{"/products/batch?ids=1,2,9", ""},
+		{"/products/batch?ids=1,9,1&ordered=true", ""},
+		{"/products/batch?ids=1,2", contentTypeNDJSON},
+		{"/products/export", ""},
+		{"/products/export?format=ndjson", ""},
+		{"/products/popular", ""},
+		{"/product/1/history", ""},
+	}
+	for _, req := range requests {
+		if body := do(h, "GET", req.path, "", "Accept", req.accept).Body.String(); strings.Contains(body, `"price"`) || !strings.Contains(body, `"name"`) {
+			t.Fatalf("GET %s unauthenticated: %s", req.path, body)
+		}
+		body := do(h, "GET", req.path, "", "Accept", req.accept, "Authorization", "Bearer partner-token").Body.String()
+		if !strings.Contains(body, `"price"`) {
+			t.Fatalf("GET %s authenticated: %s", req.path, body)
+		}
+	}
+
+	w := do(h, "POST", "/product", `{"name":"Damson","price":300}`)
+	if body := w.Body.String(); w.Code != http.StatusCreated || strings.Contains(body, `"price"`) || !strings.Contains(body, `"Damson"`) {
+		t.Fatalf("create: %d %s", w.Code, body)
+	}
+}
+
+func TestGRPCPublicProjection(t *testing.T) {
+	setupTest(t)
+	config.PublicFields = []string{"name"}
+	config.APITokens = []string{"partner-token"}
+	client := grpcTestClient(t)
+
+	public, err := client.GetProduct(context.Background(), &productpb.GetProductRequest{Id: 1})
+	if err != nil || public.GetName() != "Apple" || public.GetPrice() != 0 {
+		t.Fatalf("unauthenticated: %v, %v", public, err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer partner-token")
+	full, err := client.GetProduct(ctx, &productpb.GetProductRequest{Id: 1})
+	if err != nil || full.GetPrice() != 100 {
+		t.Fatalf("authenticated: %v, %v", full, err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"google.golang.org/protobuf/proto"
+
+	"example.com/gorediscache/productpb"
+)
+
+// Utility - map a product to its protobuf message
+func productToProto(p Product) *productpb.Product {
+	return &productpb.Product{
+		Id:      int64(p.ID),
+		Name:    p.Name,
+		Price:   int64(p.Price),
+		Version: int64(p.Version),
+	}
+}
+
+// Utility - map a protobuf message back to a product
+func productFromProto(m *productpb.Product) Product {
+	return Product{
+		ID:      int(m.GetId()),
+		Name:    m.GetName(),
+		Price:   Price(m.GetPrice()),
+		Version: int(m.GetVersion()),
+	}
+}
+
+// Utility - write a product as protobuf
+func writeProductProtobuf(w http.ResponseWriter, product Product) {
+	raw, err := proto.Marshal(productToProto(product))
+	if err != nil {
+		log.Printf("Protobuf marshal error: %v", err)
+		http.Error(w, "Could not encode product", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeProtobuf)
+	w.Write(raw)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"example.com/gorediscache/productpb"
+)
+
+func TestProductProtoRoundTrip(t *testing.T) {
+	p := Product{ID: 7, Name: "Date", Price: 1234, Version: 3}
+	raw, err := proto.Marshal(productToProto(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m productpb.Product
+	if err := proto.Unmarshal(raw, &m); err != nil {
+		t.Fatal(err)
+	}
+	if got := productFromProto(&m); got != p {
+		t.Fatalf("round trip: got %+v, want %+v", got, p)
+	}
+}
+
+func TestGetProductAsProtobuf(t *testing.T) {
+	_, h := setupTest(t)
+
+	w := do(h, "GET", "/product/1", "", "Accept", contentTypeProtobuf)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != contentTypeProtobuf {
+		t.Fatalf("GET with Accept protobuf: got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var m productpb.Product
+	if err := proto.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := productFromProto(&m), (Product{ID: 1, Name: "Apple", Price: 100, Version: 1}); got != want {
+		t.Fatalf("protobuf product: got %+v, want %+v", got, want)
+	}
+
+	if ct := do(h, "GET", "/product/1", "").Header().Get("Content-Type"); ct != contentTypeJSON {
+		t.Fatalf("GET without Accept: got %q, want JSON", ct)
+	}
+}
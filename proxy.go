@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Ways to render the Location header of a created product
+const (
+	locationRelative = "relative" // /product/5
+	locationAbsolute = "absolute" // https://host/product/5
+)
+
+// Networks whose X-Forwarded-* headers are believed, from TRUSTED_PROXIES
+var trustedProxyNets []*net.IPNet
+
+// Parse TRUSTED_PROXIES entries, each an IP or a CIDR
+func parseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Whether the request came directly from a trusted proxy
+func fromTrustedProxy(r *http.Request) bool {
+	return trustedProxy(peerIP(r))
+}
+
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxyNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Utility - the address of the peer that sent the request, without the port
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Utility - the address of the client behind a request, for rate limits and
+// logs. From a trusted proxy it's the nearest X-Forwarded-For hop that isn't
+// a trusted proxy itself; the entries before that are whatever the client
+// sent, so they're never believed.
+func clientIP(r *http.Request) string {
+	ip := peerIP(r)
+	if !trustedProxy(ip) {
+		return ip
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// Utility - first value of a possibly comma-separated forwarded header
+func firstForwarded(v string) string {
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+// Utility - scheme and host the client used to reach us. X-Forwarded-Proto
+// and X-Forwarded-Host are honoured only from a trusted proxy; anyone else
+// could use them to make us emit links to an arbitrary host.
+func requestOrigin(r *http.Request) string {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if fromTrustedProxy(r) {
+		if p := firstForwarded(r.Header.Get("X-Forwarded-Proto")); p == "http" || p == "https" {
+			scheme = p
+		}
+		if h := firstForwarded(r.Header.Get("X-Forwarded-Host")); h != "" {
+			host = h
+		}
+	}
+	return scheme + "://" + host
+}
+
+// link is a hypermedia link; Method is set for links that aren't followed
+// with GET
+type link struct {
+	Href   string `json:"href"`
+	Method string `json:"method,omitempty"`
+}
+
+// productLinks are the _links of a product response
+type productLinks struct {
+	Self    link `json:"self"`
+	Update  link `json:"update"`
+	Delete  link `json:"delete"`
+	History link `json:"history"`
+}
+
+// linkedProduct is a product with its _links
+type linkedProduct struct {
+	Product
+	Links productLinks `json:"_links"`
+}
+
+// Utility - whether product responses to this request carry _links: always
+// with PRODUCT_LINKS, otherwise when the client asks for application/hal+json
+func wantProductLinks(r *http.Request) bool {
+	return config.ProductLinks || negotiateContentType(r.Header.Get("Accept"), productContentTypes) == contentTypeHAL
+}
+
+// Utility - the _links of a product, built like its Location so they point
+// at the host and scheme the client used
+func productLinksFor(r *http.Request, id int) productLinks {
+	self := productLocation(r, id)
+	return productLinks{
+		Self:    link{Href: self},
+		Update:  link{Href: self, Method: http.MethodPut},
+		Delete:  link{Href: self, Method: http.MethodDelete},
+		History: link{Href: self + "/history"},
+	}
+}
+
+// Utility - Location of a product, per LOCATION_STYLE
+func productLocation(r *http.Request, id int) string {
+	path := fmt.Sprintf("/product/%d", id)
+	if config.LocationStyle == locationAbsolute {
+		return requestOrigin(r) + path
+	}
+	return path
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// POST a product from remoteAddr with the given forwarded headers and return
+// the Location of the created product
+func createdLocation(t *testing.T, h http.Handler, remoteAddr string, header ...string) string {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/product", strings.NewReader(`{"name":"Date","price":5}`))
+	req.RemoteAddr = remoteAddr
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got %d %s", w.Code, w.Body.String())
+	}
+	var created Product
+	decodeBody(t, w, &created)
+	return strings.Replace(w.Header().Get("Location"), fmt.Sprint(created.ID), "{id}", 1)
+}
+
+func TestLocationStyles(t *testing.T) {
+	_, h := setupTest(t)
+	forwarded := []string{"X-Forwarded-Proto", "https", "X-Forwarded-Host", "shop.example, proxy.internal"}
+	var err error
+	if trustedProxyNets, err = parseTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := createdLocation(t, h, "10.1.2.3:4000", forwarded...); got != "/product/{id}" {
+		t.Fatalf("relative: got %q", got)
+	}
+
+	config.LocationStyle = locationAbsolute
+	for _, tc := range []struct {
+		remoteAddr string
+		header     []string
+		want       string
+	}{
+		{"192.0.2.1:4000", nil, "http://example.com/product/{id}"},
+		{"10.1.2.3:4000", forwarded, "https://shop.example/product/{id}"},
+		// Forwarded headers from anyone else are ignored
+		{"192.0.2.1:4000", forwarded, "http://example.com/product/{id}"},
+	} {
+		if got := createdLocation(t, h, tc.remoteAddr, tc.header...); got != tc.want {
+			t.Fatalf("absolute from %s with %v: got %q, want %q", tc.remoteAddr, tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestProductLinks(t *testing.T) {
+	_, h := setupTest(t)
+	var plain map[string]interface{}
+	decodeBody(t, do(h, "GET", "/product/1", ""), &plain)
+	if _, ok := plain["_links"]; ok {
+		t.Fatal("_links without PRODUCT_LINKS or a HAL Accept")
+	}
+
+	want := productLinks{
+		Self:    link{Href: "/product/1"},
+		Update:  link{Href: "/product/1", Method: "PUT"},
+		Delete:  link{Href: "/product/1", Method: "DELETE"},
+		History: link{Href: "/product/1/history"},
+	}
+	var hal linkedProduct
+	w := do(h, "GET", "/product/1", "", "Accept", contentTypeHAL)
+	decodeBody(t, w, &hal)
+	if w.Header().Get("Content-Type") != contentTypeHAL || hal.Links != want || hal.Name != "Apple" {
+		t.Fatalf("HAL read: %q %+v", w.Header().Get("Content-Type"), hal)
+	}
+
+	// Absolute links use the same host derivation as Location
+	config.ProductLinks = true
+	config.LocationStyle = locationAbsolute
+	var err error
+	if trustedProxyNets, err = parseTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/product/1", nil)
+	req.RemoteAddr = "10.1.2.3:4000"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "shop.example")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var linked linkedProduct
+	decodeBody(t, rec, &linked)
+	if linked.Links.Self.Href != "https://shop.example/product/1" || linked.Links.Delete.Method != "DELETE" ||
+		linked.Links.History.Href != "https://shop.example/product/1/history" {
+		t.Fatalf("absolute links: %+v", linked.Links)
+	}
+}
+
+func TestClientIPBehindTrustedProxy(t *testing.T) {
+	setupTest(t)
+	var err error
+	if trustedProxyNets, err = parseTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		remoteAddr, forwardedFor, want string
+	}{
+		{"10.1.2.3:4000", "198.51.100.7", "198.51.100.7"},
+		// The client's own claim comes first; the proxy's view of it last
+		{"10.1.2.3:4000", "203.0.113.9, 198.51.100.7", "198.51.100.7"},
+		{"10.1.2.3:4000", "198.51.100.7, 10.9.9.9", "198.51.100.7"},
+		{"10.1.2.3:4000", "", "10.1.2.3"},
+		{"10.1.2.3:4000", "garbage", "10.1.2.3"},
+		// Anyone else can't pick their address
+		{"192.0.2.1:4000", "198.51.100.7", "192.0.2.1"},
+	} {
+		req := httptest.NewRequest("GET", "/product/1", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		if got := clientIP(req); got != tc.want {
+			t.Errorf("from %s with X-Forwarded-For %q: got %s, want %s", tc.remoteAddr, tc.forwardedFor, got, tc.want)
+		}
+	}
+}
+
+func TestRateLimitPerClientBehindProxy(t *testing.T) {
+	_, h := setupTest(t)
+	var err error
+	if trustedProxyNets, err = parseTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+	requestRateLimiter = newKeyedTokenBuckets(0.001, 1)
+
+	get := func(forwardedFor string) int {
+		req := httptest.NewRequest("GET", "/product/1", nil)
+		req.RemoteAddr = "10.1.2.3:4000"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	if get("198.51.100.7") != http.StatusOK || get("198.51.100.7") != http.StatusTooManyRequests {
+		t.Fatal("a client's budget isn't enforced behind the proxy")
+	}
+	if code := get("198.51.100.8"); code != http.StatusOK {
+		t.Fatalf("another client behind the same proxy: got %d, want its own budget", code)
+	}
+}
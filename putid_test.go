@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPutIDPolicies(t *testing.T) {
+	bodies := map[string]string{
+		"mismatched": `{"id":2,"name":"Apricot","price":90}`,
+		"matching":   `{"id":1,"name":"Apricot","price":90}`,
+		"absent":     `{"name":"Apricot","price":90}`,
+	}
+	want := map[string]map[string]int{
+		putIDStrict:       {"mismatched": http.StatusBadRequest, "matching": http.StatusNoContent, "absent": http.StatusBadRequest},
+		putIDPathWins:     {"mismatched": http.StatusNoContent, "matching": http.StatusNoContent, "absent": http.StatusNoContent},
+		putIDBodyOptional: {"mismatched": http.StatusBadRequest, "matching": http.StatusNoContent, "absent": http.StatusNoContent},
+	}
+	for policy, codes := range want {
+		for name, body := range bodies {
+			_, h := setupTest(t)
+			config.PutIDPolicy = policy
+			w := do(h, "PUT", "/product/1", body)
+			if w.Code != codes[name] {
+				t.Errorf("%s, %s body ID: got %d, want %d", policy, name, w.Code, codes[name])
+				continue
+			}
+			apple, _ := dbProduct(1)
+			banana, _ := dbProduct(2)
+			if w.Code == http.StatusNoContent && apple.Name != "Apricot" {
+				t.Errorf("%s, %s body ID: product 1 is %+v", policy, name, apple)
+			}
+			if banana.Name != "Banana" {
+				t.Errorf("%s, %s body ID: product 2 was written: %+v", policy, name, banana)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a minimal thread-safe token bucket: it holds up to burst
+// tokens and refills at rate tokens per second
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// quota is a bucket's state after a take, for reporting to clients
+type quota struct {
+	Limit      int           // burst size
+	Remaining  int           // whole tokens left
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token; 0 if one is available
+}
+
+// Take a token if one is available
+func (b *tokenBucket) allow() bool {
+	ok, _ := b.take()
+	return ok
+}
+
+// Whether a token is available, without taking it
+func (b *tokenBucket) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tokens := b.tokens + time.Since(b.last).Seconds()*b.rate
+	return tokens >= 1
+}
+
+// Take a token, waiting for one if needed; fails only when ctx ends
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		ok, q := b.take()
+		if ok {
+			return nil
+		}
+		sleepCtx(ctx, q.RetryAfter)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Take a token if one is available, reporting the resulting quota
+func (b *tokenBucket) take() (bool, quota) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	ok := b.tokens >= 1
+	if ok {
+		b.tokens--
+	}
+	q := quota{
+		Limit:     int(b.burst),
+		Remaining: int(b.tokens),
+		Reset:     b.refillTime(b.burst - b.tokens),
+	}
+	if b.tokens < 1 {
+		q.RetryAfter = b.refillTime(1 - b.tokens)
+	}
+	return ok, q
+}
+
+// Utility - how long refilling the given number of tokens takes
+func (b *tokenBucket) refillTime(tokens float64) time.Duration {
+	return time.Duration(tokens / b.rate * float64(time.Second))
+}
+
+// keyedTokenBuckets keeps one token bucket per key (e.g. client IP). Buckets
+// that have been idle long enough to refill completely are indistinguishable
+// from new ones, so they are periodically discarded to bound memory.
+type keyedTokenBuckets struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     int
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+const keyedBucketSweepInterval = time.Minute
+
+func newKeyedTokenBuckets(rate float64, burst int) *keyedTokenBuckets {
+	return &keyedTokenBuckets{rate: rate, burst: burst, buckets: map[string]*tokenBucket{}, lastSweep: time.Now()}
+}
+
+// Take a token from key's bucket if one is available
+func (k *keyedTokenBuckets) allow(key string) bool {
+	ok, _ := k.take(key)
+	return ok
+}
+
+// Take a token from key's bucket if one is available, reporting its quota
+func (k *keyedTokenBuckets) take(key string) (bool, quota) {
+	k.mu.Lock()
+	now := time.Now()
+	if now.Sub(k.lastSweep) >= keyedBucketSweepInterval {
+		k.sweep(now)
+	}
+	b, ok := k.buckets[key]
+	if !ok {
+		b = newTokenBucket(k.rate, k.burst)
+		k.buckets[key] = b
+	}
+	k.mu.Unlock()
+	return b.take()
+}
+
+// Drop buckets that would have refilled to burst by now. Callers hold k.mu.
+func (k *keyedTokenBuckets) sweep(now time.Time) {
+	refill := time.Duration(float64(k.burst) / k.rate * float64(time.Second))
+	for key, b := range k.buckets {
+		b.mu.Lock()
+		idle := now.Sub(b.last)
+		b.mu.Unlock()
+		if idle >= refill {
+			delete(k.buckets, key)
+		}
+	}
+	k.lastSweep = now
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var errCacheReadOnly = errors.New("cache is read-only; write skipped")
+
+// Commands that modify Redis and are skipped while the cache is read-only
+var redisWriteCommands = map[string]bool{
+	"set": true, "setnx": true, "setex": true, "getset": true, "del": true, "unlink": true,
+	"expire": true, "pexpire": true, "persist": true, "incr": true, "incrby": true,
+	"zadd": true, "zincrby": true, "zrem": true, "zremrangebyscore": true, "zremrangebyrank": true,
+	"hset": true, "hdel": true, "hincrby": true, "lpush": true, "rpush": true, "ltrim": true,
+	"eval": true, "evalsha": true, "publish": true,
+}
+
+// cacheReadOnlyState tracks whether Redis has rejected writes with READONLY,
+// as happens when the client ends up talking to a replica after a failover.
+// While set, writes are skipped up front; after the recheck interval one
+// write is let through to see whether Redis is writable again.
+type cacheReadOnlyState struct {
+	mu    sync.Mutex
+	since time.Time // zero while writable
+	probe time.Time // when the next write may be attempted
+}
+
+var cacheReadOnly = &cacheReadOnlyState{}
+
+func (s *cacheReadOnlyState) active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.since.IsZero()
+}
+
+// Whether a write may be sent now; lets a single probe through per interval
+func (s *cacheReadOnlyState) allowWrite() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.since.IsZero() {
+		return true
+	}
+	now := time.Now()
+	if now.Before(s.probe) {
+		return false
+	}
+	s.probe = now.Add(config.CacheReadOnlyRecheck)
+	return true
+}
+
+func (s *cacheReadOnlyState) observe(cmd redis.Cmder) {
+	if !redisWriteCommands[cmd.Name()] {
+		return
+	}
+	err := cmd.Err()
+	if err != nil && err != errCacheReadOnly && strings.HasPrefix(err.Error(), "READONLY") {
+		s.mu.Lock()
+		if s.since.IsZero() {
+			s.since = time.Now()
+			s.probe = s.since.Add(config.CacheReadOnlyRecheck)
+			log.Printf("WARNING: Redis rejected a write as READONLY (replica after failover?); skipping cache writes, rechecking every %s", config.CacheReadOnlyRecheck)
+		}
+		s.mu.Unlock()
+		return
+	}
+	if err == nil || err == redis.Nil {
+		s.mu.Lock()
+		if !s.since.IsZero() {
+			log.Printf("Redis accepted a write again after being read-only for %s; resuming cache writes", time.Since(s.since).Round(time.Second))
+			s.since = time.Time{}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// readOnlyHook is a go-redis hook that short-circuits writes while the cache
+// is read-only and watches replies for READONLY errors
+type readOnlyHook struct{}
+
+func (readOnlyHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if redisWriteCommands[cmd.Name()] && !cacheReadOnly.allowWrite() {
+		return ctx, errCacheReadOnly
+	}
+	return ctx, nil
+}
+
+func (readOnlyHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	cacheReadOnly.observe(cmd)
+	return nil
+}
+
+func (readOnlyHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		if redisWriteCommands[cmd.Name()] {
+			if !cacheReadOnly.allowWrite() {
+				return ctx, errCacheReadOnly
+			}
+			break
+		}
+	}
+	return ctx, nil
+}
+
+func (readOnlyHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		cacheReadOnly.observe(cmd)
+	}
+	return nil
+}
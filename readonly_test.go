@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// replicaHook fails writes the way a read-only replica does, while failing is
+// set, and counts the writes that reach it
+type replicaHook struct {
+	failing int32
+	writes  int32
+}
+
+func (h *replicaHook) check(cmd redis.Cmder) error {
+	if !redisWriteCommands[cmd.Name()] {
+		return nil
+	}
+	atomic.AddInt32(&h.writes, 1)
+	if atomic.LoadInt32(&h.failing) == 1 {
+		return errors.New("READONLY You can't write against a read only replica.")
+	}
+	return nil
+}
+
+func (h *replicaHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, h.check(cmd)
+}
+
+func (h *replicaHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+func (h *replicaHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		if err := h.check(cmd); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func (h *replicaHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestReadOnlyCacheSkipsWritesWhileReadsContinue(t *testing.T) {
+	_, h := setupTest(t)
+	config.CacheReadOnlyRecheck = 50 * time.Millisecond
+	replica := &replicaHook{failing: 1}
+	redisClient.AddHook(replica)
+
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET against a read-only replica: got %d", w.Code)
+	}
+	var stats ServiceStats
+	decodeBody(t, do(h, "GET", "/stats", ""), &stats)
+	if !stats.CacheReadOnly {
+		t.Fatal("cache_readonly not reported after a READONLY error")
+	}
+
+	writes := atomic.LoadInt32(&replica.writes)
+	for i := 0; i < 3; i++ {
+		var p Product
+		w := do(h, "GET", "/product/2", "")
+		decodeBody(t, w, &p)
+		if w.Code != http.StatusOK || p.Name != "Banana" {
+			t.Fatalf("read while read-only: got %d %+v", w.Code, p)
+		}
+	}
+	if n := atomic.LoadInt32(&replica.writes) - writes; n != 0 {
+		t.Fatalf("%d writes sent to Redis while it was read-only", n)
+	}
+
+	// After the recheck interval a write is tried again and succeeds
+	atomic.StoreInt32(&replica.failing, 0)
+	time.Sleep(config.CacheReadOnlyRecheck)
+	do(h, "GET", "/product/3", "")
+	if cacheReadOnly.active() {
+		t.Fatal("still read-only after Redis accepted a write")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// productResponse is a serialized GET /product/{id} body, reused for
+// identical requests arriving within RESPONSE_DEDUP_WINDOW
+type productResponse struct {
+	contentType string
+	body        []byte
+	expiresAt   time.Time
+}
+
+// responseDedupCache sits in front of the data cache and holds encoded
+// responses rather than products, so a burst of identical GETs for one ID is
+// loaded and serialized once. Concurrent misses for the same key are
+// coalesced: one request builds the body and the rest wait for it.
+type responseDedupCache struct {
+	mu       sync.Mutex
+	entries  map[string]productResponse
+	inflight map[string]chan struct{} // closed when the build finishes
+}
+
+var productResponses = &responseDedupCache{
+	entries:  map[string]productResponse{},
+	inflight: map[string]chan struct{}{},
+}
+
+func productResponseKey(id int, contentType string) string {
+	return strconv.Itoa(id) + "|" + contentType
+}
+
+// Return the cached response for key, or build it with fill. Only the
+// builder's result is stored; errors are returned to the builder alone and
+// waiters retry on their own. Waiters give up when ctx ends.
+func (c *responseDedupCache) get(ctx context.Context, key string, fill func() (productResponse, error)) (productResponse, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && time.Now().Before(entry.expiresAt) {
+		c.mu.Unlock()
+		return entry, nil
+	}
+	if done, busy := c.inflight[key]; busy {
+		c.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return productResponse{}, ctx.Err()
+		}
+		c.mu.Lock()
+		entry, ok := c.entries[key]
+		c.mu.Unlock()
+		if ok && time.Now().Before(entry.expiresAt) {
+			return entry, nil
+		}
+		// The builder failed or the entry was dropped; take our own turn
+		return fill()
+	}
+	done := make(chan struct{})
+	c.inflight[key] = done
+	c.mu.Unlock()
+
+	resp, err := fill()
+	c.mu.Lock()
+	if err == nil {
+		resp.expiresAt = time.Now().Add(config.ResponseDedupWindow)
+		c.entries[key] = resp
+	}
+	delete(c.inflight, key)
+	c.mu.Unlock()
+	close(done)
+	c.sweep()
+	return resp, err
+}
+
+// Discard cached responses for the given products
+func (c *responseDedupCache) drop(ids []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		for _, ct := range productContentTypes {
+			delete(c.entries, productResponseKey(id, ct))
+		}
+	}
+}
+
+// Remove expired entries so one-off IDs don't accumulate
+func (c *responseDedupCache) sweep() {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Utility - serialize a product the way writeProduct would
+func encodeProductResponse(product Product, contentType string) (productResponse, error) {
+	if contentType == contentTypeProtobuf {
+		raw, err := proto.Marshal(productToProto(product))
+		if err != nil {
+			return productResponse{}, err
+		}
+		return productResponse{contentType: contentTypeProtobuf, body: raw}, nil
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(product); err != nil {
+		return productResponse{}, err
+	}
+	return productResponse{contentType: contentTypeJSON, body: buf.Bytes()}, nil
+}
+
+func writeProductResponse(w http.ResponseWriter, resp productResponse) {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", resp.contentType)
+	w.Write(resp.body)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBurstReusesOneSerializedResponse(t *testing.T) {
+	mr, h := setupTest(t)
+	config.ResponseDedupWindow = time.Second
+
+	// Hold the DB so the first request is still building while the rest arrive
+	fakeDBLock.Lock()
+	var wg sync.WaitGroup
+	bodies := make([]string, 20)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := do(h, "GET", "/product/1", "")
+			if w.Code != http.StatusOK {
+				t.Errorf("request %d: got %d", i, w.Code)
+			}
+			bodies[i] = w.Body.String()
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	fakeDBLock.Unlock()
+	wg.Wait()
+
+	for i, body := range bodies {
+		if body != bodies[0] {
+			t.Fatalf("request %d got %q, request 0 %q", i, body, bodies[0])
+		}
+	}
+	// Every load of the product counts towards its popularity
+	if loads, _ := mr.ZScore(redisPopularityKey, "1"); loads != 1 {
+		t.Fatalf("product loaded %v times for the burst, want once", loads)
+	}
+}
+
+func TestResponseDedupDroppedOnUpdate(t *testing.T) {
+	_, h := setupTest(t)
+	config.ResponseDedupWindow = time.Minute
+
+	do(h, "GET", "/product/1", "")
+	do(h, "PUT", "/product/1", `{"id":1,"name":"Green Apple","price":120}`)
+	var p Product
+	decodeBody(t, do(h, "GET", "/product/1", ""), &p)
+	if p.Name != "Green Apple" {
+		t.Fatalf("GET after an update: got %+v from the response cache", p)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// ShutdownHook runs at the start of a graceful shutdown, before the servers
+// stop accepting requests, so the instance can take itself out of rotation
+// (fail readiness, deregister from service discovery) while it can still
+// serve the traffic that arrives meanwhile
+type ShutdownHook interface {
+	BeforeShutdown(ctx context.Context) error
+}
+
+// ShutdownHookFunc adapts a function to ShutdownHook
+type ShutdownHookFunc func(ctx context.Context) error
+
+func (f ShutdownHookFunc) BeforeShutdown(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Hooks run on shutdown, in registration order; none by default
+var shutdownHooks []ShutdownHook
+
+func registerShutdownHook(h ShutdownHook) {
+	shutdownHooks = append(shutdownHooks, h)
+}
+
+// Run every shutdown hook. A failing hook is logged and doesn't stop the
+// others or the shutdown.
+func runShutdownHooks(ctx context.Context) {
+	for _, h := range shutdownHooks {
+		if err := h.BeforeShutdown(ctx); err != nil {
+			log.Printf("Shutdown hook failed: %v", err)
+		}
+	}
+}
+
+// Set once shutdown has begun; /readyz then fails
+var shuttingDown int32
+
+// Fail /readyz, then give load balancers SHUTDOWN_DRAIN_DELAY to notice
+// before the listeners close
+func drainReadinessHook(ctx context.Context) error {
+	atomic.StoreInt32(&shuttingDown, 1)
+	if config.ShutdownDrainDelay > 0 {
+		log.Printf("Readiness failing; waiting %s before closing listeners", config.ShutdownDrainDelay)
+		sleepCtx(ctx, config.ShutdownDrainDelay)
+	}
+	return nil
+}
+
+// Deregister CONSUL_SERVICE_ID from the local Consul agent at CONSUL_ADDR
+func consulDeregisterHook(ctx context.Context) error {
+	endpoint := config.ConsulAddr + "/v1/agent/service/deregister/" + url.PathEscape(config.ConsulServiceID)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("consul deregister: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("consul deregister: %s", resp.Status)
+	}
+	log.Printf("Deregistered %q from Consul", config.ConsulServiceID)
+	return nil
+}
+
+// Utility - register the built-in shutdown hooks the configuration asks for.
+// Deregistration goes first so the drain delay also covers it.
+func registerConfiguredShutdownHooks() {
+	if config.ConsulAddr != "" && config.ConsulServiceID != "" {
+		registerShutdownHook(ShutdownHookFunc(consulDeregisterHook))
+	}
+	registerShutdownHook(ShutdownHookFunc(drainReadinessHook))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestShutdownHooksRunBeforeServerShutdown(t *testing.T) {
+	_, h := setupTest(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: h}
+	go srv.Serve(lis)
+
+	var mu sync.Mutex
+	var order []string
+	note := func(step string) {
+		mu.Lock()
+		order = append(order, step)
+		mu.Unlock()
+	}
+	shutdownRan := make(chan struct{})
+	srv.RegisterOnShutdown(func() {
+		note("server shutdown")
+		close(shutdownRan)
+	})
+	registerShutdownHook(ShutdownHookFunc(func(ctx context.Context) error {
+		// Still serving while the hook runs
+		resp, err := http.Get("http://" + lis.Addr().String() + "/healthz")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		note("hook")
+		return nil
+	}))
+	registerShutdownHook(ShutdownHookFunc(drainReadinessHook))
+
+	shutdownServers(context.Background(), srv, nil)
+	<-shutdownRan
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"hook", "server shutdown"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("shutdown order: got %v, want %v", order, want)
+	}
+	if w := do(h, "GET", "/readyz", ""); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz after shutdown began: got %d", w.Code)
+	}
+}
+
+func TestConsulDeregisteredOnShutdown(t *testing.T) {
+	setupTest(t)
+	var gotMethod, gotPath string
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath = r.Method, r.URL.Path
+	}))
+	defer consul.Close()
+	config.ConsulAddr = consul.URL
+	config.ConsulServiceID = "products-1"
+
+	registerConfiguredShutdownHooks()
+	runShutdownHooks(context.Background())
+	if gotMethod != http.MethodPut || gotPath != "/v1/agent/service/deregister/products-1" {
+		t.Fatalf("consul got %s %s", gotMethod, gotPath)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// cacheSnapshot is the on-disk form of the product cache, written on
+// shutdown and loaded on startup so a restart doesn't start cold
+type cacheSnapshot struct {
+	Schema  int                  `json:"schema"`
+	TakenAt time.Time            `json:"taken_at"`
+	Entries []cacheSnapshotEntry `json:"entries"`
+}
+
+type cacheSnapshotEntry struct {
+	Key   string        `json:"key"`
+	Value string        `json:"value"`
+	TTL   time.Duration `json:"ttl"` // remaining when the snapshot was taken
+}
+
+// Utility - whether key holds a product under the given schema (as opposed
+// to a hits counter, populate lock or another schema's entry)
+func isProductValueKey(key string, schema int) bool {
+	prefix := strings.TrimSuffix(redisProductKeyForSchema(0, schema), "0")
+	rest := strings.TrimPrefix(key, prefix)
+	if rest == key || rest == "" {
+		return false
+	}
+	_, err := strconv.Atoi(rest)
+	return err == nil
+}
+
+// Write the current schema's product entries, with their remaining TTLs, to
+// path. Tombstones and keys without a TTL are left out.
+func dumpCacheSnapshot(ctx context.Context, path string) error {
+	snap := cacheSnapshot{Schema: config.CacheSchemaVersion, TakenAt: sharedNow(ctx), Entries: []cacheSnapshotEntry{}}
+	var cursor uint64
+	for {
+		keys, next, err := redisClient.Scan(ctx, cursor, redisProductKeyPrefix+"*", 100).Result()
+		if err != nil {
+			return fmt.Errorf("scan: %w", err)
+		}
+		var values []string
+		for _, key := range keys {
+			if isProductValueKey(key, snap.Schema) {
+				values = append(values, key)
+			}
+		}
+		if len(values) > 0 {
+			getCmds := make([]*redis.StringCmd, len(values))
+			ttlCmds := make([]*redis.DurationCmd, len(values))
+			redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for i, key := range values {
+					getCmds[i] = pipe.Get(ctx, key)
+					ttlCmds[i] = pipe.PTTL(ctx, key)
+				}
+				return nil
+			})
+			for i, key := range values {
+				value, err := getCmds[i].Result()
+				ttl, _ := ttlCmds[i].Result()
+				if err != nil || value == redisTombstoneValue || ttl <= 0 {
+					continue
+				}
+				snap.Entries = append(snap.Entries, cacheSnapshotEntry{Key: key, Value: value, TTL: ttl})
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	raw, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	// Write beside the target and rename, so a crash mid-write can't leave a
+	// truncated snapshot behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	log.Printf("Cache snapshot: saved %d entries to %s", len(snap.Entries), path)
+	return nil
+}
+
+// Load a snapshot written by dumpCacheSnapshot. Each entry keeps only what
+// was left of its TTL (minus the time since the snapshot), so nothing lives
+// longer than it would have without the restart, and existing keys are
+// never overwritten. A missing, corrupt or other-schema snapshot is skipped.
+func restoreCacheSnapshot(ctx context.Context, path string) {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("Cache snapshot: %s not found, starting cold", path)
+		return
+	}
+	if err != nil {
+		log.Printf("Cache snapshot: could not read %s: %v", path, err)
+		return
+	}
+	var snap cacheSnapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		log.Printf("Cache snapshot: ignoring corrupt %s: %v", path, err)
+		return
+	}
+	if snap.Schema != config.CacheSchemaVersion {
+		log.Printf("Cache snapshot: ignoring %s, written for schema v%d", path, snap.Schema)
+		return
+	}
+
+	// A snapshot stamped by a clock ahead of ours would otherwise extend TTLs
+	elapsed := sharedNow(ctx).Sub(snap.TakenAt)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	restored := 0
+	_, err = redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, e := range snap.Entries {
+			ttl := e.TTL - elapsed
+			if ttl <= 0 || !isProductValueKey(e.Key, snap.Schema) {
+				continue
+			}
+			pipe.SetNX(ctx, e.Key, e.Value, ttl)
+			restored++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Cache snapshot: restore from %s failed: %v", path, err)
+		return
+	}
+	log.Printf("Cache snapshot: restored %d of %d entries from %s", restored, len(snap.Entries), path)
+}
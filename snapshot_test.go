@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheSnapshotRoundTrip(t *testing.T) {
+	mr, h := setupTest(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	do(h, "GET", "/product/1", "")
+	do(h, "GET", "/product/2", "")
+	mr.Set(redisProductKey(3), redisTombstoneValue)
+	mr.SetTTL(redisProductKey(3), time.Minute)
+	want := map[string]string{}
+	for _, id := range []int{1, 2} {
+		want[redisProductKey(id)], _ = mr.Get(redisProductKey(id))
+	}
+
+	if err := dumpCacheSnapshot(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	mr.FlushAll()
+	restoreCacheSnapshot(ctx, path)
+
+	for key, value := range want {
+		if got, _ := mr.Get(key); got != value {
+			t.Fatalf("%s after restore: got %q, want %q", key, got, value)
+		}
+		if ttl := mr.TTL(key); ttl <= 0 || ttl > productCache.TTL() {
+			t.Fatalf("%s restored with TTL %v", key, ttl)
+		}
+	}
+	if keys := mr.Keys(); len(keys) != len(want) {
+		t.Fatalf("restored keys %v; want only the two product entries", keys)
+	}
+	var p Product
+	decodeBody(t, do(h, "GET", "/product/1", ""), &p)
+	if p.Name != "Apple" {
+		t.Fatalf("GET after restore: got %+v", p)
+	}
+}
+
+func TestCacheSnapshotRestoreKeepsExistingKeys(t *testing.T) {
+	mr, h := setupTest(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	do(h, "GET", "/product/1", "")
+	if err := dumpCacheSnapshot(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	mr.Set(redisProductKey(1), "newer")
+	restoreCacheSnapshot(ctx, path)
+	if got, _ := mr.Get(redisProductKey(1)); got != "newer" {
+		t.Fatalf("restore overwrote an existing key with %q", got)
+	}
+}
+
+func TestCacheSnapshotMissingOrCorrupt(t *testing.T) {
+	mr, _ := setupTest(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	restoreCacheSnapshot(ctx, filepath.Join(dir, "missing.json"))
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte(`{"schema":1,"entries":[{"key":`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	restoreCacheSnapshot(ctx, corrupt)
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatalf("keys after skipped snapshots: %v", keys)
+	}
+}
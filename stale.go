@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// staleWarning is the RFC 7234 warning attached to stale responses
+const staleWarning = `110 - "Response is Stale"`
+
+type staleEntry struct {
+	product  Product
+	storedAt time.Time
+}
+
+// staleStore keeps the last good copy of each product served by this
+// instance, as a fallback for when neither Redis nor the DB can answer.
+// Entries are dropped when the product changes, so a fallback is never a
+// version that was knowingly replaced or deleted.
+type staleStore struct {
+	mu      sync.Mutex
+	entries map[int]staleEntry
+}
+
+var staleProducts = &staleStore{entries: map[int]staleEntry{}}
+
+func (s *staleStore) put(product Product) {
+	if !config.ServeStaleOnError {
+		return
+	}
+	s.mu.Lock()
+	s.entries[product.ID] = staleEntry{product: product, storedAt: time.Now()}
+	s.mu.Unlock()
+}
+
+// The last good copy of a product, if younger than STALE_MAX_AGE
+func (s *staleStore) get(id int) (Product, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok || time.Since(entry.storedAt) > config.StaleMaxAge {
+		return Product{}, false
+	}
+	return entry.product, true
+}
+
+func (s *staleStore) drop(ids []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.entries, id)
+	}
+}
+
+// Utility - after a failed read, serve the last good copy of the product
+// with a Warning header, if SERVE_STALE_ON_ERROR allows and one exists
+func serveStaleProduct(w http.ResponseWriter, r *http.Request, id int) bool {
+	if !config.ServeStaleOnError {
+		return false
+	}
+	product, ok := staleProducts.get(id)
+	if !ok {
+		return false
+	}
+	metrics.IncrCounter("product_stale_responses_total", nil)
+	w.Header().Set("Warning", staleWarning)
+	writeCacheDiagnostics(w, r, readInfo{Source: "stale"})
+	writeProduct(w, r, product)
+	return true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStaleServedWhenRedisAndDBFail(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		mr, h := setupTest(t)
+		config.ServeStaleOnError = enabled
+		config.DBLockTimeout = 50 * time.Millisecond
+		if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK {
+			t.Fatalf("enabled=%v: first read = %d", enabled, w.Code)
+		}
+
+		// Redis down, and the DB too busy to answer
+		mr.Close()
+		fakeDBLock.Lock()
+		w := do(h, "GET", "/product/1", "")
+		fakeDBLock.Unlock()
+
+		if !enabled {
+			if w.Code != http.StatusServiceUnavailable || w.Header().Get("Warning") != "" {
+				t.Errorf("disabled: got %d, Warning %q", w.Code, w.Header().Get("Warning"))
+			}
+			continue
+		}
+		if w.Code != http.StatusOK || w.Header().Get("Warning") != staleWarning {
+			t.Fatalf("enabled: got %d, Warning %q", w.Code, w.Header().Get("Warning"))
+		}
+		var p Product
+		decodeBody(t, w, &p)
+		if p.ID != 1 || p.Name != "Apple" {
+			t.Fatalf("stale body: got %+v", p)
+		}
+	}
+}
+
+func TestStaleCopyDroppedOnWrite(t *testing.T) {
+	mr, h := setupTest(t)
+	config.ServeStaleOnError = true
+	config.DBLockTimeout = 50 * time.Millisecond
+	do(h, "GET", "/product/1", "")
+	do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":120}`)
+	if w := do(h, "DELETE", "/product/1", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d", w.Code)
+	}
+
+	mr.Close()
+	fakeDBLock.Lock()
+	w := do(h, "GET", "/product/1", "")
+	fakeDBLock.Unlock()
+	if w.Code == http.StatusOK {
+		t.Fatalf("served a stale copy of a deleted product: %s", w.Body)
+	}
+}
+
+func TestStaleCopyExpires(t *testing.T) {
+	_, h := setupTest(t)
+	config.ServeStaleOnError = true
+	config.StaleMaxAge = time.Millisecond
+	do(h, "GET", "/product/1", "")
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := staleProducts.get(1); ok {
+		t.Fatal("stale copy outlived STALE_MAX_AGE")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// Cleared while STARTUP_GATE holds requests back during initialization
+var serviceReady int32 = 1
+
+// Connect to Redis and restore state before serving: ping, seed the ID
+// sequence, load the cache epoch, restore the cache snapshot if one is configured and warm the
+// cache for a cold start
+func initializeService(ctx context.Context) error {
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("could not connect to Redis: %w", err)
+	}
+	if err := initProductIDSeq(ctx); err != nil {
+		return fmt.Errorf("could not initialize product id sequence: %w", err)
+	}
+	if err := refreshCacheEpoch(ctx); err != nil {
+		return fmt.Errorf("could not load cache epoch: %w", err)
+	}
+	if config.CacheSnapshotPath != "" {
+		restoreCacheSnapshot(ctx, config.CacheSnapshotPath)
+	}
+	warmColdStart(ctx)
+	return nil
+}
+
+// Middleware - until initialization finishes, answer everything except
+// /healthz with 503 and Retry-After, so nothing reaches a half-ready service
+func startupGateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&serviceReady) == 0 && r.URL.Path != "/healthz" {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Service starting", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStartupGateHoldsRequestsUntilInitialized(t *testing.T) {
+	_, h := setupTest(t)
+	atomic.StoreInt32(&serviceReady, 0)
+
+	for _, path := range []string{"/product/1", "/products", "/readyz"} {
+		w := do(h, "GET", path, "")
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+			t.Fatalf("%s before initialization: got %d, Retry-After %q", path, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+	if w := do(h, "GET", "/healthz", ""); w.Code != http.StatusOK {
+		t.Fatalf("/healthz before initialization: got %d", w.Code)
+	}
+
+	if err := initializeService(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&serviceReady, 1)
+	if w := do(h, "GET", "/product/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("after initialization: got %d", w.Code)
+	}
+}
+
+func TestInitializeServiceFailsWithoutRedis(t *testing.T) {
+	mr, _ := setupTest(t)
+	mr.Close()
+	if err := initializeService(context.Background()); err == nil {
+		t.Fatal("initialized with Redis down")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Process-wide cache counters reported on /stats
+var (
+	statCacheHits   int64
+	statCacheMisses int64
+)
+
+// ServiceStats is the process-wide summary served on /stats
+type ServiceStats struct {
+	Products      int   `json:"products"`
+	CacheHits     int64 `json:"cache_hits"`
+	CacheMisses   int64 `json:"cache_misses"`
+	CacheReadOnly bool  `json:"cache_readonly"`
+	CleanerPaused bool  `json:"cleaner_paused"`
+	BreakerOpen   bool  `json:"breaker_open"`
+
+	RedisMemory *RedisMemoryStats `json:"redis_memory,omitempty"` // once polled
+}
+
+// Handler - GET /stats
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := rlockDB(r.Context()); err != nil {
+		writeDBLockError(w)
+		return
+	}
+	products := len(fakeProductDB)
+	fakeDBLock.RUnlock()
+
+	stats := ServiceStats{
+		Products:      products,
+		CacheHits:     atomic.LoadInt64(&statCacheHits),
+		CacheMisses:   atomic.LoadInt64(&statCacheMisses),
+		CacheReadOnly: cacheReadOnly.active(),
+		CleanerPaused: atomic.LoadInt32(&cleanerPaused) == 1,
+		BreakerOpen:   redisBreaker.isOpen(),
+		RedisMemory:   redisMemory.snapshot(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Product operations shared by the HTTP and gRPC front ends. They combine
+// the fake DB with the Redis cache and return errProductNotFound,
+// errProductLimitReached, errDBLockTimeout, a *ValidationError or a
+// *CatalogValueError for the callers to map.
+
+var (
+	errProductNotFound     = errors.New("product not found")
+	errProductLimitReached = errors.New("product limit reached")
+)
+
+// Per-product write generations, set under fakeDBLock by every write. A
+// reader notes the generation alongside the DB row; if it changed by the
+// time the reader populates the cache, a write (e.g. a DELETE) raced the
+// read and the populate is abandoned. Tombstones cover the same race across
+// instances; this covers it even with TOMBSTONE_TTL=0 or a populate slower
+// than the tombstone.
+//
+// Generations come from one counter shared by all products, so only
+// existing products need an entry: a deleted product's is dropped, and a
+// reader still holding it sees 0, or the larger generation of a product
+// recreated under the same ID.
+var (
+	productGenerations = map[int]uint64{}
+	lastGeneration     uint64
+)
+
+// Products present from the start need a generation too, or a reader of
+// one couldn't tell it apart from its deletion
+func init() {
+	for id := range fakeProductDB {
+		bumpGenerationLocked(id)
+	}
+}
+
+// Utility - record a write to a product. Callers hold fakeDBLock for writing.
+func bumpGenerationLocked(id int) {
+	lastGeneration++
+	productGenerations[id] = lastGeneration
+}
+
+// Utility - record a product's removal. Callers hold fakeDBLock for writing.
+func forgetGenerationLocked(id int) {
+	delete(productGenerations, id)
+}
+
+// Utility - a product's current write generation, waiting for the DB lock
+// no longer than DB_LOCK_TIMEOUT
+func productGeneration(ctx context.Context, id int) (uint64, error) {
+	if err := rlockDB(ctx); err != nil {
+		return 0, err
+	}
+	defer fakeDBLock.RUnlock()
+	return productGenerations[id], nil
+}
+
+// Utility - whether product id is still at write generation gen. If the DB
+// lock can't be had in time the answer is no, so a populate is skipped
+// rather than left waiting on a stuck writer.
+func generationCurrent(ctx context.Context, id int, gen uint64) bool {
+	current, err := productGeneration(ctx, id)
+	return err == nil && current == gen
+}
+
+// Cache-aside read: serve from Redis when possible, otherwise load from the
+// DB and populate the cache. bypass skips the lookup and overwrites the entry.
+func loadProduct(ctx context.Context, id int, bypass bool) (Product, error) {
+	product, _, err := loadProductWithInfo(ctx, id, bypass)
+	return product, err
+}
+
+// readInfo describes how loadProduct answered, for diagnostics
+type readInfo struct {
+	Cache  string        // "HIT", "MISS" or "BYPASS"
+	Source string        // "cache" or "db"
+	TTL    time.Duration // remaining cache TTL on a hit; 0 if unknown
+	// Why the DB was consulted: "miss", "cache_error", "corrupt", "epoch",
+	// "max_age", "circuit_open", "bypass" or "tombstone". Empty on a hit.
+	FallbackReason string
+}
+
+// Utility - the fallback reason for a failed cache lookup
+func dbFallbackReason(err error) string {
+	switch {
+	case err == redis.Nil:
+		return "miss"
+	case errors.Is(err, errCircuitOpen):
+		return "circuit_open"
+	default:
+		return "cache_error"
+	}
+}
+
+// Like loadProduct, also reporting where the product came from
+func loadProductWithInfo(ctx context.Context, id int, bypass bool) (Product, readInfo, error) {
+	info := readInfo{Cache: "MISS", Source: "db"}
+	if bypass {
+		info.Cache = "BYPASS"
+	}
+	redisKey := redisProductKey(id)
+	redisHitsKey := redisProductHitsKey(id)
+	var product Product
+
+	cacheHit := false
+	tombstoned := false
+	corrupt := false
+	recordProductAccess(ctx, id)
+	canary := !bypass && sampleCanary()
+	var canaryGen uint64
+	if canary {
+		var err error
+		if canaryGen, err = productGeneration(ctx, id); err != nil {
+			canary = false
+		}
+	}
+
+	var data string
+	var err error
+	if bypass {
+		// Caller asked for a fresh read; skip the lookup and overwrite the entry below
+		err = redis.Nil
+		info.FallbackReason = "bypass"
+	} else {
+		data, err = redisClient.Get(ctx, redisKey).Result()
+		if err != nil {
+			info.FallbackReason = dbFallbackReason(err)
+		}
+	}
+	if err == nil && data == redisTombstoneValue {
+		// Recently mutated; read through to the DB and leave the tombstone alone
+		tombstoned = true
+		info.FallbackReason = "tombstone"
+	} else if err == nil {
+		if product, err = decodeCachedProductFor(data, config.CacheSchemaVersion, id); err != nil {
+			// A stale-epoch or too-old entry is overwritten just like a
+			// corrupt one
+			corrupt = true
+			switch {
+			case errors.Is(err, errStaleCacheEpoch):
+				info.FallbackReason = "epoch"
+			case errors.Is(err, errCacheEntryTooOld):
+				info.FallbackReason = "max_age"
+			default:
+				info.FallbackReason = "corrupt"
+			}
+		} else {
+			cacheHit = true
+			// Increment hit count, reading the remaining TTL in the same round trip.
+			// In sliding mode popularity is the number of hits within the window.
+			var hitsCmd, windowCmd *redis.IntCmd
+			var ttlCmd *redis.DurationCmd
+			var now time.Time
+			if config.HitsMode == hitsModeSliding {
+				now = sharedNow(ctx)
+			}
+			redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				hitsCmd = pipe.Incr(ctx, redisHitsKey)
+				if config.HitsMode == hitsModeSliding {
+					windowCmd = queueWindowHit(ctx, pipe, redisProductRecentHitsKey(id), now)
+				}
+				ttlCmd = pipe.TTL(ctx, redisKey)
+				return nil
+			})
+			hits, _ := hitsCmd.Result()
+			if windowCmd != nil {
+				hits, _ = windowCmd.Result()
+			}
+			remaining, _ := ttlCmd.Result()
+
+			info.TTL = remaining
+			if hits >= popularThreshold {
+				notePopularHit(ctx, product)
+			}
+			if hits >= popularThreshold && ttlRefreshDue(remaining) {
+				// Refresh TTL for popular items. The hit count only gates the
+				// refresh; the TTL is always the fixed product cache TTL, so no
+				// count, however large, can stretch it.
+				redisClient.Expire(ctx, redisKey, productCache.TTL())
+				redisClient.Expire(ctx, redisHitsKey, productCache.TTL())
+				info.TTL = productCache.TTL()
+				metrics.IncrCounter("product_ttl_refresh_total", nil)
+			}
+		}
+	}
+	if err == redis.Nil && !bypass {
+		product, cacheHit = readLegacyCachedProduct(ctx, id)
+	}
+	if cacheHit {
+		atomic.AddInt64(&statCacheHits, 1)
+		metrics.IncrCounter("product_cache_requests_total", Labels{"result": "hit"})
+		staleProducts.put(product)
+		info.Cache, info.Source, info.FallbackReason = "HIT", "cache", ""
+		if canary {
+			go checkCanary(id, product, canaryGen)
+		}
+		return product, info, nil
+	}
+	atomic.AddInt64(&statCacheMisses, 1)
+	metrics.IncrCounter("product_cache_requests_total", Labels{"result": "miss"})
+	metrics.IncrCounter("product_db_fallback_total", Labels{"reason": info.FallbackReason})
+
+	// Not found or not deserialized; get from DB
+	if inColdStartGrace() && !bypass && !tombstoned && !corrupt {
+		res, err := loadColdStart(ctx, id)
+		if err != nil {
+			return Product{}, info, err
+		}
+		if res.fromCache {
+			info.Source = "cache"
+		}
+		staleProducts.put(res.product)
+		return res.product, info, nil
+	}
+	if err := rlockDB(ctx); err != nil {
+		return Product{}, info, err
+	}
+	dbProduct, ok := fakeProductDB[id]
+	if ok {
+		product = *dbProduct
+	}
+	gen := productGenerations[id]
+	fakeDBLock.RUnlock()
+	if !ok {
+		return Product{}, info, errProductNotFound
+	}
+
+	if !tombstoned {
+		populateProductCache(ctx, product, gen, corrupt || bypass)
+	}
+	staleProducts.put(product)
+	return product, info, nil
+}
+
+// Read a product without cache side effects: a valid cached copy is used if
+// present, otherwise the DB, but nothing is populated and no hit is counted
+func peekProduct(ctx context.Context, id int) (Product, error) {
+	product, _, err := peekProductWithSource(ctx, id)
+	return product, err
+}
+
+// Like peekProduct, also reporting whether the copy came from "cache" or "db"
+func peekProductWithSource(ctx context.Context, id int) (Product, string, error) {
+	if data, err := redisClient.Get(ctx, redisProductKey(id)).Result(); err == nil && data != redisTombstoneValue {
+		if product, err := decodeCachedProductFor(data, config.CacheSchemaVersion, id); err == nil {
+			return product, "cache", nil
+		}
+	}
+	product, err := readProductFromDB(ctx, id)
+	return product, "db", err
+}
+
+// Read a product straight from the DB, ignoring the cache
+func readProductFromDB(ctx context.Context, id int) (Product, error) {
+	product, _, err := readProductWithGeneration(ctx, id)
+	return product, err
+}
+
+// Like readProductFromDB, also returning the write generation read with it
+func readProductWithGeneration(ctx context.Context, id int) (Product, uint64, error) {
+	if err := rlockDB(ctx); err != nil {
+		return Product{}, 0, err
+	}
+	defer fakeDBLock.RUnlock()
+	dbProduct, ok := fakeProductDB[id]
+	if !ok {
+		return Product{}, 0, errProductNotFound
+	}
+	return *dbProduct, productGenerations[id], nil
+}
+
+// Replace (or insert) a product, bumping its version, then update or
+// invalidate the cache per CACHE_UPDATE_MODE. In write_behind mode the DB
+// write is queued instead.
+func saveProduct(ctx context.Context, input Product) (Product, error) {
+	return saveProductUnless(ctx, input, nil)
+}
+
+// Like saveProduct, but failing with errWriteSuperseded if superseded
+// reports that a later write has replaced this one. It runs under the DB
+// lock, so no write can land between the check and the save.
+func saveProductUnless(ctx context.Context, input Product, superseded func() bool) (Product, error) {
+	input, err := validateProduct(input)
+	if err != nil {
+		return Product{}, err
+	}
+	// no_cache products can't be served from the cache until written
+	if config.CacheUpdateMode == cacheUpdateWriteBehind && !input.NoCache {
+		if superseded != nil && superseded() {
+			return Product{}, errWriteSuperseded
+		}
+		return saveProductWriteBehind(ctx, input)
+	}
+
+	if err := lockDB(ctx); err != nil {
+		return Product{}, err
+	}
+	if superseded != nil && superseded() {
+		fakeDBLock.Unlock()
+		return Product{}, errWriteSuperseded
+	}
+	updated, eventType, err := putProductLocked(input)
+	fakeDBLock.Unlock()
+	if err != nil {
+		return Product{}, err
+	}
+
+	afterSave(ctx, updated, eventType)
+	return updated, nil
+}
+
+// Store a validated product, bumping its version. Callers hold fakeDBLock
+// for writing. Returns the stored copy and "created" or "updated".
+func putProductLocked(input Product) (Product, string, error) {
+	if err := checkCatalogValueLocked(input); err != nil {
+		return Product{}, "", err
+	}
+	version := 1
+	eventType := "created"
+	if existing, ok := fakeProductDB[input.ID]; ok {
+		version = existing.Version + 1
+		eventType = "updated"
+	} else if productLimitReachedLocked() {
+		return Product{}, "", errProductLimitReached
+	}
+	updated := Product{ID: input.ID, Name: input.Name, Price: input.Price, Version: version, NoCache: input.NoCache}
+	fakeProductDB[input.ID] = &updated
+	bumpGenerationLocked(input.ID)
+	return updated, eventType, nil
+}
+
+// Cache and event side effects of a save, run after the lock is released
+func afterSave(ctx context.Context, updated Product, eventType string) {
+	if config.CacheUpdateMode == cacheUpdateWriteThrough && !updated.NoCache {
+		writeThroughProductCache(ctx, updated)
+	} else {
+		// Invalidate related cache keys immediately after update; this also
+		// evicts a product just marked no_cache
+		invalidateProductCache(ctx, updated.ID)
+	}
+	publishProductEvent(eventType, updated.ID, &updated)
+}
+
+// Insert a new product under a freshly assigned ID
+func createProduct(ctx context.Context, input Product) (Product, error) {
+	input, err := validateProduct(input)
+	if err != nil {
+		return Product{}, err
+	}
+
+	reserved, err := reserveProductID(ctx)
+	if err != nil {
+		return Product{}, err
+	}
+
+	// Assign the next ID and insert under a single write lock. Computing the
+	// ID under a read lock and inserting later would let concurrent creates
+	// pick the same max+1.
+	if err := lockDB(ctx); err != nil {
+		return Product{}, err
+	}
+	created, err := insertProductLocked(input, reserved)
+	count := len(fakeProductDB)
+	fakeDBLock.Unlock()
+	if err != nil {
+		return Product{}, err
+	}
+	metrics.SetGauge("products_in_db", float64(count), nil)
+	publishProductEvent("created", created.ID, &created)
+	return created, nil
+}
+
+// Insert a validated product under a new ID (see assignProductID). Callers
+// hold fakeDBLock for writing.
+func insertProductLocked(input Product, reserved int) (Product, error) {
+	if productLimitReachedLocked() {
+		return Product{}, errProductLimitReached
+	}
+	if err := checkCatalogValueLocked(input); err != nil {
+		return Product{}, err
+	}
+	id, err := assignProductID(reserved)
+	if err != nil {
+		return Product{}, err
+	}
+	product := &Product{ID: id, Name: input.Name, Price: input.Price, Version: 1, NoCache: input.NoCache}
+	fakeProductDB[id] = product
+	bumpGenerationLocked(id)
+	return *product, nil
+}
+
+// Utility - whether MAX_PRODUCTS leaves no room for another product. Every
+// path that can add a product (POST, PUT to a new ID, bulk, write-behind)
+// checks it. Callers hold fakeDBLock.
+func productLimitReachedLocked() bool {
+	return config.MaxProducts > 0 && len(fakeProductDB) >= config.MaxProducts
+}
+
+// Remove a product and invalidate its cache entry
+func deleteProduct(ctx context.Context, id int, cond *versionPrecondition) error {
+	// A pending write-behind write is the version clients have seen
+	pendingVersion, pending := pendingWriteBehindVersion(ctx, id)
+	if cond != nil && pending && !cond.matches(pendingVersion) {
+		return errPreconditionFailed
+	}
+	discarded := discardWriteBehind(ctx, id)
+	if err := lockDB(ctx); err != nil {
+		return err
+	}
+	existing, ok := fakeProductDB[id]
+	if cond != nil && ok && !pending && !cond.matches(existing.Version) {
+		fakeDBLock.Unlock()
+		return errPreconditionFailed
+	}
+	if ok {
+		delete(fakeProductDB, id)
+		forgetGenerationLocked(id)
+		// An async write queued before the delete mustn't bring it back
+		supersedeQueuedWrites(ctx, []int{id})
+	}
+	ok = ok || discarded
+	count := len(fakeProductDB)
+	fakeDBLock.Unlock()
+	if !ok {
+		return errProductNotFound
+	}
+	metrics.SetGauge("products_in_db", float64(count), nil)
+	afterDelete(ctx, id)
+	return nil
+}
+
+// Cache and event side effects of a delete, run after the lock is released
+func afterDelete(ctx context.Context, id int) {
+	saveDeletedHits(ctx, id)
+	invalidateProductCache(ctx, id)
+	publishProductEvent("deleted", id, nil)
+}
+
+// All products matching the filter, ordered by ID. Filters run on a
+// snapshot, never on the live map.
+func queryProducts(ctx context.Context, filter productFilter) ([]Product, error) {
+	products, err := snapshotProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matched := []Product{}
+	for _, p := range products {
+		if filter.match(p) {
+			matched = append(matched, p)
+		}
+	}
+	return matched, nil
+}
+
+// Utility - the limit/offset window of a result set
+func paginate(products []Product, limit, offset int) []Product {
+	if offset >= len(products) {
+		return []Product{}
+	}
+	page := products[offset:]
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	return page
+}
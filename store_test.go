@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMaxProductsAppliesToPutOfNewID(t *testing.T) {
+	_, h := setupTest(t)
+	config.MaxProducts = 3
+
+	if w := do(h, "PUT", "/product/10", `{"id":10,"name":"Date","price":5}`); w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("PUT of a new ID at the cap: got %d, want 507", w.Code)
+	}
+	if _, ok := dbProduct(10); ok {
+		t.Fatal("product 10 was created past the cap")
+	}
+	if w := do(h, "PUT", "/product/1", `{"id":1,"name":"Green Apple","price":5}`); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT of an existing ID at the cap: got %d, want 204", w.Code)
+	}
+}
+
+func TestMaxProductsAppliesToBulkUpdateOfNewID(t *testing.T) {
+	_, h := setupTest(t)
+	config.MaxProducts = 3
+
+	config.BulkMode = bulkBestEffort
+
+	w := do(h, "POST", "/products/bulk", `[{"op":"update","product":{"id":10,"name":"Date","price":5}}]`)
+	var resp struct {
+		Results []BulkItemResult `json:"results"`
+	}
+	decodeBody(t, w, &resp)
+	if len(resp.Results) != 1 || resp.Results[0].Status != http.StatusInsufficientStorage {
+		t.Fatalf("bulk update of a new ID at the cap: got %s", w.Body.String())
+	}
+}
+
+func TestMaxProductsAppliesToWriteBehind(t *testing.T) {
+	setupTest(t)
+	config.MaxProducts = 3
+	config.CacheUpdateMode = cacheUpdateWriteBehind
+	ctx := context.Background()
+
+	if _, err := saveProduct(ctx, Product{ID: 10, Name: "Date", Price: 5}); !errors.Is(err, errProductLimitReached) {
+		t.Fatalf("queueing a new product at the cap: got %v", err)
+	}
+	raw := `{"id":11,"name":"Elder","price":5,"version":1}`
+	redisClient.HSet(ctx, redisWriteBehindPendingKey, "11", raw)
+	if err := storeWriteBehindProduct(ctx, Product{ID: 11, Name: "Elder", Price: 5, Version: 1}, raw); !errors.Is(err, errProductLimitReached) {
+		t.Fatalf("applying a new product at the cap: got %v", err)
+	}
+}
+
+func TestPopulateSkipsWhenDBLockTimesOut(t *testing.T) {
+	mr, _ := setupTest(t)
+	config.DBLockTimeout = 20 * time.Millisecond
+	ctx := context.Background()
+	product, gen, err := readProductWithGeneration(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A writer stuck holding the lock must not hang the populate
+	fakeDBLock.Lock()
+	defer fakeDBLock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		populateProductCache(ctx, product, gen, false)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("populate blocked on the DB lock past DB_LOCK_TIMEOUT")
+	}
+	if mr.Exists(redisProductKey(1)) {
+		t.Fatal("populate wrote the cache without checking the write generation")
+	}
+}
+
+func TestDeleteOfUnknownIDKeepsNoGeneration(t *testing.T) {
+	_, h := setupTest(t)
+	for id := 100; id < 200; id++ {
+		if w := do(h, "DELETE", fmt.Sprintf("/product/%d", id), ""); w.Code != http.StatusNotFound {
+			t.Fatalf("DELETE /product/%d: got %d, want 404", id, w.Code)
+		}
+	}
+	if w := do(h, "DELETE", "/product/1", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /product/1: got %d, want 204", w.Code)
+	}
+	if len(productGenerations) != len(fakeProductDB) {
+		t.Fatalf("%d generations kept for %d products", len(productGenerations), len(fakeProductDB))
+	}
+}
+
+func TestPopulateAbandonedAfterDeleteAndRecreate(t *testing.T) {
+	mr, _ := setupTest(t)
+	ctx := context.Background()
+	product, gen, err := readProductWithGeneration(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A delete alone, then a delete and a recreate under the same ID, both
+	// land between the read and the populate
+	if err := deleteProduct(ctx, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	populateProductCache(ctx, product, gen, true)
+	if v, _ := mr.Get(redisProductKey(1)); v != "" && v != redisTombstoneValue {
+		t.Fatalf("a deleted product was cached: %s", v)
+	}
+	if _, err := saveProduct(ctx, Product{ID: 1, Name: "Apricot", Price: 80}); err != nil {
+		t.Fatal(err)
+	}
+	populateProductCache(ctx, product, gen, true)
+	if v, _ := mr.Get(redisProductKey(1)); v != "" && v != redisTombstoneValue {
+		t.Fatalf("the pre-delete copy was cached over the recreated product: %s", v)
+	}
+}
+
+func TestReadRacingUpdateSeesTombstone(t *testing.T) {
+	mr, h := setupTest(t)
+	config.TombstoneTTL = 2 * time.Second
+	config.DebugToken = "debug"
+	ctx := context.Background()
+	old, _ := dbProduct(1)
+
+	do(h, "GET", "/product/1", "")
+	if w := do(h, "PUT", "/product/1", `{"id":1,"name":"Green Apple","price":120}`); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT: got %d", w.Code)
+	}
+	if v, _ := mr.Get(redisProductKey(1)); v != redisTombstoneValue {
+		t.Fatalf("cache key after PUT: got %q, want the tombstone", v)
+	}
+
+	// Another instance that read the DB before the update tries to populate
+	// its stale copy; the tombstone keeps it out
+	populateProductCache(ctx, old, productGenerations[1], false)
+
+	w := do(h, "GET", "/product/1", "", "X-Debug", "debug")
+	var got Product
+	decodeBody(t, w, &got)
+	if got.Name != "Green Apple" || w.Header().Get("X-Data-Source") != "db" {
+		t.Fatalf("read racing the update: got %+v from %q", got, w.Header().Get("X-Data-Source"))
+	}
+	if v, _ := mr.Get(redisProductKey(1)); v != redisTombstoneValue {
+		t.Fatalf("read replaced the tombstone with %q", v)
+	}
+
+	// Once it expires, reads populate the cache again
+	mr.FastForward(config.TombstoneTTL)
+	do(h, "GET", "/product/1", "")
+	w = do(h, "GET", "/product/1", "", "X-Debug", "debug")
+	decodeBody(t, w, &got)
+	if got.Name != "Green Apple" || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("read after the tombstone expired: got %+v, X-Cache %q", got, w.Header().Get("X-Cache"))
+	}
+}
+
+func TestUpdateDeletesCacheKeyWithoutTombstoneTTL(t *testing.T) {
+	mr, h := setupTest(t)
+	config.TombstoneTTL = 0
+
+	do(h, "GET", "/product/1", "")
+	do(h, "PUT", "/product/1", `{"id":1,"name":"Green Apple","price":120}`)
+	if mr.Exists(redisProductKey(1)) {
+		t.Fatal("cache key kept after PUT with tombstones disabled")
+	}
+}
+
+func TestPaddedIDsShareOneCacheEntry(t *testing.T) {
+	mr, h := setupTest(t)
+	config.DebugToken = "debug"
+
+	if w := do(h, "GET", "/product/007", "", "X-Debug", "debug"); w.Code != http.StatusNotFound {
+		t.Fatalf("/product/007 of a missing product: got %d", w.Code)
+	}
+	do(h, "GET", "/product/1", "")
+	w := do(h, "GET", "/product/001", "", "X-Debug", "debug")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("/product/001 after /product/1: got %d, X-Cache %q", w.Code, w.Header().Get("X-Cache"))
+	}
+	for _, key := range mr.Keys() {
+		if key == "product:001" {
+			t.Fatal("padded ID used as a cache key")
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type singleflightResult = singleflight.Result
+
+// Routes that stream for as long as the client stays connected
+var requestTimeoutExempt = map[string]bool{
+	"/products/events": true,
+	"/products/export": true,
+}
+
+// Middleware - give each request a REQUEST_TIMEOUT deadline. Every wait on
+// the way (Redis commands, the DB lock, coalesced loads, idempotent retries)
+// selects on the request context, so the deadline bounds the request as a
+// whole rather than each layer adding its own timeout on top.
+func requestTimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.RequestTimeout <= 0 || requestTimeoutExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), config.RequestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Utility - report a request that ran out of its time budget. Returns false
+// for other errors.
+func writeTimeoutError(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Request timed out", http.StatusServiceUnavailable)
+	return true
+}
+
+// Utility - wait for a shared singleflight result, giving up when ctx ends.
+// The shared work carries on for the other waiters.
+func awaitShared(ctx context.Context, ch <-chan singleflightResult) (interface{}, error, bool) {
+	select {
+	case res := <-ch:
+		return res.Val, res.Err, res.Shared
+	case <-ctx.Done():
+		return nil, ctx.Err(), false
+	}
+}
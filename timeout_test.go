@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// slowRedisHook delays every command, as a struggling Redis would
+type slowRedisHook struct{ delay time.Duration }
+
+func (h slowRedisHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	sleepCtx(ctx, h.delay)
+	return ctx, nil
+}
+
+func (slowRedisHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (h slowRedisHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	sleepCtx(ctx, h.delay)
+	return ctx, nil
+}
+
+func (slowRedisHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func TestRequestTimeoutBoundsAllLayers(t *testing.T) {
+	_, h := setupTest(t)
+	config.RequestTimeout = 100 * time.Millisecond
+	config.DBLockTimeout = 2 * time.Second
+	redisClient.AddHook(slowRedisHook{delay: 60 * time.Millisecond})
+
+	// A slow cache miss, then a DB that won't answer within its own timeout
+	fakeDBLock.Lock()
+	defer fakeDBLock.Unlock()
+	start := time.Now()
+	w := do(h, "GET", "/product/1", "")
+	elapsed := time.Since(start)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want 503", w.Code)
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Fatalf("request took %v with a 100ms budget", elapsed)
+	}
+}
+
+func TestRequestTimeoutExemptsStreams(t *testing.T) {
+	setupTest(t)
+	config.RequestTimeout = time.Millisecond
+	var deadline bool
+	h := requestTimeoutMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, deadline = r.Context().Deadline()
+	}))
+	do(h, "GET", "/products/export", "")
+	if deadline {
+		t.Fatal("streaming export got a request deadline")
+	}
+	do(h, "GET", "/product/1", "")
+	if !deadline {
+		t.Fatal("product read got no request deadline")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "example.com/gorediscache"
+
+// Install an OpenTelemetry tracer provider exporting spans over OTLP/HTTP.
+// The collector endpoint and headers come from the standard
+// OTEL_EXPORTER_OTLP_* environment variables. The returned function flushes
+// and stops the provider.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not create trace exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.TraceSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(config.TracingServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// Middleware - with TRACING, run each request in a server span, continuing
+// a trace from an incoming traceparent header. A sampled request's trace ID
+// is returned in X-Trace-Id and in error bodies, so a client reporting a
+// problem can quote it. Wraps the whole middleware chain, so rejections
+// before routing are traced too; the span is named after the method until
+// traceRouteMiddleware finds the route.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.Tracing {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(tracerName).Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPMethod(r.Method)))
+		defer span.End()
+
+		if traceID := traceIDFromContext(ctx); traceID != "" {
+			w.Header().Set("X-Trace-Id", traceID)
+			ctx = context.WithValue(ctx, errorBodyTraceKey{}, &errorBodyTrace{traceID: traceID})
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		serveWithTraceIDBody(next, sw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCode(sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// Middleware - name the request's span after the route it matched
+func traceRouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tmpl, err := cur.GetPathTemplate(); err == nil {
+				span := trace.SpanFromContext(r.Context())
+				span.SetName(r.Method + " " + tmpl)
+				span.SetAttributes(semconv.HTTPRoute(tmpl))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware - end plain text error bodies with the trace ID. Sits inside
+// compressionMiddleware so the line goes in before the body is gzipped;
+// tracingMiddleware covers the errors of the middleware outside it.
+func traceErrorBodyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveWithTraceIDBody(next, w, r)
+	})
+}
+
+type errorBodyTraceKey struct{}
+
+// errorBodyTrace is the trace ID of a request and whether its error body
+// already quotes it
+type errorBodyTrace struct {
+	traceID string
+	added   bool
+}
+
+// errorBodyWriter notes whether a response is an uncompressed plain text
+// error, like http.Error writes, that a line can be appended to
+type errorBodyWriter struct {
+	http.ResponseWriter
+	plainError bool
+}
+
+func (e *errorBodyWriter) WriteHeader(status int) {
+	h := e.Header()
+	e.plainError = status >= http.StatusBadRequest && strings.HasPrefix(h.Get("Content-Type"), "text/plain") &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Length") == ""
+	e.ResponseWriter.WriteHeader(status)
+}
+
+func (e *errorBodyWriter) Flush() {
+	if f, ok := e.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Serve r through next and, for a traced request answered with a plain text
+// error, add a "trace_id: <id>" line to the body unless an inner call has
+func serveWithTraceIDBody(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	bt, _ := r.Context().Value(errorBodyTraceKey{}).(*errorBodyTrace)
+	if bt == nil || bt.added {
+		next.ServeHTTP(w, r)
+		return
+	}
+	ew := &errorBodyWriter{ResponseWriter: w}
+	next.ServeHTTP(ew, r)
+	if ew.plainError && !bt.added {
+		bt.added = true
+		fmt.Fprintf(w, "trace_id: %s\n", bt.traceID)
+	}
+}
+
+// Utility - the trace ID of the sampled span in ctx, or "" when the request
+// isn't being traced
+func traceIDFromContext(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
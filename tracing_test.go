@@ -0,0 +1,154 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Install a tracer provider recording spans in memory for the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	sr := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return sr
+}
+
+func TestErrorResponseCarriesTraceID(t *testing.T) {
+	_, h := setupTest(t)
+	sr := recordSpans(t)
+
+	if w := do(h, "GET", "/product/99", ""); w.Header().Get("X-Trace-Id") != "" {
+		t.Fatal("X-Trace-Id set with tracing disabled")
+	}
+
+	config.Tracing = true
+	w := do(h, "GET", "/product/99", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got %d", w.Code)
+	}
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if want := spans[0].SpanContext().TraceID().String(); w.Header().Get("X-Trace-Id") != want {
+		t.Fatalf("X-Trace-Id %q, want the span's trace %s", w.Header().Get("X-Trace-Id"), want)
+	}
+	if spans[0].Name() != "GET /product/{id:[0-9]+}" {
+		t.Fatalf("span name: %q", spans[0].Name())
+	}
+}
+
+func TestTraceContinuedFromTraceparent(t *testing.T) {
+	_, h := setupTest(t)
+	recordSpans(t)
+	config.Tracing = true
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(prev) })
+
+	w := do(h, "GET", "/product/1", "", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if got := w.Header().Get("X-Trace-Id"); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("X-Trace-Id %q, want the incoming trace", got)
+	}
+}
+
+func TestMetricsExemplarCarriesTraceID(t *testing.T) {
+	setupTest(t)
+	recordSpans(t)
+	config.Tracing = true
+	config.MetricsExemplars = true
+	rec, metricsHandler, err := newRecorder(Config{MetricsBackend: metricsBackendPrometheus, MetricsExemplars: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics = rec
+	h := newHandler(rec, metricsHandler)
+
+	traceID := do(h, "GET", "/product/99", "").Header().Get("X-Trace-Id")
+	if traceID == "" {
+		t.Fatal("traced request without X-Trace-Id")
+	}
+	w := do(h, "GET", "/metrics", "", "Accept", "application/openmetrics-text; version=0.0.1")
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/openmetrics-text") {
+		t.Fatalf("/metrics content type: %q", w.Header().Get("Content-Type"))
+	}
+	if want := `# {trace_id="` + traceID + `"}`; !strings.Contains(w.Body.String(), want) {
+		t.Fatalf("OpenMetrics scrape lacks the exemplar %s:\n%s", want, w.Body)
+	}
+	// The classic text format has no exemplars
+	if body := do(h, "GET", "/metrics", "").Body.String(); strings.Contains(body, "trace_id") {
+		t.Fatalf("text scrape carries an exemplar:\n%s", body)
+	}
+}
+
+func TestErrorBodiesQuoteTraceID(t *testing.T) {
+	_, h := setupTest(t)
+	recordSpans(t)
+	config.Tracing = true
+
+	w := do(h, "GET", "/product/99", "")
+	traceID := w.Header().Get("X-Trace-Id")
+	if traceID == "" || !strings.HasSuffix(w.Body.String(), "trace_id: "+traceID+"\n") {
+		t.Fatalf("plain text 404: X-Trace-Id %q, body %q", traceID, w.Body.String())
+	}
+
+	var problem Problem
+	w = do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":-5}`, "Accept", contentTypeProblem)
+	decodeBody(t, w, &problem)
+	if problem.TraceID == "" || problem.TraceID != w.Header().Get("X-Trace-Id") {
+		t.Fatalf("problem trace_id %q, X-Trace-Id %q", problem.TraceID, w.Header().Get("X-Trace-Id"))
+	}
+
+	// Gzipped errors quote it inside the compressed body, once
+	config.Compression = true
+	w = do(h, "GET", "/product/99", "", "Accept-Encoding", encodingGzip)
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if strings.Count(string(body), "trace_id: ") != 1 || !strings.HasSuffix(string(body), w.Header().Get("X-Trace-Id")+"\n") {
+		t.Fatalf("gzipped 404 body %q", body)
+	}
+}
+
+func TestRejectionsBeforeRoutingAreTraced(t *testing.T) {
+	_, h := setupTest(t)
+	sr := recordSpans(t)
+	config.Tracing = true
+	config.MaxURLLength = 20
+
+	for _, req := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/product/1?padding=xxxxxxxx", http.StatusRequestURITooLong},
+		{"GET", "/no-such-route", http.StatusNotFound},
+		{"PATCH", "/product/1", http.StatusMethodNotAllowed},
+	} {
+		w := do(h, req.method, req.path, "")
+		traceID := w.Header().Get("X-Trace-Id")
+		if w.Code != req.status || traceID == "" {
+			t.Fatalf("%s %s: got %d, X-Trace-Id %q", req.method, req.path, w.Code, traceID)
+		}
+		// The router's 405 has no body to quote it in
+		if w.Body.Len() > 0 && !strings.HasSuffix(w.Body.String(), "trace_id: "+traceID+"\n") {
+			t.Fatalf("%s %s: body %q", req.method, req.path, w.Body.String())
+		}
+	}
+	for _, span := range sr.Ended() {
+		if strings.Contains(span.Name(), "/") {
+			t.Fatalf("unrouted request span named %q, want just the method", span.Name())
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Status codes for business-rule validation failures. Malformed input
+// (unparseable JSON, bad IDs) is always 400.
+const (
+	validationStatus400 = 400
+	validationStatus422 = 422
+)
+
+// ValidationError reports a well-formed product that breaks a business rule
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// Check and normalize a product before it's stored
+func validateProduct(input Product) (Product, error) {
+	name, err := normalizeProductName(input.Name)
+	if err != nil {
+		return Product{}, err
+	}
+	input.Name = name
+	if input.Price < 0 {
+		return Product{}, &ValidationError{Field: "price", Reason: "must not be negative"}
+	}
+	return input, nil
+}
+
+// Utility - write err as a validation failure if it is one, with the status
+// from VALIDATION_STATUS. Clients accepting application/problem+json get an
+// RFC 7807 problem naming the field; others a plain text message.
+func writeValidationError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		return false
+	}
+	status := http.StatusBadRequest
+	if config.ValidationStatus == validationStatus422 {
+		status = http.StatusUnprocessableEntity
+	}
+	if wantsProblem(r) {
+		writeProblem(w, Problem{
+			Type:     problemTypeValidation,
+			Title:    "Invalid product",
+			Status:   status,
+			Detail:   verr.Error(),
+			Instance: r.URL.Path,
+			Errors:   []ProblemFieldError{{Field: verr.Field, Reason: verr.Reason}},
+		})
+		return true
+	}
+	http.Error(w, "Invalid "+verr.Error(), status)
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNameWhitespace(t *testing.T) {
+	for _, tc := range []struct {
+		mode   string
+		status int
+		stored string
+	}{
+		{nameWhitespaceTrim, http.StatusNoContent, "Green Apple"},
+		{nameWhitespaceKeep, http.StatusNoContent, "  Green Apple  "},
+		{nameWhitespaceReject, http.StatusBadRequest, "Apple"},
+	} {
+		_, h := setupTest(t)
+		config.NameWhitespace = tc.mode
+
+		if w := do(h, "PUT", "/product/1", `{"id":1,"name":"  Green Apple  ","price":120}`); w.Code != tc.status {
+			t.Fatalf("%s: PUT of a padded name got %d, want %d", tc.mode, w.Code, tc.status)
+		}
+		if p, _ := dbProduct(1); p.Name != tc.stored {
+			t.Fatalf("%s: stored name %q, want %q", tc.mode, p.Name, tc.stored)
+		}
+		w := do(h, "POST", "/product", `{"name":" Date ","price":5}`)
+		if tc.mode == nameWhitespaceReject {
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("%s: POST of a padded name got %d, want 400", tc.mode, w.Code)
+			}
+			continue
+		}
+		var created Product
+		decodeBody(t, w, &created)
+		if want := map[string]string{nameWhitespaceTrim: "Date", nameWhitespaceKeep: " Date "}[tc.mode]; created.Name != want {
+			t.Fatalf("%s: created name %q, want %q", tc.mode, created.Name, want)
+		}
+	}
+}
+
+func TestValidationStatus(t *testing.T) {
+	for _, tc := range []struct {
+		setting, want int
+	}{
+		{validationStatus400, http.StatusBadRequest},
+		{validationStatus422, http.StatusUnprocessableEntity},
+	} {
+		_, h := setupTest(t)
+		config.ValidationStatus = tc.setting
+
+		if w := do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":-5}`); w.Code != tc.want {
+			t.Fatalf("VALIDATION_STATUS=%d: negative price got %d, want %d", tc.setting, w.Code, tc.want)
+		}
+		if w := do(h, "POST", "/product", `{"name":"Date","price":-5}`); w.Code != tc.want {
+			t.Fatalf("VALIDATION_STATUS=%d: negative price on create got %d, want %d", tc.setting, w.Code, tc.want)
+		}
+		// Parse failures are always 400
+		if w := do(h, "PUT", "/product/1", `{"id":1,"name":`); w.Code != http.StatusBadRequest {
+			t.Fatalf("VALIDATION_STATUS=%d: malformed JSON got %d, want 400", tc.setting, w.Code)
+		}
+	}
+}
+
+func TestValidationProblemDetails(t *testing.T) {
+	_, h := setupTest(t)
+
+	w := do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":-5}`, "Accept", contentTypeProblem)
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != contentTypeProblem {
+		t.Fatalf("got %d %q, want 400 %s", w.Code, w.Header().Get("Content-Type"), contentTypeProblem)
+	}
+	var p Problem
+	decodeBody(t, w, &p)
+	if p.Type != problemTypeValidation || p.Title == "" || p.Status != http.StatusBadRequest || p.Detail == "" || p.Instance != "/product/1" {
+		t.Fatalf("problem members: got %+v", p)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Field != "price" || p.Errors[0].Reason == "" {
+		t.Fatalf("field errors: got %+v", p.Errors)
+	}
+
+	// The status in the body follows VALIDATION_STATUS like the response's
+	config.ValidationStatus = validationStatus422
+	decodeBody(t, do(h, "POST", "/product", `{"name":"Date","price":-5}`, "Accept", contentTypeProblem), &p)
+	if p.Status != http.StatusUnprocessableEntity || p.Instance != "/product" {
+		t.Fatalf("problem on create: got %+v", p)
+	}
+
+	// Other clients keep the plain error
+	w = do(h, "PUT", "/product/1", `{"id":1,"name":"Apple","price":-5}`)
+	if strings.HasPrefix(w.Header().Get("Content-Type"), contentTypeProblem) || !strings.Contains(w.Body.String(), "price") {
+		t.Fatalf("plain error: got %q %s", w.Header().Get("Content-Type"), w.Body)
+	}
+	if p, _ := dbProduct(1); p.Name != "Apple" || p.Price != 100 {
+		t.Fatalf("invalid update applied: %+v", p)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Write-behind state: a hash of product ID to the latest product waiting to
+// be written to the DB, a queue of IDs with a pending write, and the IDs a
+// worker has taken but not finished
+const (
+	redisWriteBehindPendingKey    = "writebehind:pending"
+	redisWriteBehindQueueKey      = "writebehind:queue"
+	redisWriteBehindProcessingKey = "writebehind:processing"
+)
+
+// errWriteBehindSuperseded means a queued write was dropped (by a delete or
+// a bulk update) while the worker was waiting to store it
+var errWriteBehindSuperseded = errors.New("pending write was superseded")
+
+// errWriteBehindReplaced means a newer write for the product coalesced into
+// the pending entry while the worker was waiting to store the one it read;
+// the newer one is written on its own turn instead
+var errWriteBehindReplaced = errors.New("pending write was replaced by a newer one")
+
+// errWriteBehindUnchecked means Redis couldn't confirm the write was still
+// pending; it is retried like a busy DB
+var errWriteBehindUnchecked = errors.New("could not check the pending write")
+
+// Record the latest pending write for ARGV[1] and queue the ID unless a
+// write for it is already queued, so repeated writes to one product
+// coalesce into a single DB write. ARGV[3] is the version of the pending
+// write the caller based its version on (0 for none); if another write has
+// replaced it since, nothing is stored and -1 is returned, so two writes
+// can't both take the same version. Otherwise returns 1 if the ID was
+// queued, 0 if it already was.
+var queueWriteBehindScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+local version = 0
+if current then
+	version = cjson.decode(current).version
+end
+if version ~= tonumber(ARGV[3]) then
+	return -1
+end
+if redis.call('HSET', KEYS[1], ARGV[1], ARGV[2]) == 1 then
+	redis.call('LPUSH', KEYS[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// How often saveProductWriteBehind recomputes a version that concurrent
+// writes to the same product took first
+const writeBehindVersionAttempts = 5
+
+// errWriteBehindContended means concurrent writes to one product kept
+// taking the version a write-behind save computed
+var errWriteBehindContended = errors.New("too many concurrent writes to the product")
+
+// Finish a write of ARGV[1] taken from the processing list. If the pending
+// write is still the one applied (ARGV[2]) it's done; if a newer one arrived
+// meanwhile, the ID is queued again so that one gets written too.
+var finishWriteBehindScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+else
+	redis.call('RPUSH', KEYS[2], ARGV[1])
+end
+redis.call('LREM', KEYS[3], 1, ARGV[1])
+return 1
+`)
+
+// Save a validated product under CACHE_UPDATE_MODE=write_behind: store it in
+// the cache straight away and queue the DB write for the background worker.
+// The version is the one the DB will hold once the write is applied: one
+// past the pending write or, without one, the DB's. It is computed and
+// stored in one step by queueWriteBehindScript.
+func saveProductWriteBehind(ctx context.Context, input Product) (Product, error) {
+	for attempt := 0; attempt < writeBehindVersionAttempts; attempt++ {
+		pendingVersion, pending := pendingWriteBehindVersion(ctx, input.ID)
+		if err := rlockDB(ctx); err != nil {
+			return Product{}, err
+		}
+		existing, inDB := fakeProductDB[input.ID]
+		dbVersion := 0
+		if inDB {
+			dbVersion = existing.Version
+		}
+		// Refuse up front what the worker would refuse once applied
+		full := !inDB && !pending && productLimitReachedLocked()
+		fakeDBLock.RUnlock()
+		if full {
+			return Product{}, errProductLimitReached
+		}
+
+		eventType := "updated"
+		if !inDB && !pending {
+			eventType = "created"
+		}
+		updated := input
+		updated.Version = dbVersion + 1
+		if pending && pendingVersion >= dbVersion {
+			updated.Version = pendingVersion + 1
+		}
+		raw, _ := json.Marshal(updated)
+		queued, err := queueWriteBehindScript.Run(ctx, redisClient,
+			[]string{redisWriteBehindPendingKey, redisWriteBehindQueueKey},
+			strconv.Itoa(updated.ID), raw, pendingVersion).Int()
+		if err != nil {
+			return Product{}, err
+		}
+		if queued < 0 {
+			continue // another write took this version first
+		}
+		if queued == 0 {
+			metrics.IncrCounter("write_behind_coalesced_total", nil)
+		}
+		writeThroughProductCache(ctx, updated)
+		publishProductEvent(eventType, updated.ID, &updated)
+		return updated, nil
+	}
+	return Product{}, errWriteBehindContended
+}
+
+// Drop a product's pending write, e.g. because it's being deleted. Returns
+// whether there was one.
+func discardWriteBehind(ctx context.Context, id int) bool {
+	if config.CacheUpdateMode != cacheUpdateWriteBehind {
+		return false
+	}
+	n, _ := redisClient.HDel(ctx, redisWriteBehindPendingKey, strconv.Itoa(id)).Result()
+	return n > 0
+}
+
+// Versions of the pending writes for ids, for those that have one
+func pendingWriteBehindVersions(ctx context.Context, ids []int) map[int]int {
+	versions := map[int]int{}
+	if config.CacheUpdateMode != cacheUpdateWriteBehind || len(ids) == 0 {
+		return versions
+	}
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = strconv.Itoa(id)
+	}
+	values, err := redisClient.HMGet(ctx, redisWriteBehindPendingKey, fields...).Result()
+	if err != nil {
+		return versions
+	}
+	for i, v := range values {
+		var product Product
+		if raw, ok := v.(string); ok && json.Unmarshal([]byte(raw), &product) == nil {
+			versions[ids[i]] = product.Version
+		}
+	}
+	return versions
+}
+
+// The version of a product's pending write, if it has one
+func pendingWriteBehindVersion(ctx context.Context, id int) (int, bool) {
+	if config.CacheUpdateMode != cacheUpdateWriteBehind {
+		return 0, false
+	}
+	raw, err := redisClient.HGet(ctx, redisWriteBehindPendingKey, strconv.Itoa(id)).Bytes()
+	if err != nil {
+		return 0, false
+	}
+	var product Product
+	if json.Unmarshal(raw, &product) != nil {
+		return 0, false
+	}
+	return product.Version, true
+}
+
+// Background goroutine - write queued products to the DB. IDs stay on the
+// processing list until their write is finished, and on startup leftovers
+// from a worker that died mid-write are requeued, so the queue is drained
+// across restarts.
+func runWriteBehindWorker(ctx context.Context) {
+	for {
+		err := redisClient.RPopLPush(ctx, redisWriteBehindProcessingKey, redisWriteBehindQueueKey).Err()
+		if errors.Is(err, redis.Nil) {
+			break
+		}
+		if err != nil {
+			log.Printf("Could not requeue unfinished write-behind writes: %v", err)
+			break
+		}
+	}
+	for ctx.Err() == nil {
+		idStr, err := redisClient.BRPopLPush(ctx, redisWriteBehindQueueKey, redisWriteBehindProcessingKey, asyncWritePollTimeout).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Write-behind queue error: %v", err)
+				sleepCtx(ctx, asyncWriteRetryDelay)
+			}
+			continue
+		}
+		applyWriteBehind(ctx, idStr)
+	}
+}
+
+// Write the pending product for one queued ID to the DB
+func applyWriteBehind(ctx context.Context, idStr string) {
+	raw, err := redisClient.HGet(ctx, redisWriteBehindPendingKey, idStr).Result()
+	if errors.Is(err, redis.Nil) {
+		// Discarded by a delete, or already written by another worker
+		redisClient.LRem(ctx, redisWriteBehindProcessingKey, 1, idStr)
+		return
+	}
+	if err != nil {
+		log.Printf("Write-behind read of product %s failed: %v", idStr, err)
+		sleepCtx(ctx, asyncWriteRetryDelay)
+		return // stays on the processing list for the next restart
+	}
+	var product Product
+	if err := json.Unmarshal([]byte(raw), &product); err != nil {
+		log.Printf("Dropping malformed write-behind write for product %s: %v", idStr, err)
+		redisClient.HDel(ctx, redisWriteBehindPendingKey, idStr)
+		redisClient.LRem(ctx, redisWriteBehindProcessingKey, 1, idStr)
+		return
+	}
+
+	err = storeWriteBehindProduct(ctx, product, raw)
+	if errors.Is(err, errWriteBehindSuperseded) {
+		metrics.IncrCounter("write_behind_writes_total", Labels{"result": "superseded"})
+		redisClient.LRem(ctx, redisWriteBehindProcessingKey, 1, idStr)
+		return
+	}
+	if errors.Is(err, errWriteBehindReplaced) {
+		// The newer write didn't queue the ID again, as it was still
+		// queued for this one; finishing requeues it
+		metrics.IncrCounter("write_behind_writes_total", Labels{"result": "replaced"})
+		finishWriteBehindScript.Run(ctx, redisClient,
+			[]string{redisWriteBehindPendingKey, redisWriteBehindQueueKey, redisWriteBehindProcessingKey},
+			idStr, raw)
+		return
+	}
+	if errors.Is(err, errDBLockTimeout) || errors.Is(err, context.Canceled) || errors.Is(err, errWriteBehindUnchecked) {
+		redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LRem(ctx, redisWriteBehindProcessingKey, 1, idStr)
+			pipe.RPush(ctx, redisWriteBehindQueueKey, idStr)
+			return nil
+		})
+		sleepCtx(ctx, asyncWriteRetryDelay)
+		return
+	}
+	if err != nil {
+		// The cache shows a write the DB refused; drop it so reads see the DB
+		log.Printf("Write-behind write of product %d rejected, discarding it: %v", product.ID, err)
+		metrics.IncrCounter("write_behind_writes_total", Labels{"result": "rejected"})
+		invalidateProductCache(ctx, product.ID)
+	} else {
+		metrics.IncrCounter("write_behind_writes_total", Labels{"result": "applied"})
+	}
+	finishWriteBehindScript.Run(ctx, redisClient,
+		[]string{redisWriteBehindPendingKey, redisWriteBehindQueueKey, redisWriteBehindProcessingKey},
+		idStr, raw)
+}
+
+// Store a product queued by saveProductWriteBehind, keeping the version it
+// was cached with unless the DB has since moved past it. raw is the pending
+// entry it was read from: writes that drop pending entries do so under the
+// DB lock, so checking it is still there once the lock is held keeps a
+// dropped write from landing after the one that replaced it. An entry that
+// changed holds a newer write, and only that one is stored.
+func storeWriteBehindProduct(ctx context.Context, product Product, raw string) error {
+	if err := lockDB(ctx); err != nil {
+		return err
+	}
+	defer fakeDBLock.Unlock()
+	current, err := redisClient.HGet(ctx, redisWriteBehindPendingKey, strconv.Itoa(product.ID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return errWriteBehindSuperseded
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errWriteBehindUnchecked, err)
+	}
+	if current != raw {
+		return errWriteBehindReplaced
+	}
+	if err := checkCatalogValueLocked(product); err != nil {
+		return err
+	}
+	existing, ok := fakeProductDB[product.ID]
+	if !ok && productLimitReachedLocked() {
+		return errProductLimitReached
+	}
+	if ok && existing.Version >= product.Version {
+		product.Version = existing.Version + 1
+	}
+	fakeProductDB[product.ID] = &product
+	bumpGenerationLocked(product.ID)
+	metrics.SetGauge("products_in_db", float64(len(fakeProductDB)), nil)
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestConcurrentWriteBehindSavesGetDistinctVersions(t *testing.T) {
+	setupTest(t)
+	config.CacheUpdateMode = cacheUpdateWriteBehind
+	ctx := context.Background()
+
+	const writers = 4
+	versions := make(chan int, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			updated, err := saveProduct(ctx, Product{ID: 1, Name: "Apple", Price: 100})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			versions <- updated.Version
+		}()
+	}
+	wg.Wait()
+	close(versions)
+
+	seen := map[int]bool{}
+	for v := range versions {
+		if seen[v] {
+			t.Fatalf("two concurrent writes both got version %d", v)
+		}
+		seen[v] = true
+	}
+	if pending, _ := pendingWriteBehindVersion(ctx, 1); pending != 1+writers {
+		t.Fatalf("pending write has version %d, want %d", pending, 1+writers)
+	}
+}
+
+func TestWriteBehindVersionFollowsPendingWrite(t *testing.T) {
+	setupTest(t)
+	config.CacheUpdateMode = cacheUpdateWriteBehind
+	ctx := context.Background()
+
+	first, err := saveProduct(ctx, Product{ID: 1, Name: "Apple", Price: 110})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cache entry has expired; the next version still follows the
+	// pending write, not the DB
+	redisClient.Del(ctx, redisProductKey(1))
+	second, err := saveProduct(ctx, Product{ID: 1, Name: "Apple", Price: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 2 || second.Version != 3 {
+		t.Fatalf("versions %d then %d, want 2 then 3", first.Version, second.Version)
+	}
+}
+
+// writeAfterReadHook runs write once, right after the worker's first read of
+// a pending write-behind entry
+type writeAfterReadHook struct {
+	done  int32
+	write func()
+}
+
+func (h *writeAfterReadHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *writeAfterReadHook) AfterProcess(_ context.Context, cmd redis.Cmder) error {
+	if cmd.Name() == "hget" && cmd.Args()[1] == redisWriteBehindPendingKey {
+		// The write reads the entry too; only the first read triggers it
+		if atomic.CompareAndSwapInt32(&h.done, 0, 1) {
+			h.write()
+		}
+	}
+	return nil
+}
+
+func (h *writeAfterReadHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *writeAfterReadHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func TestWriteBehindWriteCoalescedMidApplyIsStored(t *testing.T) {
+	setupTest(t)
+	config.CacheUpdateMode = cacheUpdateWriteBehind
+	ctx := context.Background()
+
+	if _, err := saveProduct(ctx, Product{ID: 1, Name: "Apple", Price: 110}); err != nil {
+		t.Fatal(err)
+	}
+	// A newer write lands between the worker's read and its store; it
+	// coalesces into the pending entry, so it doesn't queue the ID again
+	redisClient.AddHook(&writeAfterReadHook{write: func() {
+		if _, err := saveProduct(ctx, Product{ID: 1, Name: "Green Apple", Price: 120}); err != nil {
+			t.Error(err)
+		}
+	}})
+	for i := 0; i < 2; i++ {
+		idStr, err := redisClient.RPopLPush(ctx, redisWriteBehindQueueKey, redisWriteBehindProcessingKey).Result()
+		if err != nil {
+			t.Fatalf("pass %d: queue empty with a write pending: %v", i+1, err)
+		}
+		applyWriteBehind(ctx, idStr)
+	}
+
+	if p, _ := dbProduct(1); p.Name != "Green Apple" || p.Version != 3 {
+		t.Fatalf("DB after both passes: got %+v, want the newer write at version 3", p)
+	}
+	if n, _ := redisClient.HLen(ctx, redisWriteBehindPendingKey).Result(); n != 0 {
+		t.Fatal("pending entry left after the newer write was stored")
+	}
+	if n, _ := redisClient.LLen(ctx, redisWriteBehindProcessingKey).Result(); n != 0 {
+		t.Fatal("ID left on the processing list")
+	}
+}